@@ -0,0 +1,159 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/negbie/logp"
+	"github.com/negbie/multiconfig"
+	"github.com/sipcapture/heplify-server/config"
+	"github.com/sipcapture/heplify-server/pcap"
+	"github.com/sipcapture/heplify-server/rotator"
+	input "github.com/sipcapture/heplify-server/server"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [options] file.pcap [file.pcapng ...]\n\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "Replays SIP from capture files through the heplify-server pipeline.\n\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	cfgFile := flag.String("config", "./heplify-server.toml", "heplify-server config file")
+	nodeID := flag.Uint("nodeid", 0, "HEP NodeID of the imported packets")
+	nodeName := flag.String("nodename", "pcap-import", "HEP NodeName of the imported packets")
+	nodePW := flag.String("nodepw", "", "HEP NodePW if HEPAuthEnable is set")
+	logLvl := flag.String("loglvl", "info", "log level")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	cfg := new(config.HeplifyServer)
+	loaders := []multiconfig.Loader{&multiconfig.TagLoader{}}
+	if _, err := os.Stat(*cfgFile); err == nil {
+		loaders = append(loaders, &multiconfig.TOMLLoader{Path: *cfgFile})
+	}
+	if err := multiconfig.MultiLoader(loaders...).Load(cfg); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	cfg.Config = *cfgFile
+	config.Setting = *cfg
+	config.Setting.AlegIDs = config.GenerateRegexMap(config.Setting.AlegIDs)
+
+	// Only the outputs are needed, no listeners.
+	config.Setting.HEPAddr = ""
+	config.Setting.HEPTCPAddr = ""
+	config.Setting.HEPTLSAddr = ""
+	config.Setting.HEPWSAddr = ""
+	config.Setting.HEPHTTPAddr = ""
+
+	toStderr := true
+	logging := logp.Logging{Level: *logLvl}
+	logp.ToStderr = &toStderr
+	logp.DebugSelectorsStr = &config.Setting.LogDbg
+	if err := logp.Init("heplify-import", &logging); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	dec := pcap.NewDecoder()
+	dec.NodeID = uint32(*nodeID)
+	dec.NodeName = *nodeName
+	dec.NodePW = *nodePW
+
+	var parts *partitions
+	if len(config.Setting.DBAddr) > 2 && config.Setting.DBRotate &&
+		(config.Setting.DBDriver == "mysql" || config.Setting.DBDriver == "postgres") {
+		parts = &partitions{r: rotator.Setup(nil), days: make(map[int]bool)}
+	}
+
+	src := make(chan []byte, 1024)
+	failed := false
+	var frames, messages uint64
+	go func() {
+		defer close(src)
+		for _, name := range flag.Args() {
+			n, m, err := importFile(name, dec, parts, src)
+			frames += n
+			messages += m
+			if err != nil {
+				logp.Err("%s: %v", name, err)
+				failed = true
+				continue
+			}
+			logp.Info("%s: %d SIP messages from %d frames", name, m, n)
+		}
+	}()
+
+	input.NewHEPInput().Replay(src)
+	logp.Info("imported %d SIP messages from %d frames", messages, frames)
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func importFile(name string, dec *pcap.Decoder, parts *partitions, src chan<- []byte) (frames, messages uint64, err error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	r, err := pcap.NewReader(f)
+	if err != nil {
+		return 0, 0, err
+	}
+	for {
+		p, err := r.ReadPacket()
+		if err == io.EOF {
+			return frames, messages, nil
+		} else if err != nil {
+			return frames, messages, err
+		}
+		frames++
+		for _, h := range dec.Decode(p) {
+			pkt, err := h.MarshalHEP3()
+			if err != nil {
+				logp.Warn("%s: skip SIP message from %s:%d: %v", name, h.SrcIP, h.SrcPort, err)
+				continue
+			}
+			parts.ensure(h.Timestamp)
+			src <- pkt
+			messages++
+		}
+	}
+}
+
+// partitions creates the rotator partitions of the capture days, the
+// rotator itself only covers yesterday until tomorrow.
+type partitions struct {
+	r    *rotator.Rotator
+	days map[int]bool
+}
+
+func (p *partitions) ensure(t time.Time) {
+	if p == nil {
+		return
+	}
+	y, m, d := t.Local().Date()
+	ny, nm, nd := time.Now().Date()
+	day := int(time.Date(y, m, d, 12, 0, 0, 0, time.UTC).Sub(time.Date(ny, nm, nd, 12, 0, 0, 0, time.UTC)).Hours() / 24)
+	if p.days[day] {
+		return
+	}
+	p.days[day] = true
+
+	if drop := config.Setting.DBDropDays; drop > 0 && -day >= drop {
+		logp.Warn("packets from %s are older than DBDropDays and will be dropped by the rotator", t.Format("2006-01-02"))
+	}
+	if err := p.r.CreateDataTables(day); err != nil {
+		logp.Err("failed to create partitions for %s: %v", t.Format("2006-01-02"), err)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sipcapture/heplify-server/database"
+	"github.com/sipcapture/heplify-server/pcap"
+)
+
+// exportPcap implements the export subcommand:
+//
+//	heplify-server -config heplify-server.toml export -from 2020-01-02T00:00:00Z callid1 callid2
+func exportPcap(args []string) int {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	from := fs.String("from", "", "start of the time range, RFC 3339 or Unix seconds (default 24h before -to)")
+	to := fs.String("to", "", "end of the time range, RFC 3339 or Unix seconds (default now)")
+	out := fs.String("o", "", "output file, - for stdout (default <first Call-ID>.pcapng)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [server options] export [options] Call-ID|correlation-ID ...\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	var ids []string
+	for _, a := range fs.Args() {
+		for _, id := range strings.Split(a, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				ids = append(ids, id)
+			}
+		}
+	}
+	if len(ids) == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	t2, err := database.ParseExportTime(*to, time.Now())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	t1, err := database.ParseExportTime(*from, t2.Add(-24*time.Hour))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	if err := database.CheckExport(ids, t1, t2); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	pkts, err := database.FindSIP(ids, t1, t2)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if len(pkts) == 0 {
+		fmt.Fprintf(os.Stderr, "no packets found between %s and %s\n", t1.Format(time.RFC3339), t2.Format(time.RFC3339))
+		return 1
+	}
+
+	var w io.Writer = os.Stdout
+	name := *out
+	if name == "" {
+		name = ids[0] + ".pcapng"
+	}
+	if name != "-" {
+		f, err := os.Create(name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := pcap.WriteNg(w, pkts); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if name != "-" {
+		fmt.Fprintf(os.Stderr, "wrote %d packets to %s\n", len(pkts), name)
+	}
+	return 0
+}
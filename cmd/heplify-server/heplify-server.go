@@ -1,11 +1,14 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"html/template"
+	"io"
 	"net/http"
 	"os"
 	"os/signal"
+	"reflect"
 	"strings"
 	"sync"
 	"syscall"
@@ -76,6 +79,35 @@ func tomlExists(f string) bool {
 	return err == nil
 }
 
+// subcommand returns the first argument after the server flags and the
+// arguments which follow it. The flags are parsed like multiconfig does,
+// so a flag value is never taken as subcommand.
+func subcommand(args []string) (string, []string) {
+	fs := flag.NewFlagSet("heplify-server", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	t := reflect.TypeOf(config.HeplifyServer{})
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.IsExported() {
+			fs.Var(&argValue{isBool: f.Type.Kind() == reflect.Bool}, strings.ToLower(f.Name), "")
+		}
+	}
+	if fs.Parse(args) != nil || fs.NArg() == 0 {
+		return "", nil
+	}
+	return fs.Arg(0), fs.Args()[1:]
+}
+
+// argValue accepts any flag value, it only tells the parser whether a
+// flag needs a value.
+type argValue struct {
+	isBool bool
+}
+
+func (v *argValue) String() string   { return "" }
+func (v *argValue) Set(string) error { return nil }
+func (v *argValue) IsBoolFlag() bool { return v.isBool }
+
 func main() {
 	var servers []server
 	var wg sync.WaitGroup
@@ -87,6 +119,13 @@ func main() {
 		os.Exit(0)
 	}
 
+	switch cmd, args := subcommand(os.Args[1:]); cmd {
+	case "export":
+		os.Exit(exportPcap(args))
+	case "script-test":
+		os.Exit(scriptTest(args))
+	}
+
 	startServer := func() {
 		hep := input.NewHEPInput()
 		servers = []server{hep}
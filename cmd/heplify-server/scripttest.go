@@ -0,0 +1,98 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/sipcapture/heplify-server/config"
+	"github.com/sipcapture/heplify-server/decoder"
+	"github.com/sipcapture/heplify-server/enrich"
+	"github.com/sipcapture/heplify-server/scripttest"
+)
+
+// scriptTest implements the script-test subcommand:
+//
+//	heplify-server -config heplify-server.toml script-test [-update] invite.sip call.pcap
+func scriptTest(args []string) int {
+	fs := flag.NewFlagSet("script-test", flag.ExitOnError)
+	folder := fs.String("folder", config.Setting.ScriptFolder, "script folder")
+	engine := fs.String("engine", config.Setting.ScriptEngine, "script engine, lua or expr")
+	update := fs.Bool("update", false, "write the reports to the expected files")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [server options] script-test [options] fixture ...\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Runs the scripts against SIP text, HEP JSON or pcap fixtures and prints what they changed.\n")
+		fmt.Fprintf(os.Stderr, "A report is checked against fixture%s when it exists.\n\n", scripttest.ExpectedSuffix)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	config.Setting.ScriptFolder = *folder
+	config.Setting.ScriptEngine = *engine
+	config.Setting.Dedup = false
+	if config.Setting.EnrichFolder != "" || config.Setting.EnrichGeoIPFile != "" {
+		d, err := enrich.Load(config.Setting.EnrichFolder, config.Setting.EnrichGeoIPFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		enrich.Store(d)
+	}
+	script, err := decoder.NewScriptEngine()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer script.Close()
+
+	failed := 0
+	for _, name := range fs.Args() {
+		pkts, err := scripttest.Load(name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			failed++
+			continue
+		}
+		report, err := scripttest.Run(script, pkts)
+		fmt.Printf("== %s\n%s", name, report)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "FAIL %s: %v\n", name, err)
+			failed++
+			continue
+		}
+
+		expected := name + scripttest.ExpectedSuffix
+		if *update {
+			if err := os.WriteFile(expected, []byte(report), 0644); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				failed++
+			}
+			continue
+		}
+		want, err := os.ReadFile(expected)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			failed++
+			continue
+		}
+		if diff := scripttest.Compare(string(want), report); len(diff) > 0 {
+			fmt.Fprintf(os.Stderr, "FAIL %s: report differs from %s\n", name, expected)
+			for _, l := range diff {
+				fmt.Fprintln(os.Stderr, l)
+			}
+			failed++
+		} else {
+			fmt.Fprintf(os.Stderr, "ok %s\n", name)
+		}
+	}
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
@@ -9,6 +9,25 @@ type HeplifyServer struct {
 	HEPTCPAddr            string   `default:""`
 	HEPTLSAddr            string   `default:""`
 	HEPWSAddr             string   `default:""`
+	HEPWSPath             string   `default:""`
+	HEPWSTLS              bool     `default:"false"`
+	HEPWSIdleTimeout      int      `default:"60"`
+	HEPHTTPAddr           string   `default:""`
+	HEPHTTPToken          string   `default:""`
+	HEPHTTPTLS            bool     `default:"false"`
+	HEPForwardAddr        []string `default:""`
+	HEPForwardBuffer      int      `default:"20000"`
+	HEPAuthEnable         bool     `default:"false"`
+	HEPAuthNodes          []string `default:""`
+	HEPRateLimitIP        int      `default:"0"`
+	HEPRateBurstIP        int      `default:"0"`
+	HEPRateLimitNode      int      `default:"0"`
+	HEPRateBurstNode      int      `default:"0"`
+	HEPMaxConnPerIP       int      `default:"0"`
+	HEPAllowCIDR          []string `default:""`
+	HEPDenyCIDR           []string `default:""`
+	HEPEncapAllowCIDR     []string `default:""`
+	HEPEncapDenyCIDR      []string `default:""`
 	ESAddr                string   `default:""`
 	ESDiscovery           bool     `default:"true"`
 	HEPv2Enable           bool     `default:"true"`
@@ -32,9 +51,15 @@ type HeplifyServer struct {
 	LineprotoHEPFilter    []int    `default:"1,5,100"`
 	LineprotoIPPortLabels bool     `default:"false"`
 	ForceHEPPayload       []int    `default:""`
+	CDREnable             bool     `default:"false"`
+	CDRSetupTimeout       int      `default:"180"`
+	CDRIdleTimeout        int      `default:"14400"`
+	MediaCorrelate        bool     `default:"false"`
+	MediaCorrelateTTL     int      `default:"300"`
 	PromAddr              string   `default:":9096"`
 	PromTargetIP          string   `default:""`
 	PromTargetName        string   `default:""`
+	PromKPIWindow         int      `default:"15"`
 	DBShema               string   `default:"homer5"`
 	DBDriver              string   `default:"mysql"`
 	DBAddr                string   `default:"localhost:3306"`
@@ -47,6 +72,9 @@ type HeplifyServer struct {
 	DBTimer               int      `default:"4"`
 	DBBuffer              int      `default:"400000"`
 	DBWorker              int      `default:"8"`
+	DBSpoolFolder         string   `default:""`
+	DBSpoolSegment        int      `default:"16"`
+	DBSpoolMaxSize        int      `default:"1024"`
 	DBRotate              bool     `default:"true"`
 	DBPartLog             string   `default:"2h"`
 	DBPartIsup            string   `default:"6h"`
@@ -57,6 +85,7 @@ type HeplifyServer struct {
 	DBDropDaysRegister    int      `default:"0"`
 	DBDropDaysDefault     int      `default:"0"`
 	DBDropOnStart         bool     `default:"false"`
+	DBExportMaxDays       int      `default:"7"`
 	DBUsageProtection     bool     `default:"false"`
 	DBUsageScheme         string   `default:"percentage"`
 	DBPercentageUsage     string   `default:"80%"`
@@ -82,6 +111,19 @@ type HeplifyServer struct {
 	ScriptEngine          string   `default:"lua"`
 	ScriptFolder          string   `default:""`
 	ScriptHEPFilter       []int    `default:"1,5,100"`
+	ScriptLuaLibs         []string `default:"base,string,table,math"`
+	ScriptMaxInstructions int      `default:"1000000"`
+	ScriptTimeoutMS       int      `default:"100"`
+	ScriptStateMaxSize    int      `default:"32"`
+	ScriptStateNamespaces []string `default:""`
+	ScriptMetricMaxSeries int      `default:"1000"`
+	EnrichFolder          string   `default:""`
+	EnrichGeoIPFile       string   `default:""`
+	EnrichLokiLabels      []string `default:""`
+	EnrichPromTarget      string   `default:""`
 	TLSCertFolder         string   `default:"."`
 	TLSMinVersion         string   `default:"1.2"`
+	TLSCertFile           string   `default:""`
+	TLSKeyFile            string   `default:""`
+	TLSClientCA           string   `default:""`
 }
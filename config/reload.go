@@ -0,0 +1,15 @@
+package config
+
+import (
+	"github.com/negbie/multiconfig"
+)
+
+// ReadFile parses the TOML config file again. Only the fields which are set
+// in the file will be filled, everything else stays at its zero value.
+func ReadFile() (*HeplifyServer, error) {
+	s := new(HeplifyServer)
+	if err := (&multiconfig.TOMLLoader{Path: Setting.Config}).Load(s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"runtime"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/negbie/logp"
 	"github.com/sipcapture/heplify-server/config"
@@ -12,13 +14,21 @@ import (
 )
 
 type Database struct {
-	H    DBHandler
-	Chan chan *decoder.HEP
+	H     DBHandler
+	Chan  chan *decoder.HEP
+	Spill chan *decoder.HEP
+	spool *Spool
+	quit  chan bool
+	wg    sync.WaitGroup
 }
 
 type DBHandler interface {
 	setup() error
 	insert(chan *decoder.HEP)
+	ping() error
+	close()
+	replay(query string, rows []any) error
+	useSpool(s *Spool) DBHandler
 }
 
 func New(name string) *Database {
@@ -29,7 +39,8 @@ func New(name string) *Database {
 	}
 
 	return &Database{
-		H: register[name],
+		H:    register[name],
+		quit: make(chan bool),
 	}
 }
 
@@ -58,23 +69,79 @@ func (d *Database) Run() error {
 		return err
 	}
 
+	if config.Setting.DBSpoolFolder != "" {
+		d.spool, err = NewSpool(config.Setting.DBSpoolFolder,
+			int64(config.Setting.DBSpoolSegment)<<20, int64(config.Setting.DBSpoolMaxSize)<<20)
+		if err != nil {
+			return err
+		}
+		spiller := d.H.useSpool(d.spool)
+		if d.Spill != nil {
+			d.wg.Add(1)
+			go func() {
+				defer d.wg.Done()
+				spiller.insert(d.Spill)
+			}()
+		}
+		go d.replaySpool()
+	}
+
 	if worker > runtime.NumCPU() {
 		worker = runtime.NumCPU()
 	}
 
 	for i := 0; i < worker; i++ {
+		d.wg.Add(1)
 		go func() {
+			defer d.wg.Done()
 			d.H.insert(d.Chan)
 		}()
 	}
 	return nil
 }
 
+// End closes the channels and returns after the insert workers have
+// flushed their pending rows.
 func (d *Database) End() {
 	close(d.Chan)
+	if d.Spill != nil && d.spool != nil {
+		close(d.Spill)
+	}
+	d.wg.Wait()
+	if d.spool != nil {
+		d.quit <- true
+		<-d.quit
+		d.spool.Close()
+	}
+	d.H.close()
 	logp.Info("close %s channel", config.Setting.DBDriver)
 }
 
+func (d *Database) replaySpool() {
+	ticker := time.NewTicker(spoolReplayInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			d.spool.refreshGauges()
+			if d.spool.Empty() || len(d.Chan) > cap(d.Chan)/2 {
+				continue
+			}
+			if err := d.H.ping(); err != nil {
+				logp.Debug("sql", "spool replay waits for database: %v", err)
+				continue
+			}
+			logp.Info("database is reachable, replay spooled batches")
+			if err := d.spool.Replay(d.H.replay); err != nil {
+				logp.Warn("spool replay interrupted: %v", err)
+			}
+		case <-d.quit:
+			d.quit <- true
+			return
+		}
+	}
+}
+
 func ConnectString(dbName string) (string, error) {
 	var dsn string
 	driver := config.Setting.DBDriver
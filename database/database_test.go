@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"log"
 	"runtime"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/sipcapture/heplify-server/config"
 	"github.com/sipcapture/heplify-server/decoder"
@@ -63,6 +65,25 @@ func BenchmarkEscapeFields(b *testing.B) {
 	}
 }
 
+func TestMakeSIPDataHeader(t *testing.T) {
+	sh := config.Setting.SIPHeader
+	defer func() { config.Setting.SIPHeader = sh }()
+	config.Setting.SIPHeader = []string{"callid", "via_chain", "supported", "allow", "warning_code", "sdp_codecs", "media_port"}
+
+	h, err := decoder.DecodeHEP(hepPacket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bb := bytebufferpool.Get()
+	defer bytebufferpool.Put(bb)
+
+	want := `{"callid":"BC099884@6dfcffe8","via_chain":"SIP/2.0/UDP 192.168.111.111:5060;branch=z9hG4bK+21f113e7e3d04c846148a9ad7607aefa1+6dfcffe8+1",` +
+		`"supported":"100rel,timer","allow":"INVITE,ACK,CANCEL,BYE,OPTIONS,NOTIFY,PRACK,UPDATE,INFO,REFER","warning_code":"","sdp_codecs":"PCMA","media_port":""}`
+	if got := makeSIPDataHeader(h, bb, buildTemplate()); got != want {
+		t.Errorf("[TestMakeSIPDataHeader failed]\nwant:\t%s\ngot:\t%s", want, got)
+	}
+}
+
 /*
 func TestMakeISUPDataHeader(t *testing.T) {
 	bpp := bytebufferpool.Get()
@@ -83,3 +104,70 @@ func BenchmarkMakeISUPDataHeader(b *testing.B) {
 	}
 }
 */
+
+// recorder keeps the packets until its channel is closed like a handler
+// which waits for a full bulk.
+type recorder struct {
+	mu     sync.Mutex
+	pkts   []*decoder.HEP
+	closed bool
+}
+
+func (r *recorder) setup() error { return nil }
+func (r *recorder) ping() error  { return nil }
+func (r *recorder) close()       { r.closed = true }
+
+func (r *recorder) insert(ch chan *decoder.HEP) {
+	var pending []*decoder.HEP
+	for pkt := range ch {
+		pending = append(pending, pkt)
+	}
+	time.Sleep(10 * time.Millisecond)
+	r.mu.Lock()
+	r.pkts = append(r.pkts, pending...)
+	r.mu.Unlock()
+}
+
+func (r *recorder) replay(string, []any) error { return nil }
+func (r *recorder) useSpool(*Spool) DBHandler  { return r }
+
+func TestEndFlushes(t *testing.T) {
+	r := &recorder{}
+	d := &Database{H: r, Chan: make(chan *decoder.HEP, 10), quit: make(chan bool)}
+	if err := d.Run(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		d.Chan <- hep
+	}
+	d.End()
+	if len(r.pkts) != 3 || !r.closed {
+		t.Errorf("got %d packets before End returned, want 3", len(r.pkts))
+	}
+}
+
+func TestPostgresFlushOnClose(t *testing.T) {
+	s, err := NewSpool(t.TempDir(), 1<<20, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// a spilling handler writes its batches into the spool
+	p := &Postgres{bulkCnt: 100, dbTimer: time.Hour, spill: true, spool: s}
+	ch := make(chan *decoder.HEP, 3)
+	for i := 0; i < 3; i++ {
+		ch <- hep
+	}
+	close(ch)
+	p.insert(ch)
+
+	rows := 0
+	s.Replay(func(query string, r []any) error {
+		rows += len(r)
+		return nil
+	})
+	if rows != 3*5 {
+		t.Errorf("flushed %d values, want 15", rows)
+	}
+}
@@ -0,0 +1,237 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"github.com/sipcapture/heplify-server/config"
+	"github.com/sipcapture/heplify-server/decoder"
+)
+
+// exportMaxIDs limits the Call-IDs of one export, every ID is matched
+// against three columns of every table.
+const exportMaxIDs = 50
+
+var (
+	pgExportTables    = []string{"hep_proto_1_call", "hep_proto_1_registration", "hep_proto_1_default"}
+	mysqlExportTables = []string{"sip_capture_call_", "sip_capture_registration_", "sip_capture_rest_"}
+)
+
+const pgExportQuery = `SELECT create_date, protocol_header, raw FROM %s
+	WHERE create_date >= $1 AND create_date <= $2
+	AND (sid = ANY($3) OR protocol_header->>'correlation_id' = ANY($3) OR data_header->>'callid' = ANY($3))`
+
+const mysqlExportQuery = `SELECT micro_ts, source_ip, source_port, destination_ip, destination_port, proto, family, node, msg FROM %s
+	WHERE date >= ? AND date <= ? AND (callid IN (%[2]s) OR callid_aleg IN (%[2]s) OR correlation_id IN (%[2]s))`
+
+// exportDB is shared by all exports, so a request doesn't open a new
+// connection pool.
+var exportDB struct {
+	sync.Mutex
+	db  *sql.DB
+	dsn string
+}
+
+// CheckExport validates the Call-IDs and the time range of an export. The
+// range may span at most DBExportMaxDays, 0 doesn't limit it.
+func CheckExport(ids []string, from, to time.Time) error {
+	if len(ids) == 0 {
+		return fmt.Errorf("no Call-ID given")
+	}
+	if len(ids) > exportMaxIDs {
+		return fmt.Errorf("%d Call-IDs given, at most %d are allowed", len(ids), exportMaxIDs)
+	}
+	if from.After(to) {
+		return fmt.Errorf("from %s is after to %s", from.Format(time.RFC3339), to.Format(time.RFC3339))
+	}
+	if max := time.Duration(config.Setting.DBExportMaxDays) * 24 * time.Hour; max > 0 && to.Sub(from) > max {
+		return fmt.Errorf("time range exceeds DBExportMaxDays of %d days", config.Setting.DBExportMaxDays)
+	}
+	return nil
+}
+
+// FindSIP returns the stored SIP messages of the given Call-IDs or
+// correlation IDs between from and to, ordered by their capture time. Only
+// the fields needed to rebuild the packets are set.
+func FindSIP(ids []string, from, to time.Time) ([]*decoder.HEP, error) {
+	if err := CheckExport(ids, from, to); err != nil {
+		return nil, err
+	}
+	driver := config.Setting.DBDriver
+	if driver != "mysql" && driver != "postgres" {
+		return nil, fmt.Errorf("invalid DBDriver: %s, please use mysql or postgres", driver)
+	}
+	db, err := exportConn(driver)
+	if err != nil {
+		return nil, err
+	}
+
+	var pkts []*decoder.HEP
+	if driver == "postgres" {
+		pkts, err = findPostgres(db, ids, from, to)
+	} else {
+		pkts, err = findMySQL(db, ids, from, to)
+	}
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(pkts, func(i, j int) bool {
+		return pkts[i].Timestamp.Before(pkts[j].Timestamp)
+	})
+	return pkts, nil
+}
+
+// exportConn returns the shared connection pool, it is opened again only
+// when the connection settings changed.
+func exportConn(driver string) (*sql.DB, error) {
+	cs, err := ConnectString(config.Setting.DBDataTable)
+	if err != nil {
+		return nil, err
+	}
+	exportDB.Lock()
+	defer exportDB.Unlock()
+	if exportDB.db != nil && exportDB.dsn == driver+cs {
+		return exportDB.db, nil
+	}
+	db, err := sql.Open(driver, cs)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(2)
+	if exportDB.db != nil {
+		exportDB.db.Close()
+	}
+	exportDB.db, exportDB.dsn = db, driver+cs
+	return db, nil
+}
+
+func findPostgres(db *sql.DB, ids []string, from, to time.Time) ([]*decoder.HEP, error) {
+	var pkts []*decoder.HEP
+	for _, table := range pgExportTables {
+		rows, err := db.Query(fmt.Sprintf(pgExportQuery, table), from, to, pq.Array(ids))
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var (
+				date   time.Time
+				header []byte
+				raw    string
+			)
+			if err := rows.Scan(&date, &header, &raw); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			h, err := parseProtoHeader(header)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			h.Timestamp = date
+			h.Payload = raw
+			pkts = append(pkts, h)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return pkts, nil
+}
+
+func findMySQL(db *sql.DB, ids []string, from, to time.Time) ([]*decoder.HEP, error) {
+	ph := strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")
+	args := []any{from.UTC().Format("2006-01-02 15:04:05.999999"), to.UTC().Format("2006-01-02 15:04:05.999999")}
+	for i := 0; i < 3; i++ {
+		for _, id := range ids {
+			args = append(args, id)
+		}
+	}
+
+	var pkts []*decoder.HEP
+	// The tables are named after the UTC day in which the rows were inserted.
+	for day := from.UTC().Truncate(24 * time.Hour); !day.After(to.UTC()); day = day.Add(24 * time.Hour) {
+		for _, prefix := range mysqlExportTables {
+			table := prefix + day.Format("20060102")
+			rows, err := db.Query(fmt.Sprintf(mysqlExportQuery, table, ph), args...)
+			if err != nil {
+				if mErr, ok := err.(*mysql.MySQLError); ok && mErr.Number == 1146 {
+					continue
+				}
+				return nil, err
+			}
+			for rows.Next() {
+				var microTS int64
+				h := &decoder.HEP{ProtoType: 1}
+				if err := rows.Scan(&microTS, &h.SrcIP, &h.SrcPort, &h.DstIP, &h.DstPort,
+					&h.Protocol, &h.Version, &h.NodeName, &h.Payload); err != nil {
+					rows.Close()
+					return nil, err
+				}
+				h.Timestamp = time.UnixMicro(microTS).UTC()
+				pkts = append(pkts, h)
+			}
+			err = rows.Err()
+			rows.Close()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return pkts, nil
+}
+
+// parseProtoHeader is the reverse of makeProtoHeader.
+func parseProtoHeader(b []byte) (*decoder.HEP, error) {
+	var ph struct {
+		ProtocolFamily uint32 `json:"protocolFamily"`
+		Protocol       uint32 `json:"protocol"`
+		SrcIP          string `json:"srcIp"`
+		DstIP          string `json:"dstIp"`
+		SrcPort        uint32 `json:"srcPort"`
+		DstPort        uint32 `json:"dstPort"`
+		PayloadType    uint32 `json:"payloadType"`
+		CaptureID      string `json:"captureId"`
+		CorrelationID  string `json:"correlation_id"`
+	}
+	if err := json.Unmarshal(b, &ph); err != nil {
+		return nil, fmt.Errorf("invalid protocol_header: %v", err)
+	}
+	h := &decoder.HEP{
+		Version:   ph.ProtocolFamily,
+		Protocol:  ph.Protocol,
+		SrcIP:     ph.SrcIP,
+		DstIP:     ph.DstIP,
+		SrcPort:   ph.SrcPort,
+		DstPort:   ph.DstPort,
+		ProtoType: ph.PayloadType,
+		NodeName:  ph.CaptureID,
+		CID:       ph.CorrelationID,
+	}
+	return h, nil
+}
+
+// ParseExportTime accepts RFC 3339 or Unix seconds. An empty string returns
+// def.
+func ParseExportTime(s string, def time.Time) (time.Time, error) {
+	if s == "" {
+		return def, nil
+	}
+	if sec, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, use RFC 3339 or Unix seconds", s)
+	}
+	return t, nil
+}
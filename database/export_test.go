@@ -0,0 +1,77 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sipcapture/heplify-server/config"
+	"github.com/sipcapture/heplify-server/decoder"
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/bytebufferpool"
+)
+
+func TestParseProtoHeader(t *testing.T) {
+	in := &decoder.HEP{
+		Version:   2,
+		Protocol:  6,
+		SrcIP:     "10.0.0.1",
+		DstIP:     "10.0.0.2",
+		SrcPort:   5060,
+		DstPort:   5061,
+		ProtoType: 1,
+		NodeName:  "sbc01",
+		CID:       `a"b`,
+		Timestamp: time.Now(),
+	}
+	bb := bytebufferpool.Get()
+	defer bytebufferpool.Put(bb)
+
+	out, err := parseProtoHeader([]byte(makeProtoHeader(in, bb)))
+	if assert.NoError(t, err) {
+		assert.Equal(t, in.Version, out.Version)
+		assert.Equal(t, in.Protocol, out.Protocol)
+		assert.Equal(t, in.SrcIP, out.SrcIP)
+		assert.Equal(t, in.DstIP, out.DstIP)
+		assert.Equal(t, in.SrcPort, out.SrcPort)
+		assert.Equal(t, in.DstPort, out.DstPort)
+		assert.Equal(t, in.NodeName, out.NodeName)
+		assert.Equal(t, in.CID, out.CID)
+	}
+
+	_, err = parseProtoHeader([]byte("{"))
+	assert.Error(t, err)
+}
+
+func TestCheckExport(t *testing.T) {
+	orig := config.Setting.DBExportMaxDays
+	defer func() { config.Setting.DBExportMaxDays = orig }()
+	config.Setting.DBExportMaxDays = 7
+
+	now := time.Now()
+	assert.NoError(t, CheckExport([]string{"abc"}, now.Add(-24*time.Hour), now))
+	assert.Error(t, CheckExport(nil, now.Add(-time.Hour), now))
+	assert.Error(t, CheckExport([]string{"abc"}, now, now.Add(-time.Hour)))
+	assert.Error(t, CheckExport([]string{"abc"}, time.Unix(0, 0), now))
+	assert.Error(t, CheckExport(make([]string, exportMaxIDs+1), now.Add(-time.Hour), now))
+
+	config.Setting.DBExportMaxDays = 0
+	assert.NoError(t, CheckExport([]string{"abc"}, time.Unix(0, 0), now))
+}
+
+func TestParseExportTime(t *testing.T) {
+	def := time.Unix(42, 0)
+	got, err := ParseExportTime("", def)
+	assert.NoError(t, err)
+	assert.Equal(t, def, got)
+
+	got, err = ParseExportTime("1577934245", def)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1577934245), got.Unix())
+
+	got, err = ParseExportTime("2020-01-02T03:04:05Z", def)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1577934245), got.Unix())
+
+	_, err = ParseExportTime("yesterday", def)
+	assert.Error(t, err)
+}
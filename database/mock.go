@@ -42,9 +42,26 @@ func (m *Mock) insert(hCh chan *decoder.HEP) {
 			}
 		}
 	}
+	if callCnt > 0 {
+		m.bulkInsert(callCopy, callRowsString)
+	}
 }
 
 func (m *Mock) bulkInsert(query string, rows []string) {
 	logp.Debug("sql", "%s\n\n%v\n\n", query, rows)
 	m.db.Store(query, rows)
 }
+
+func (m *Mock) close() {}
+
+func (m *Mock) ping() error {
+	return nil
+}
+
+func (m *Mock) useSpool(s *Spool) DBHandler {
+	return m
+}
+
+func (m *Mock) replay(query string, rows []any) error {
+	return nil
+}
@@ -2,9 +2,12 @@ package database
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
 	"time"
 
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
 	"github.com/negbie/logp"
 	"github.com/sipcapture/heplify-server/config"
 	"github.com/sipcapture/heplify-server/decoder"
@@ -88,6 +91,8 @@ type MySQL struct {
 	dbTimer    time.Duration
 	sipBulkVal []byte
 	rtcBulkVal []byte
+	spool      *Spool
+	spill      bool
 }
 
 func (m *MySQL) setup() error {
@@ -200,13 +205,57 @@ func (m *MySQL) insert(hCh chan *decoder.HEP) {
 		return r
 	}
 
+	// flush inserts the pending rows of all tables
+	flush := func() {
+		if callCnt > 0 {
+			l := len(callRows)
+			m.bulkInsert(callQuery, sipQueryVal(l/sipValCnt), callRows[:l])
+			callRows = []any{}
+			callCnt = 0
+		}
+		if regCnt > 0 {
+			l := len(regRows)
+			m.bulkInsert(registerQuery, sipQueryVal(l/sipValCnt), regRows[:l])
+			regRows = []any{}
+			regCnt = 0
+		}
+		if restCnt > 0 {
+			l := len(restRows)
+			m.bulkInsert(restQuery, sipQueryVal(l/sipValCnt), restRows[:l])
+			restRows = []any{}
+			restCnt = 0
+		}
+		if rtcpCnt > 0 {
+			l := len(rtcpRows)
+			m.bulkInsert(rtcpQuery, rtcQueryVal(l/rtcValCnt), rtcpRows[:l])
+			rtcpRows = []any{}
+			rtcpCnt = 0
+		}
+		if reportCnt > 0 {
+			l := len(reportRows)
+			m.bulkInsert(reportQuery, rtcQueryVal(l/rtcValCnt), reportRows[:l])
+			reportRows = []any{}
+			reportCnt = 0
+		}
+		if dnsCnt > 0 {
+			l := len(dnsRows)
+			m.bulkInsert(dnsQuery, rtcQueryVal(l/rtcValCnt), dnsRows[:l])
+			dnsRows = []any{}
+			dnsCnt = 0
+		}
+		if logCnt > 0 {
+			l := len(logRows)
+			m.bulkInsert(logQuery, rtcQueryVal(l/rtcValCnt), logRows[:l])
+			logRows = []any{}
+			logCnt = 0
+		}
+	}
+
 	for {
 		select {
 		case pkt, ok = <-hCh:
 			if !ok {
-				if m.db != nil {
-					m.db.Close()
-				}
+				flush()
 				return
 			}
 
@@ -278,48 +327,7 @@ func (m *MySQL) insert(hCh chan *decoder.HEP) {
 			}
 		case <-timer.C:
 			timer.Reset(maxWait)
-			if callCnt > 0 {
-				l := len(callRows)
-				m.bulkInsert(callQuery, sipQueryVal(l/sipValCnt), callRows[:l])
-				callRows = []any{}
-				callCnt = 0
-			}
-			if regCnt > 0 {
-				l := len(regRows)
-				m.bulkInsert(registerQuery, sipQueryVal(l/sipValCnt), regRows[:l])
-				regRows = []any{}
-				regCnt = 0
-			}
-			if restCnt > 0 {
-				l := len(restRows)
-				m.bulkInsert(restQuery, sipQueryVal(l/sipValCnt), restRows[:l])
-				restRows = []any{}
-				restCnt = 0
-			}
-			if rtcpCnt > 0 {
-				l := len(rtcpRows)
-				m.bulkInsert(rtcpQuery, rtcQueryVal(l/rtcValCnt), rtcpRows[:l])
-				rtcpRows = []any{}
-				rtcpCnt = 0
-			}
-			if reportCnt > 0 {
-				l := len(reportRows)
-				m.bulkInsert(reportQuery, rtcQueryVal(l/rtcValCnt), reportRows[:l])
-				reportRows = []any{}
-				reportCnt = 0
-			}
-			if dnsCnt > 0 {
-				l := len(dnsRows)
-				m.bulkInsert(dnsQuery, rtcQueryVal(l/rtcValCnt), dnsRows[:l])
-				dnsRows = []any{}
-				dnsCnt = 0
-			}
-			if logCnt > 0 {
-				l := len(logRows)
-				m.bulkInsert(logQuery, rtcQueryVal(l/rtcValCnt), logRows[:l])
-				logRows = []any{}
-				logCnt = 0
-			}
+			flush()
 		}
 	}
 }
@@ -329,10 +337,66 @@ func (m *MySQL) bulkInsert(q, v []byte, rows []any) {
 	query := make([]byte, len(tblDate)+len(v))
 	tdl := copy(query, tblDate)
 	copy(query[tdl:], v)
-	_, err := m.db.Exec(string(query), rows...)
-	if err != nil {
+
+	if !m.spill {
+		_, err := m.db.Exec(string(query), rows...)
+		if err == nil {
+			return
+		}
 		logp.Err("%v", err)
 	}
+
+	if m.spool != nil {
+		if err := m.spool.Write(string(query), rows); err != nil {
+			logp.Err("%v", err)
+		}
+	}
+}
+
+func (m *MySQL) close() {
+	if m.db != nil {
+		m.db.Close()
+	}
+}
+
+func (m *MySQL) ping() error {
+	return m.db.Ping()
+}
+
+// useSpool attaches the spool for failed batches and returns a handler
+// which writes all batches into the spool without touching the database.
+func (m *MySQL) useSpool(s *Spool) DBHandler {
+	m.spool = s
+	sp := *m
+	sp.spill = true
+	return &sp
+}
+
+func (m *MySQL) replay(query string, rows []any) error {
+	if n := strings.Count(query, "?"); n == 0 || n != len(rows) {
+		return fmt.Errorf("%w: %d values for %d placeholders of %s", errInvalidBatch, len(rows), n, short(query, 60))
+	}
+	_, err := m.db.Exec(query, rows...)
+	if err != nil && !mysqlRetryable(err) {
+		return fmt.Errorf("%w: %v", errInvalidBatch, err)
+	}
+	return err
+}
+
+// mysqlRetryable reports whether a batch failed because the database was
+// unreachable, overloaded or shutting down and may be inserted later.
+// Errors the server returns for the batch itself are final.
+func mysqlRetryable(err error) bool {
+	var myErr *mysql.MySQLError
+	if !errors.As(err, &myErr) {
+		return true
+	}
+	switch myErr.Number {
+	case 1040, 1053, 1205, 1213:
+		// too many connections, shutdown, lock wait timeout, deadlock
+		return true
+	}
+	return false
 }
 
 func short(s string, i int) string {
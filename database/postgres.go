@@ -2,9 +2,11 @@ package database
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
 	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	"github.com/negbie/logp"
 	"github.com/sipcapture/heplify-server/config"
 	"github.com/sipcapture/heplify-server/decoder"
@@ -16,6 +18,8 @@ type Postgres struct {
 	dbTimer         time.Duration
 	bulkCnt         int
 	forceHEPPayload []int
+	spool           *Spool
+	spill           bool
 }
 
 const (
@@ -27,6 +31,7 @@ const (
 	dnsCopy      = "COPY hep_proto_53_default(sid,create_date,protocol_header,data_header,raw) FROM STDIN"
 	isupCopy     = "COPY hep_proto_54_default(sid,create_date,protocol_header,data_header,raw) FROM STDIN"
 	logCopy      = "COPY hep_proto_100_default(sid,create_date,protocol_header,data_header,raw) FROM STDIN"
+	cdrCopy      = "COPY hep_proto_1_cdr(sid,create_date,protocol_header,data_header,raw) FROM STDIN"
 )
 
 func (p *Postgres) setup() error {
@@ -64,7 +69,7 @@ func (p *Postgres) setup() error {
 
 func (p *Postgres) insert(hCh chan *decoder.HEP) {
 	var (
-		callCnt, regCnt, defCnt, dnsCnt, logCnt, rtcpCnt, isupCnt, reportCnt int
+		callCnt, regCnt, defCnt, dnsCnt, logCnt, rtcpCnt, isupCnt, reportCnt, cdrCnt int
 
 		callRows   = make([]string, 0, p.bulkCnt)
 		regRows    = make([]string, 0, p.bulkCnt)
@@ -74,6 +79,7 @@ func (p *Postgres) insert(hCh chan *decoder.HEP) {
 		isupRows   = make([]string, 0, p.bulkCnt)
 		rtcpRows   = make([]string, 0, p.bulkCnt)
 		reportRows = make([]string, 0, p.bulkCnt)
+		cdrRows    = make([]string, 0, p.bulkCnt)
 		maxWait    = p.dbTimer
 	)
 
@@ -92,13 +98,69 @@ func (p *Postgres) insert(hCh chan *decoder.HEP) {
 	bb := bytebufferpool.Get()
 	defer bytebufferpool.Put(bb)
 
+	// flush inserts the pending rows of all tables
+	flush := func() {
+		if callCnt > 0 {
+			l := len(callRows)
+			p.bulkInsert(callCopy, callRows[:l])
+			callRows = []string{}
+			callCnt = 0
+		}
+		if regCnt > 0 {
+			l := len(regRows)
+			p.bulkInsert(registerCopy, regRows[:l])
+			regRows = []string{}
+			regCnt = 0
+		}
+		if defCnt > 0 {
+			l := len(defRows)
+			p.bulkInsert(defaultCopy, defRows[:l])
+			defRows = []string{}
+			defCnt = 0
+		}
+		if rtcpCnt > 0 {
+			l := len(rtcpRows)
+			p.bulkInsert(rtcpCopy, rtcpRows[:l])
+			rtcpRows = []string{}
+			rtcpCnt = 0
+		}
+		if reportCnt > 0 {
+			l := len(reportRows)
+			p.bulkInsert(reportCopy, reportRows[:l])
+			reportRows = []string{}
+			reportCnt = 0
+		}
+		if dnsCnt > 0 {
+			l := len(dnsRows)
+			p.bulkInsert(dnsCopy, dnsRows[:l])
+			dnsRows = []string{}
+			dnsCnt = 0
+		}
+		if logCnt > 0 {
+			l := len(logRows)
+			p.bulkInsert(logCopy, logRows[:l])
+			logRows = []string{}
+			logCnt = 0
+		}
+		if isupCnt > 0 {
+			l := len(isupRows)
+			p.bulkInsert(isupCopy, isupRows[:l])
+			isupRows = []string{}
+			isupCnt = 0
+		}
+		if cdrCnt > 0 {
+			l := len(cdrRows)
+			p.bulkInsert(cdrCopy, cdrRows[:l])
+			cdrRows = []string{}
+			cdrCnt = 0
+		}
+	}
+
 	for {
 		select {
 		case pkt, ok := <-hCh:
 			if !ok {
-				if p.db != nil {
-					p.db.Close()
-				}
+				flush()
 				return
 			}
 
@@ -133,6 +195,15 @@ func (p *Postgres) insert(hCh chan *decoder.HEP) {
 						defCnt = 0
 					}
 				}
+			} else if pkt.CDR != nil {
+				pHeader := makeProtoHeader(pkt, bb)
+				cdrRows = append(cdrRows, pkt.SID, date, pHeader, pkt.Payload, pkt.Payload)
+				cdrCnt++
+				if cdrCnt == p.bulkCnt {
+					p.bulkInsert(cdrCopy, cdrRows)
+					cdrRows = []string{}
+					cdrCnt = 0
+				}
 			} else if pkt.ProtoType == 54 && pkt.Payload != "" {
 				pHeader := makeProtoHeader(pkt, bb)
 				sid, dHeader := makeISUPDataHeader([]byte(pkt.Payload), bb)
@@ -201,73 +272,92 @@ func (p *Postgres) insert(hCh chan *decoder.HEP) {
 			}
 		case <-timer.C:
 			timer.Reset(maxWait)
-			if callCnt > 0 {
-				l := len(callRows)
-				p.bulkInsert(callCopy, callRows[:l])
-				callRows = []string{}
-				callCnt = 0
-			}
-			if regCnt > 0 {
-				l := len(regRows)
-				p.bulkInsert(registerCopy, regRows[:l])
-				regRows = []string{}
-				regCnt = 0
-			}
-			if defCnt > 0 {
-				l := len(defRows)
-				p.bulkInsert(defaultCopy, defRows[:l])
-				defRows = []string{}
-				defCnt = 0
-			}
-			if rtcpCnt > 0 {
-				l := len(rtcpRows)
-				p.bulkInsert(rtcpCopy, rtcpRows[:l])
-				rtcpRows = []string{}
-				rtcpCnt = 0
-			}
-			if reportCnt > 0 {
-				l := len(reportRows)
-				p.bulkInsert(reportCopy, reportRows[:l])
-				reportRows = []string{}
-				reportCnt = 0
-			}
-			if dnsCnt > 0 {
-				l := len(dnsRows)
-				p.bulkInsert(dnsCopy, dnsRows[:l])
-				dnsRows = []string{}
-				dnsCnt = 0
-			}
-			if logCnt > 0 {
-				l := len(logRows)
-				p.bulkInsert(logCopy, logRows[:l])
-				logRows = []string{}
-				logCnt = 0
-			}
-			if isupCnt > 0 {
-				l := len(isupRows)
-				p.bulkInsert(isupCopy, isupRows[:l])
-				isupRows = []string{}
-				isupCnt = 0
-			}
+			flush()
 		}
 	}
 }
 
+func (p *Postgres) close() {
+	if p.db != nil {
+		p.db.Close()
+	}
+}
+
+func (p *Postgres) ping() error {
+	return p.db.Ping()
+}
+
+// useSpool attaches the spool for failed batches and returns a handler
+// which writes all batches into the spool without touching the database.
+func (p *Postgres) useSpool(s *Spool) DBHandler {
+	p.spool = s
+	sp := *p
+	sp.spill = true
+	return &sp
+}
+
+func (p *Postgres) replay(query string, rows []any) error {
+	if len(rows)%5 != 0 {
+		return fmt.Errorf("%w: %d values for %s", errInvalidBatch, len(rows), query)
+	}
+	r := make([]string, len(rows))
+	for i := range rows {
+		r[i], _ = rows[i].(string)
+	}
+	err := p.copyRows(query, r)
+	if err != nil && !pgRetryable(err) {
+		return fmt.Errorf("%w: %v", errInvalidBatch, err)
+	}
+	return err
+}
+
+// pgRetryable reports whether a batch failed because the database was
+// unreachable, overloaded or shutting down and may be inserted later.
+// Errors the server returns for the batch itself are final.
+func pgRetryable(err error) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return true
+	}
+	switch pqErr.Code.Class() {
+	case "08", "40", "53", "57", "58":
+		return true
+	}
+	return false
+}
+
 func (p *Postgres) bulkInsert(query string, rows []string) {
+	if !p.spill {
+		err := p.copyRows(query, rows)
+		if err == nil {
+			return
+		}
+		logp.Err("%v", err)
+	}
+
+	if p.spool != nil {
+		r := make([]any, len(rows))
+		for i := range rows {
+			r[i] = rows[i]
+		}
+		if err := p.spool.Write(query, r); err != nil {
+			logp.Err("%v", err)
+		}
+	}
+}
+
+func (p *Postgres) copyRows(query string, rows []string) error {
 	tx, err := p.db.Begin()
 	if err != nil || tx == nil {
-		logp.Err("%v", err)
-		return
+		return fmt.Errorf("begin %s: %w", query, err)
 	}
 
 	stmt, err := tx.Prepare(query)
 	if err != nil {
-		logp.Err("%v", err)
-		err := tx.Rollback()
-		if err != nil {
+		if err := tx.Rollback(); err != nil {
 			logp.Err("%v", err)
 		}
-		return
+		return err
 	}
 
 	for i := 0; i < len(rows); i = i + 5 {
@@ -278,9 +368,11 @@ func (p *Postgres) bulkInsert(query string, rows []string) {
 		}
 	}
 
-	_, err = stmt.Exec()
-	if err != nil {
-		logp.Err("%v", err)
+	// COPY reports a rejected row on the final Exec, the commit only
+	// says that the transaction failed.
+	_, copyErr := stmt.Exec()
+	if copyErr != nil {
+		logp.Err("%v", copyErr)
 	}
 	err = stmt.Close()
 	if err != nil {
@@ -288,8 +380,12 @@ func (p *Postgres) bulkInsert(query string, rows []string) {
 	}
 	err = tx.Commit()
 	if err != nil {
-		logp.Err("%v", err)
+		if copyErr != nil {
+			return copyErr
+		}
+		return err
 	}
 
 	logp.Debug("sql", "%s\n\n%v\n\n", query, rows)
+	return nil
 }
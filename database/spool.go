@@ -0,0 +1,355 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/negbie/logp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// The spool is a write-ahead log for database batches which couldn't be
+// inserted. Every batch is one record inside a segment file:
+//
+//	+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//	| len uint32 BE | JSON {"t","q","r"} |....
+//	+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//
+// Segment names start with the creation time in nanoseconds, so sorting
+// them by name gives the replay order.
+
+const (
+	spoolSuffix         = ".seg"
+	spoolReplayInterval = 5 * time.Second
+)
+
+var (
+	spoolSegments = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "heplify_db_spool_segments",
+		Help: "Number of database spool segments on disk"})
+	spoolBytes = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "heplify_db_spool_bytes",
+		Help: "Size of the database spool on disk in bytes"})
+	spoolLag = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "heplify_db_spool_replay_lag_seconds",
+		Help: "Age of the oldest not yet replayed database spool segment"})
+	spoolDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "heplify_db_spool_dropped_total",
+		Help: "Database batches dropped because the spool was full"})
+	spoolInvalid = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "heplify_db_spool_invalid_total",
+		Help: "Spooled database batches dropped on replay because they are corrupt or rejected by the database"})
+)
+
+// errInvalidBatch is returned by a replay handler for a batch which can
+// never be inserted, because it is malformed or the database rejects it.
+// The batch is dropped instead of blocking the replay.
+var errInvalidBatch = errors.New("invalid spooled batch")
+
+type spoolRecord struct {
+	Time  int64  `json:"t"`
+	Query string `json:"q"`
+	Rows  []any  `json:"r"`
+}
+
+// Spool stores failed database batches in size limited segment files.
+type Spool struct {
+	dir     string
+	segSize int64
+	maxSize int64
+
+	mu       sync.Mutex
+	cur      *os.File
+	curName  string
+	curSize  int64
+	segs     map[string]int64
+	offset   map[string]int64
+	total    int64
+	seq      uint64
+	closed   bool
+	lastWarn time.Time
+}
+
+// NewSpool opens or creates the spool in dir. Segments which are left from a
+// previous run will be replayed first.
+func NewSpool(dir string, segSize, maxSize int64) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, err
+	}
+	if segSize < 1 {
+		segSize = 1 << 20
+	}
+	if maxSize < segSize {
+		maxSize = segSize
+	}
+
+	s := &Spool{
+		dir:     dir,
+		segSize: segSize,
+		maxSize: maxSize,
+		segs:    make(map[string]int64),
+		offset:  make(map[string]int64),
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), spoolSuffix) {
+			continue
+		}
+		fi, err := f.Info()
+		if err != nil {
+			return nil, err
+		}
+		s.segs[f.Name()] = fi.Size()
+		s.total += fi.Size()
+	}
+	if len(s.segs) > 0 {
+		logp.Info("found %d spool segments with %d bytes in %s", len(s.segs), s.total, dir)
+	}
+	s.updateGauges()
+	return s, nil
+}
+
+// Write appends one batch to the current segment.
+func (s *Spool) Write(query string, rows []any) error {
+	b, err := json.Marshal(spoolRecord{Time: time.Now().UnixNano(), Query: query, Rows: rows})
+	if err != nil {
+		return err
+	}
+	n := int64(len(b) + 4)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return fmt.Errorf("spool %s is closed", s.dir)
+	}
+
+	for s.total+n > s.maxSize {
+		if !s.dropOldest() {
+			spoolDropped.Inc()
+			if time.Since(s.lastWarn) > 1e9 {
+				logp.Warn("database spool is full with %d bytes, dropping batch", s.total)
+			}
+			s.lastWarn = time.Now()
+			return nil
+		}
+	}
+
+	if s.cur == nil || s.curSize+n > s.segSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	hdr := make([]byte, 4)
+	binary.BigEndian.PutUint32(hdr, uint32(len(b)))
+	if _, err = s.cur.Write(hdr); err != nil {
+		return err
+	}
+	if _, err = s.cur.Write(b); err != nil {
+		return err
+	}
+
+	s.curSize += n
+	s.segs[s.curName] = s.curSize
+	s.total += n
+	s.updateGauges()
+	return nil
+}
+
+// rotate closes the current segment and opens a new one. Must be called with s.mu held.
+func (s *Spool) rotate() error {
+	if s.cur != nil {
+		if err := s.cur.Close(); err != nil {
+			logp.Err("%v", err)
+		}
+		s.cur = nil
+	}
+	s.seq++
+	name := strconv.FormatInt(time.Now().UnixNano(), 10)
+	name = strings.Repeat("0", 20-len(name)) + name + "-" + strconv.FormatUint(s.seq, 10) + spoolSuffix
+	f, err := os.OpenFile(filepath.Join(s.dir, name), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+	s.cur = f
+	s.curName = name
+	s.curSize = 0
+	s.segs[name] = 0
+	return nil
+}
+
+// dropOldest removes the oldest closed segment. Must be called with s.mu held.
+func (s *Spool) dropOldest() bool {
+	for _, name := range s.sorted() {
+		if name == s.curName && s.cur != nil {
+			continue
+		}
+		logp.Warn("database spool exceeds %d bytes, drop oldest segment %s", s.maxSize, name)
+		s.remove(name)
+		return true
+	}
+	return false
+}
+
+// remove deletes a segment from disk. Must be called with s.mu held.
+func (s *Spool) remove(name string) {
+	if err := os.Remove(filepath.Join(s.dir, name)); err != nil && !os.IsNotExist(err) {
+		logp.Err("%v", err)
+	}
+	s.total -= s.segs[name]
+	delete(s.segs, name)
+	delete(s.offset, name)
+	s.updateGauges()
+}
+
+func (s *Spool) sorted() []string {
+	names := make([]string, 0, len(s.segs))
+	for name := range s.segs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// refreshGauges updates the gauges, the replay lag grows while nothing
+// is written or replayed.
+func (s *Spool) refreshGauges() {
+	s.mu.Lock()
+	s.updateGauges()
+	s.mu.Unlock()
+}
+
+func (s *Spool) updateGauges() {
+	spoolSegments.Set(float64(len(s.segs)))
+	spoolBytes.Set(float64(s.total))
+	spoolLag.Set(0)
+	for _, name := range s.sorted() {
+		if i := strings.IndexByte(name, '-'); i > 0 {
+			if ts, err := strconv.ParseInt(name[:i], 10, 64); err == nil {
+				spoolLag.Set(time.Since(time.Unix(0, ts)).Seconds())
+			}
+		}
+		break
+	}
+}
+
+// Empty reports whether there is nothing left to replay.
+func (s *Spool) Empty() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.total == 0
+}
+
+// Replay hands all spooled batches in timestamp order to fn. It stops at the
+// first error and continues with the same batch on the next call. Batches
+// for which fn returns errInvalidBatch are dropped.
+func (s *Spool) Replay(fn func(query string, rows []any) error) error {
+	s.mu.Lock()
+	if s.cur != nil && s.curSize > 0 {
+		if err := s.cur.Close(); err != nil {
+			logp.Err("%v", err)
+		}
+		s.cur = nil
+	}
+	names := s.sorted()
+	s.mu.Unlock()
+
+	for _, name := range names {
+		s.mu.Lock()
+		if name == s.curName && s.cur != nil {
+			s.mu.Unlock()
+			continue
+		}
+		off := s.offset[name]
+		s.mu.Unlock()
+
+		n, err := s.replaySegment(name, off, fn)
+
+		s.mu.Lock()
+		if err != nil {
+			s.offset[name] = off + n
+			s.mu.Unlock()
+			return err
+		}
+		s.remove(name)
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+func (s *Spool) replaySegment(name string, off int64, fn func(string, []any) error) (int64, error) {
+	f, err := os.Open(filepath.Join(s.dir, name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	defer f.Close()
+
+	if _, err = f.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	var done int64
+	r := bufio.NewReader(f)
+	hdr := make([]byte, 4)
+	for {
+		if _, err = io.ReadFull(r, hdr); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return done, nil
+			}
+			return done, err
+		}
+		b := make([]byte, binary.BigEndian.Uint32(hdr))
+		if _, err = io.ReadFull(r, b); err != nil {
+			logp.Warn("truncated record in spool segment %s: %v", name, err)
+			return done, nil
+		}
+
+		var rec spoolRecord
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.UseNumber()
+		if err = dec.Decode(&rec); err != nil {
+			spoolInvalid.Inc()
+			logp.Warn("skip corrupt record in spool segment %s: %v", name, err)
+		} else if err = fn(rec.Query, rec.Rows); errors.Is(err, errInvalidBatch) {
+			spoolInvalid.Inc()
+			logp.Warn("skip record in spool segment %s: %v", name, err)
+		} else if err != nil {
+			return done, err
+		}
+		done += int64(len(b) + 4)
+	}
+}
+
+// Close flushes and closes the current segment.
+func (s *Spool) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	if s.cur != nil {
+		if err := s.cur.Close(); err != nil {
+			logp.Err("%v", err)
+		}
+		s.cur = nil
+	}
+}
@@ -0,0 +1,209 @@
+package database
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestSpoolReplayOrder(t *testing.T) {
+	s, err := NewSpool(t.TempDir(), 256, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	for i := 0; i < 20; i++ {
+		if err := s.Write("q", []any{fmt.Sprintf("row-%02d", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(s.segs) < 2 {
+		t.Errorf("expected rotation into several segments, got %d", len(s.segs))
+	}
+
+	var got []string
+	err = s.Replay(func(query string, rows []any) error {
+		got = append(got, rows[0].(string))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 20 {
+		t.Fatalf("replayed %d batches, want 20", len(got))
+	}
+	for i, v := range got {
+		if want := fmt.Sprintf("row-%02d", i); v != want {
+			t.Errorf("batch %d is %s, want %s", i, v, want)
+		}
+	}
+	if !s.Empty() {
+		t.Errorf("spool not empty after replay")
+	}
+}
+
+func TestSpoolReplayResume(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewSpool(dir, 1<<20, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		s.Write("q", []any{i})
+	}
+
+	var got []string
+	fail := errors.New("db down")
+	err = s.Replay(func(query string, rows []any) error {
+		if len(got) == 2 {
+			return fail
+		}
+		got = append(got, fmt.Sprint(rows[0]))
+		return nil
+	})
+	if err != fail {
+		t.Fatalf("expected replay error, got %v", err)
+	}
+
+	err = s.Replay(func(query string, rows []any) error {
+		got = append(got, fmt.Sprint(rows[0]))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(got) != "[0 1 2 3 4]" {
+		t.Errorf("replayed %v", got)
+	}
+	s.Close()
+
+	s, err = NewSpool(dir, 1<<20, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.Empty() {
+		t.Errorf("replayed segments left on disk")
+	}
+}
+
+func TestSpoolMaxSize(t *testing.T) {
+	s, err := NewSpool(t.TempDir(), 128, 512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	for i := 0; i < 100; i++ {
+		if err := s.Write("q", []any{fmt.Sprintf("row-%03d", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s.total > 512 {
+		t.Errorf("spool has %d bytes, limit is 512", s.total)
+	}
+
+	var last string
+	s.Replay(func(query string, rows []any) error {
+		last = rows[0].(string)
+		return nil
+	})
+	if last != "row-099" {
+		t.Errorf("newest batch is %s, want row-099", last)
+	}
+}
+
+func TestSpoolReplayInvalid(t *testing.T) {
+	s, err := NewSpool(t.TempDir(), 1<<20, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	for _, q := range []string{"bad", "good"} {
+		if err := s.Write(q, []any{q}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var got []string
+	err = s.Replay(func(query string, rows []any) error {
+		if query == "bad" {
+			return fmt.Errorf("%w: wrong row width", errInvalidBatch)
+		}
+		got = append(got, query)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0] != "good" {
+		t.Errorf("replayed %v, want [good]", got)
+	}
+	if !s.Empty() {
+		t.Errorf("invalid batch is still spooled")
+	}
+}
+
+func TestReplayRowWidth(t *testing.T) {
+	p := &Postgres{}
+	if err := p.replay("COPY x", []any{"a", "b"}); !errors.Is(err, errInvalidBatch) {
+		t.Errorf("got %v, want errInvalidBatch", err)
+	}
+	m := &MySQL{}
+	if err := m.replay("INSERT INTO x VALUES (?,?)", []any{"a"}); !errors.Is(err, errInvalidBatch) {
+		t.Errorf("got %v, want errInvalidBatch", err)
+	}
+}
+
+func TestReplayRetryable(t *testing.T) {
+	for _, c := range []struct {
+		err  error
+		want bool
+	}{
+		{driver.ErrBadConn, true},
+		{fmt.Errorf("begin COPY x: %w", &pq.Error{Code: "08006"}), true},
+		{&pq.Error{Code: "57P01"}, true},
+		{&pq.Error{Code: "23505"}, false},
+		{&pq.Error{Code: "23514"}, false},
+	} {
+		if got := pgRetryable(c.err); got != c.want {
+			t.Errorf("pgRetryable(%v) = %v, want %v", c.err, got, c.want)
+		}
+	}
+	for _, c := range []struct {
+		err  error
+		want bool
+	}{
+		{driver.ErrBadConn, true},
+		{&mysql.MySQLError{Number: 1213}, true},
+		{&mysql.MySQLError{Number: 1062}, false},
+		{&mysql.MySQLError{Number: 1146}, false},
+	} {
+		if got := mysqlRetryable(c.err); got != c.want {
+			t.Errorf("mysqlRetryable(%v) = %v, want %v", c.err, got, c.want)
+		}
+	}
+}
+
+func TestSpoolLagGrows(t *testing.T) {
+	s, err := NewSpool(t.TempDir(), 1<<20, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if err := s.Write("q", []any{"a"}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	s.refreshGauges()
+	if lag := testutil.ToFloat64(spoolLag); lag < 0.05 {
+		t.Errorf("replay lag is %v, want at least 0.05", lag)
+	}
+}
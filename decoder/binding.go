@@ -0,0 +1,27 @@
+package decoder
+
+import "time"
+
+// Binding is a registered contact of an address of record as followed by
+// the registration tracker. It travels to the metrics as the Binding
+// field of a HEP record with Event telling what happened to it.
+type Binding struct {
+	AoR      string    `json:"aor"`
+	Domain   string    `json:"domain"`
+	Contact  string    `json:"contact"`
+	AuthUser string    `json:"auth_user,omitempty"`
+	SrcIP    string    `json:"src_ip"`
+	DstIP    string    `json:"dst_ip"`
+	Expires  time.Time `json:"expires"`
+	LastSeen time.Time `json:"last_seen"`
+	Event    string    `json:"-"`
+	Response int       `json:"-"`
+}
+
+// Binding events
+const (
+	BindingAdded   = "added"
+	BindingRemoved = "removed"
+	BindingExpired = "expired"
+	BindingFailed  = "failed"
+)
@@ -0,0 +1,36 @@
+package decoder
+
+import "time"
+
+// CDR summarises one SIP call. It is built by the dialog tracker and
+// travels through the outputs as the CDR field of a HEP record whose
+// payload is the CDR as JSON.
+type CDR struct {
+	CallID        string    `json:"call_id"`
+	FromTag       string    `json:"from_tag"`
+	ToTag         string    `json:"to_tag,omitempty"`
+	FromUser      string    `json:"from_user,omitempty"`
+	ToUser        string    `json:"to_user,omitempty"`
+	RuriUser      string    `json:"ruri_user,omitempty"`
+	SrcIP         string    `json:"src_ip"`
+	DstIP         string    `json:"dst_ip"`
+	SetupTime     time.Time `json:"setup_time,omitzero"`
+	RingTime      time.Time `json:"ring_time,omitzero"`
+	AnswerTime    time.Time `json:"answer_time,omitzero"`
+	EndTime       time.Time `json:"end_time"`
+	PDD           float64   `json:"pdd"`
+	Duration      float64   `json:"duration"`
+	FinalResponse int       `json:"final_response"`
+	FinalReason   string    `json:"final_reason,omitempty"`
+	Status        string    `json:"status"`
+	Disconnect    string    `json:"disconnect"`
+	Reason        string    `json:"reason,omitempty"`
+}
+
+// CDR status values
+const (
+	CDRAnswered  = "answered"
+	CDRFailed    = "failed"
+	CDRCancelled = "cancelled"
+	CDRTimeout   = "timeout"
+)
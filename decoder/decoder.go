@@ -17,6 +17,7 @@ import (
 	xxhash "github.com/cespare/xxhash/v2"
 	"github.com/negbie/logp"
 	"github.com/sipcapture/heplify-server/config"
+	"github.com/sipcapture/heplify-server/enrich"
 	"github.com/sipcapture/heplify-server/sipparser"
 )
 
@@ -41,6 +42,7 @@ var (
 	strBackslashLT        = []byte(`\u003c`)
 	strBackslashQ         = []byte(`\u0027`)
 	strEmpty              = []byte(``)
+	strComma              = []byte(`,`)
 )
 
 // HEP chunks
@@ -83,10 +85,15 @@ type HEP struct {
 	ProtoString      string
 	Timestamp        time.Time
 	SIP              *sipparser.SipMsg
+	CDR              *CDR
+	Binding          *Binding
 	NodeName         string
 	TargetName       string
 	SID              string
 	CustomLokiLabels map[string]string
+	Dropped          bool
+	SkipOutputs      Output
+	enrichment       *enrichment
 }
 
 // DecodeHEP returns a parsed HEP message
@@ -156,6 +163,10 @@ func (h *HEP) parse(packet []byte) error {
 		}
 	}
 
+	if d := enrich.Active(); d != nil {
+		h.enrichment = &enrichment{data: d}
+	}
+
 	if h.NodeName == "" {
 		h.NodeName = strconv.FormatUint(uint64(h.NodeID), 10)
 	}
@@ -239,11 +250,97 @@ func (h *HEP) EscapeFields(w io.Writer, tag string) (int, error) {
 		return WriteJSONString(w, h.SIP.Expires)
 	case "callid_aleg":
 		return WriteJSONString(w, h.SIP.XCallID)
+	case "via_chain":
+		return writeJoined(w, len(h.SIP.Via), func(i int) string { return h.SIP.Via[i].Via })
+	case "route":
+		return writeJoined(w, len(h.SIP.Route), func(i int) string { return h.SIP.Route[i].Raw })
+	case "record_route":
+		return writeJoined(w, len(h.SIP.RecordRoute), func(i int) string { return h.SIP.RecordRoute[i].Raw })
+	case "warning":
+		return writeJoined(w, len(h.SIP.Warning), func(i int) string { return h.SIP.Warning[i].Val })
+	case "warning_code":
+		return writeJoined(w, len(h.SIP.Warning), func(i int) string { return h.SIP.Warning[i].Code })
+	case "require":
+		return WriteJSONString(w, strings.Join(h.SIP.Require, ","))
+	case "supported":
+		return WriteJSONString(w, strings.Join(h.SIP.Supported, ","))
+	case "unsupported":
+		return WriteJSONString(w, strings.Join(h.SIP.Unsupported, ","))
+	case "allow":
+		return WriteJSONString(w, strings.Join(h.SIP.Allow, ","))
+	case "allow_events":
+		return WriteJSONString(w, strings.Join(h.SIP.AllowEvents, ","))
+	case "rseq":
+		return WriteJSONString(w, h.SIP.Rseq)
+	case "rack":
+		if h.SIP.Rack == nil {
+			return w.Write(strEmpty)
+		}
+		return WriteJSONString(w, h.SIP.Rack.Val)
+	case "www_authenticate":
+		if h.SIP.WWWAuthenticate == nil {
+			return w.Write(strEmpty)
+		}
+		return WriteJSONString(w, h.SIP.WWWAuthenticate.Val)
+	case "proxy_authenticate":
+		if h.SIP.ProxyAuthenticate == nil {
+			return w.Write(strEmpty)
+		}
+		return WriteJSONString(w, h.SIP.ProxyAuthenticate.Val)
+	case "subject":
+		return WriteJSONString(w, h.SIP.Subject)
+	case "content_disposition":
+		if h.SIP.ContentDisposition == nil {
+			return w.Write(strEmpty)
+		}
+		return WriteJSONString(w, h.SIP.ContentDisposition.Val)
+	case "sdp_codecs":
+		sdp := h.SIP.SDP()
+		if sdp == nil {
+			return w.Write(strEmpty)
+		}
+		return WriteJSONString(w, strings.Join(sdp.Codecs(), ","))
+	case "media_ip", "media_port":
+		sdp := h.SIP.SDP()
+		if sdp == nil {
+			return w.Write(strEmpty)
+		}
+		ip, port := sdp.MediaAddr()
+		if port == 0 {
+			return w.Write(strEmpty)
+		}
+		if tag == "media_ip" {
+			return WriteJSONString(w, ip)
+		}
+		return w.Write([]byte(strconv.Itoa(port)))
 	default:
+		if v := h.Enrichment(tag); v != "" {
+			return WriteJSONString(w, v)
+		}
 		return w.Write(strEmpty)
 	}
 }
 
+// writeJoined writes n escaped values separated by commas.
+func writeJoined(w io.Writer, n int, val func(int) string) (int, error) {
+	var written int
+	for i := range n {
+		if i > 0 {
+			c, err := w.Write(strComma)
+			written += c
+			if err != nil {
+				return written, err
+			}
+		}
+		c, err := WriteJSONString(w, val(i))
+		written += c
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
 func WriteJSONString(w io.Writer, s string) (int, error) {
 	write := w.Write
 	b := stb(s)
@@ -0,0 +1,58 @@
+package decoder
+
+import (
+	"sync"
+
+	"github.com/sipcapture/heplify-server/enrich"
+)
+
+// enrichment holds the data which was active when the packet was decoded
+// and the attributes once they are looked up.
+type enrichment struct {
+	data  *enrich.Data
+	once  sync.Once
+	attrs map[string]string
+}
+
+// Enrichment returns an attribute of the packet like src_customer. The
+// lookups run on the first call, packets nobody asks for cost nothing.
+func (h *HEP) Enrichment(name string) string {
+	e := h.enrichment
+	if e == nil {
+		return ""
+	}
+	e.once.Do(func() { e.attrs = h.lookupEnrichment(e.data) })
+	return e.attrs[name]
+}
+
+// lookupEnrichment looks up the addresses and numbers of the packet. The
+// calling number is the From user and the called number the R-URI
+// user or, for responses, the To user.
+func (h *HEP) lookupEnrichment(d *enrich.Data) map[string]string {
+	var src, dst string
+	if h.SIP != nil {
+		src, dst = h.SIP.FromUser, h.SIP.URIUser
+		if dst == "" {
+			dst = h.SIP.ToUser
+		}
+	}
+	return d.Packet(h.SrcIP, h.DstIP, src, dst)
+}
+
+// enrichFuncs are the lookup functions of both script engines.
+func enrichFuncs() map[string]any {
+	return map[string]any{
+		"LookupTable": func(table, key, attr string) string {
+			if d := enrich.Active(); d != nil {
+				return d.Lookup(table, key, attr)
+			}
+			return ""
+		},
+		"LookupGeoIP": func(ip, path string) string {
+			if d := enrich.Active(); d != nil {
+				return d.GeoIP(ip, path)
+			}
+			return ""
+		},
+	}
+}
@@ -0,0 +1,40 @@
+package decoder
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sipcapture/heplify-server/enrich"
+	"github.com/sipcapture/heplify-server/sipparser"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnrichment(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "customers.csv"), []byte("cidr,customer\n192.0.2.0/24,acme\n"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "carriers.csv"), []byte("prefix,carrier\n49,de\n"), 0644))
+	d, err := enrich.Load(dir, "")
+	assert.NoError(t, err)
+	enrich.Store(d)
+	defer enrich.Store(nil)
+
+	m := "INVITE sip:+4930123@example.com SIP/2.0\r\nFrom: <sip:alice@example.com>;tag=a\r\nTo: <sip:+4930123@example.com>\r\nCall-ID: enrich@example.com\r\nCSeq: 1 INVITE\r\n\r\n"
+	hep := &HEP{ProtoType: 1, SrcIP: "192.0.2.10", DstIP: "198.51.100.1", SIP: sipparser.ParseMsg(m, nil, nil),
+		enrichment: &enrichment{data: d}}
+	assert.Nil(t, hep.enrichment.attrs, "nothing is looked up before it is asked for")
+	assert.Equal(t, "acme", hep.Enrichment("src_customer"))
+	assert.Equal(t, map[string]string{"src_customer": "acme", "dst_carrier": "de"}, hep.enrichment.attrs)
+	assert.Equal(t, "", (&HEP{}).Enrichment("src_customer"))
+
+	var b bytes.Buffer
+	hep.EscapeFields(&b, "src_customer")
+	hep.EscapeFields(&b, "dst_customer")
+	assert.Equal(t, "acme", b.String())
+
+	assert.Equal(t, "acme", newTestEngine(hep).GetEnrichment("src_customer"))
+	assert.Equal(t, "de", (&ExprEngine{hepPkt: hep}).GetEnrichment("dst_carrier"))
+	lookup := enrichFuncs()["LookupTable"].(func(string, string, string) string)
+	assert.Equal(t, "acme", lookup("customers", "192.0.2.99", "customer"))
+}
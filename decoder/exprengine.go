@@ -1,6 +1,7 @@
 package decoder
 
 import (
+	"maps"
 	"strconv"
 	"strings"
 
@@ -40,6 +41,39 @@ func (e *ExprEngine) GetHEPCID() string { return e.hepPkt.GetCID() }
 
 func (e *ExprEngine) GetSIPStruct() *sipparser.SipMsg { return e.hepPkt.SIP }
 
+func (e *ExprEngine) GetSDPStruct() *sipparser.SDP {
+	if e.hepPkt.SIP == nil {
+		return nil
+	}
+	return e.hepPkt.SIP.SDP()
+}
+
+func (e *ExprEngine) GetSDPMedia() []*sipparser.SDPMedia { return sdpMedia(e.hepPkt) }
+
+func (e *ExprEngine) GetSIPHeader(name string) string {
+	if vals := sipHeaders(e.hepPkt, name); len(vals) > 0 {
+		return vals[0]
+	}
+	return ""
+}
+
+func (e *ExprEngine) GetSIPHeaders(name string) []string { return sipHeaders(e.hepPkt, name) }
+
+func (e *ExprEngine) GetSIPBody() string {
+	if e.hepPkt.SIP == nil {
+		return ""
+	}
+	return e.hepPkt.SIP.Body
+}
+
+func (e *ExprEngine) GetSIPURI(name string) *sipparser.URI { return sipURI(e.hepPkt, name) }
+
+func (e *ExprEngine) GetSIPURIParam(name string, param string) string {
+	return sipURIParam(e.hepPkt, name, param)
+}
+
+func (e *ExprEngine) GetEnrichment(name string) string { return e.hepPkt.Enrichment(name) }
+
 func (e *ExprEngine) GetSIPCallID() string {
 	if e.hepPkt.SIP == nil {
 		return ""
@@ -96,6 +130,25 @@ func (e *ExprEngine) SetHEPField(field string, value string) uint8 {
 	return 1
 }
 
+func (e *ExprEngine) Drop() uint8 {
+	e.hepPkt.Dropped = true
+	return 1
+}
+
+func (e *ExprEngine) SetOutputs(names ...string) uint8 {
+	if err := e.hepPkt.SetOutputs(names...); err != nil {
+		logp.Err("SetOutputs: %v", err)
+	}
+	return 1
+}
+
+func (e *ExprEngine) SkipOutput(names ...string) uint8 {
+	if err := e.hepPkt.SkipOutput(names...); err != nil {
+		logp.Err("SkipOutput: %v", err)
+	}
+	return 1
+}
+
 func (e *ExprEngine) SetSIPProfile(p string) uint8 {
 	if strings.HasPrefix(p, "c") || strings.HasPrefix(p, "C") {
 		e.hepPkt.SIP.Profile = "call"
@@ -177,6 +230,14 @@ func NewExprEngine() (*ExprEngine, error) {
 		"GetHEPNodeID":       e.GetHEPNodeID,
 		"GetHEPCID":          e.GetHEPCID,
 		"GetSIPStruct":       e.GetSIPStruct,
+		"GetSDPStruct":       e.GetSDPStruct,
+		"GetSDPMedia":        e.GetSDPMedia,
+		"GetSIPHeader":       e.GetSIPHeader,
+		"GetSIPHeaders":      e.GetSIPHeaders,
+		"GetSIPBody":         e.GetSIPBody,
+		"GetSIPURI":          e.GetSIPURI,
+		"GetSIPURIParam":     e.GetSIPURIParam,
+		"GetEnrichment":      e.GetEnrichment,
 		"GetSIPCallID":       e.GetSIPCallID,
 		"GetRawMessage":      e.GetRawMessage,
 		"SetRawMessage":      e.SetRawMessage,
@@ -184,12 +245,18 @@ func NewExprEngine() (*ExprEngine, error) {
 		"SetHEPField":        e.SetHEPField,
 		"SetSIPProfile":      e.SetSIPProfile,
 		"SetSIPHeader":       e.SetSIPHeader,
+		"Drop":               e.Drop,
+		"SetOutputs":         e.SetOutputs,
+		"SkipOutput":         e.SkipOutput,
 		"HashTable":          HashTable,
 		"HashString":         HashString,
 		"ReplaceAll":         strings.ReplaceAll,
 		"TrimPrefix":         strings.TrimPrefix,
 		"TrimSuffix":         strings.TrimSuffix,
 	}
+	maps.Copy(e.env, stateFuncs())
+	maps.Copy(e.env, metricFuncs())
+	maps.Copy(e.env, enrichFuncs())
 
 	files, _, err := scanCode()
 	if err != nil {
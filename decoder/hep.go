@@ -7,6 +7,71 @@ import (
 	"strconv"
 )
 
+// MarshalHEP3 encodes the packet as HEPv3. The timestamp is taken from Tsec
+// and Tmsec and falls back to Timestamp when both are zero. CID, Vlan, NodePW
+// and NodeName chunks are only written when they are set.
+func (h *HEP) MarshalHEP3() ([]byte, error) {
+	srcIP := net.ParseIP(h.SrcIP)
+	if srcIP == nil {
+		return nil, fmt.Errorf("invalid HEP source IP %q", h.SrcIP)
+	}
+	dstIP := net.ParseIP(h.DstIP)
+	if dstIP == nil {
+		return nil, fmt.Errorf("invalid HEP destination IP %q", h.DstIP)
+	}
+
+	tsec, tmsec := h.Tsec, h.Tmsec
+	if tsec == 0 && tmsec == 0 && !h.Timestamp.IsZero() {
+		tsec = uint32(h.Timestamp.Unix())
+		tmsec = uint32(h.Timestamp.Nanosecond() / 1000)
+	}
+
+	b := make([]byte, 6, 128+len(h.Payload)+len(h.CID)+len(h.NodePW)+len(h.NodeName))
+	copy(b, "HEP3")
+
+	chunk := func(t uint16, l int) []byte {
+		b = binary.BigEndian.AppendUint16(b, 0)
+		b = binary.BigEndian.AppendUint16(b, t)
+		return binary.BigEndian.AppendUint16(b, uint16(6+l))
+	}
+	ip := func(v4, v6 uint16, addr net.IP) {
+		if a := addr.To4(); a != nil {
+			b = append(chunk(v4, 4), a...)
+		} else {
+			b = append(chunk(v6, 16), addr.To16()...)
+		}
+	}
+	str := func(t uint16, v string) {
+		if v != "" {
+			b = append(chunk(t, len(v)), v...)
+		}
+	}
+
+	b = append(chunk(Version, 1), byte(h.Version))
+	b = append(chunk(Protocol, 1), byte(h.Protocol))
+	ip(IP4SrcIP, IP6SrcIP, srcIP)
+	ip(IP4DstIP, IP6DstIP, dstIP)
+	b = binary.BigEndian.AppendUint16(chunk(SrcPort, 2), uint16(h.SrcPort))
+	b = binary.BigEndian.AppendUint16(chunk(DstPort, 2), uint16(h.DstPort))
+	b = binary.BigEndian.AppendUint32(chunk(Tsec, 4), tsec)
+	b = binary.BigEndian.AppendUint32(chunk(Tmsec, 4), tmsec)
+	b = append(chunk(ProtoType, 1), byte(h.ProtoType))
+	b = binary.BigEndian.AppendUint32(chunk(NodeID, 4), h.NodeID)
+	str(NodePW, h.NodePW)
+	str(Payload, h.Payload)
+	str(CID, h.CID)
+	if h.Vlan != 0 {
+		b = binary.BigEndian.AppendUint16(chunk(Vlan, 2), uint16(h.Vlan))
+	}
+	str(NodeName, h.NodeName)
+
+	if len(b) > 0xffff {
+		return nil, fmt.Errorf("HEP packet length %d exceeds %d", len(b), 0xffff)
+	}
+	binary.BigEndian.PutUint16(b[4:6], uint16(len(b)))
+	return b, nil
+}
+
 func (h *HEP) parseHEP(packet []byte) error {
 	length := binary.BigEndian.Uint16(packet[4:6])
 	if int(length) != len(packet) {
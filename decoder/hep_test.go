@@ -0,0 +1,134 @@
+package decoder
+
+import (
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func randomHEP(r *rand.Rand) *HEP {
+	randIP := func() string {
+		if r.Intn(2) == 0 {
+			return net.IPv4(byte(r.Intn(256)), byte(r.Intn(256)), byte(r.Intn(256)), byte(r.Intn(256))).String()
+		}
+		ip := make(net.IP, 16)
+		r.Read(ip)
+		ip[0] = 0x20
+		return ip.String()
+	}
+	randStr := func(n int) string {
+		const chars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_.:;@ äöü€"
+		var b strings.Builder
+		for i := r.Intn(n + 1); i > 0; i-- {
+			rs := []rune(chars)
+			b.WriteRune(rs[r.Intn(len(rs))])
+		}
+		return b.String()
+	}
+
+	h := &HEP{
+		Version:   uint32([]byte{2, 10}[r.Intn(2)]),
+		Protocol:  uint32([]byte{6, 17, 132}[r.Intn(3)]),
+		SrcIP:     randIP(),
+		DstIP:     randIP(),
+		SrcPort:   uint32(r.Intn(65536)),
+		DstPort:   uint32(r.Intn(65536)),
+		Tsec:      uint32(r.Int31n(2e9) + 1),
+		Tmsec:     uint32(r.Intn(1e6)),
+		ProtoType: uint32(r.Intn(254) + 2),
+		NodeID:    r.Uint32(),
+		NodePW:    randStr(16),
+		Payload:   randStr(2000),
+		CID:       randStr(64),
+		Vlan:      uint32(r.Intn(4096)),
+		NodeName:  randStr(32),
+	}
+	return h
+}
+
+func TestMarshalHEP3RoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		in := randomHEP(r)
+		b, err := in.MarshalHEP3()
+		if err != nil {
+			t.Fatalf("marshal %+v: %v", in, err)
+		}
+		out, err := DecodeHEP(b)
+		if err != nil {
+			t.Fatalf("decode %+v: %v", in, err)
+		}
+
+		nodeName := in.NodeName
+		if nodeName == "" {
+			nodeName = strconv.FormatUint(uint64(in.NodeID), 10)
+		}
+		assert.Equal(t, in.Version, out.Version)
+		assert.Equal(t, in.Protocol, out.Protocol)
+		assert.Equal(t, in.SrcIP, out.SrcIP)
+		assert.Equal(t, in.DstIP, out.DstIP)
+		assert.Equal(t, in.SrcPort, out.SrcPort)
+		assert.Equal(t, in.DstPort, out.DstPort)
+		assert.Equal(t, in.Tsec, out.Tsec)
+		assert.Equal(t, in.Tmsec, out.Tmsec)
+		assert.Equal(t, in.ProtoType, out.ProtoType)
+		assert.Equal(t, in.NodeID, out.NodeID)
+		assert.Equal(t, in.NodePW, out.NodePW)
+		assert.Equal(t, in.Payload, out.Payload)
+		assert.Equal(t, in.CID, out.CID)
+		assert.Equal(t, in.Vlan, out.Vlan)
+		assert.Equal(t, nodeName, out.NodeName)
+		if t.Failed() {
+			t.Fatalf("round trip mismatch for %+v", in)
+		}
+	}
+}
+
+func TestMarshalHEP3SIP(t *testing.T) {
+	in, err := DecodeHEP(hepPacket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := in.MarshalHEP3()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := DecodeHEP(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, in.Payload, out.Payload)
+	assert.Equal(t, in.Timestamp, out.Timestamp)
+	assert.Equal(t, in.SIP.CallID, out.SIP.CallID)
+	assert.Equal(t, in.SIP.CseqMethod, out.SIP.CseqMethod)
+}
+
+func TestMarshalHEP3Timestamp(t *testing.T) {
+	in, err := DecodeHEP(hepPacket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in.Tsec, in.Tmsec = 0, 0
+	b, err := in.MarshalHEP3()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := DecodeHEP(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, in.Timestamp, out.Timestamp)
+}
+
+func TestMarshalHEP3Errors(t *testing.T) {
+	_, err := (&HEP{SrcIP: "1.2.3", DstIP: "1.2.3.4"}).MarshalHEP3()
+	assert.Error(t, err)
+	_, err = (&HEP{SrcIP: "1.2.3.4", DstIP: ""}).MarshalHEP3()
+	assert.Error(t, err)
+	_, err = (&HEP{SrcIP: "1.2.3.4", DstIP: "::1", Payload: strings.Repeat("x", 0xffff)}).MarshalHEP3()
+	assert.Error(t, err)
+}
@@ -1,6 +1,7 @@
 package decoder
 
 import (
+	"errors"
 	"fmt"
 	"maps"
 	"math"
@@ -8,6 +9,7 @@ import (
 	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/negbie/logp"
 	"github.com/sipcapture/golua/lua"
@@ -35,6 +37,8 @@ type LuaEngine struct {
 	/* pointer to modify */
 	hepPkt    **HEP
 	functions []string
+	names     []string
+	guard     *luaGuard
 	LuaEngine *lua.State
 }
 
@@ -52,6 +56,59 @@ func (d *LuaEngine) GetSIPStruct() any {
 	return (*d.hepPkt).SIP
 }
 
+func (d *LuaEngine) GetSDPStruct() any {
+	if (*d.hepPkt).SIP == nil || (*d.hepPkt).SIP.SDP() == nil {
+		return ""
+	}
+	return (*d.hepPkt).SIP.SDP()
+}
+
+func (d *LuaEngine) GetSDPMedia() any {
+	if m := sdpMedia(*d.hepPkt); m != nil {
+		return m
+	}
+	return ""
+}
+
+func (d *LuaEngine) GetSIPHeader(name string) string {
+	if vals := sipHeaders(*d.hepPkt, name); len(vals) > 0 {
+		return vals[0]
+	}
+	return ""
+}
+
+func (d *LuaEngine) GetSIPHeaders(name string) []string {
+	if vals := sipHeaders(*d.hepPkt, name); vals != nil {
+		return vals
+	}
+	return []string{}
+}
+
+func (d *LuaEngine) GetSIPBody() string {
+	if (*d.hepPkt).SIP == nil {
+		return ""
+	}
+	return (*d.hepPkt).SIP.Body
+}
+
+func (d *LuaEngine) GetSIPURI(name string) any {
+	if u := sipURI(*d.hepPkt, name); u != nil {
+		return u
+	}
+	return ""
+}
+
+func (d *LuaEngine) GetSIPURIParam(name string, param string) string {
+	return sipURIParam(*d.hepPkt, name, param)
+}
+
+func (d *LuaEngine) GetEnrichment(name string) string {
+	if (*d.hepPkt) == nil {
+		return ""
+	}
+	return (*d.hepPkt).Enrichment(name)
+}
+
 func (d *LuaEngine) GetHEPProtoType() uint32 {
 	return (*d.hepPkt).GetProtoType()
 }
@@ -158,6 +215,30 @@ func (d *LuaEngine) SetHEPField(field string, value string) {
 	}
 }
 
+func (d *LuaEngine) Drop() {
+	if (*d.hepPkt) != nil {
+		(*d.hepPkt).Dropped = true
+	}
+}
+
+func (d *LuaEngine) SetOutputs(names ...string) {
+	if (*d.hepPkt) == nil {
+		return
+	}
+	if err := (*d.hepPkt).SetOutputs(names...); err != nil {
+		logp.Err("SetOutputs: %v", err)
+	}
+}
+
+func (d *LuaEngine) SkipOutput(names ...string) {
+	if (*d.hepPkt) == nil {
+		return
+	}
+	if err := (*d.hepPkt).SkipOutput(names...); err != nil {
+		logp.Err("SkipOutput: %v", err)
+	}
+}
+
 func (d *LuaEngine) SetSIPProfile(p string) {
 	hepPkt := *d.hepPkt
 	if strings.HasPrefix(p, "c") || strings.HasPrefix(p, "C") {
@@ -294,11 +375,30 @@ func NewLuaEngine() (*LuaEngine, error) {
 
 	d := &LuaEngine{}
 	d.LuaEngine = lua.NewState()
-	d.LuaEngine.OpenLibs()
+	d.guard = &luaGuard{
+		budget: luaBudget{
+			instructions: config.Setting.ScriptMaxInstructions,
+			timeout:      time.Duration(config.Setting.ScriptTimeoutMS) * time.Millisecond,
+		},
+		setLimit: d.LuaEngine.SetExecutionLimit,
+		reset:    func() { d.LuaEngine.SetTop(0) },
+	}
+	if err := openLuaLibs(d.LuaEngine, config.Setting.ScriptLuaLibs); err != nil {
+		d.Close()
+		return nil, err
+	}
 
 	luar.Register(d.LuaEngine, "", luar.Map{
 		"GetHEPStruct":       d.GetHEPStruct,
 		"GetSIPStruct":       d.GetSIPStruct,
+		"GetSDPStruct":       d.GetSDPStruct,
+		"GetSDPMedia":        d.GetSDPMedia,
+		"GetSIPHeader":       d.GetSIPHeader,
+		"GetSIPHeaders":      d.GetSIPHeaders,
+		"GetSIPBody":         d.GetSIPBody,
+		"GetSIPURI":          d.GetSIPURI,
+		"GetSIPURIParam":     d.GetSIPURIParam,
+		"GetEnrichment":      d.GetEnrichment,
 		"GetHEPProtoType":    d.GetHEPProtoType,
 		"GetHEPSrcIP":        d.GetHEPSrcIP,
 		"GetHEPSrcPort":      d.GetHEPSrcPort,
@@ -314,26 +414,38 @@ func NewLuaEngine() (*LuaEngine, error) {
 		"SetSIPProfile":      d.SetSIPProfile,
 		"SetSIPHeader":       d.SetSIPHeader,
 		"SetLokiLabel":       d.SetLokiLabel,
+		"Drop":               d.Drop,
+		"SetOutputs":         d.SetOutputs,
+		"SkipOutput":         d.SkipOutput,
 		"HashTable":          HashTable,
 		"HashString":         HashString,
 		"Logp":               d.Logp,
 		"Print":              fmt.Println,
 	})
+	luar.Register(d.LuaEngine, "", stateFuncs())
+	luar.Register(d.LuaEngine, "", metricFuncs())
+	luar.Register(d.LuaEngine, "", enrichFuncs())
 
 	_, code, err := scanCode()
 	if err != nil {
+		d.Close()
 		return nil, err
 	}
 
-	err = d.LuaEngine.DoString(code.String())
+	err = d.guard.run("main", func() error { return d.LuaEngine.DoString(code.String()) })
 	if err != nil {
+		d.Close()
 		return nil, err
 	}
 
 	d.functions = extractFunc(code)
 	if len(d.functions) < 1 {
+		d.Close()
 		return nil, fmt.Errorf("no function name found in lua scripts")
 	}
+	for _, f := range d.functions {
+		d.names = append(d.names, f[:strings.IndexByte(f, '(')])
+	}
 
 	return d, nil
 }
@@ -343,11 +455,11 @@ func (d *LuaEngine) Run(hep *HEP) error {
 	/* preload */
 	d.hepPkt = &hep
 
-	for _, v := range d.functions {
-		err := d.LuaEngine.DoString(v)
-		if err != nil {
-			return err
+	var errs []error
+	for i, v := range d.functions {
+		if err := d.guard.run(d.names[i], func() error { return d.LuaEngine.DoString(v) }); err != nil {
+			errs = append(errs, err)
 		}
 	}
-	return nil
+	return errors.Join(errs...)
 }
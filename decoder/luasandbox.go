@@ -0,0 +1,141 @@
+package decoder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sipcapture/golua/lua"
+)
+
+// luaQuantumExceeded is the error of the instruction count hook.
+const luaQuantumExceeded = "Lua execution quantum exceeded"
+
+var (
+	scriptTimeouts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "heplify_script_timeouts_total",
+		Help: "Lua function calls stopped by the instruction or time budget"},
+		[]string{"function"})
+	scriptErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "heplify_script_errors_total",
+		Help: "Lua function calls which failed or panicked"},
+		[]string{"function"})
+)
+
+// luaLibs are the library tables scripts only get when they are listed
+// in ScriptLuaLibs. The base functions are always there.
+var luaLibs = []string{"string", "table", "math", "bit", "coroutine", "os", "io", "package", "debug", "jit"}
+
+// openLuaLibs opens the standard libraries and removes those which are
+// not listed. dofile and loadfile are removed in any case, scripts are
+// loaded from ScriptFolder only.
+func openLuaLibs(L *lua.State, allow []string) error {
+	allowed := map[string]bool{"base": true}
+	for _, name := range allow {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != "base" && !slices.Contains(luaLibs, name) {
+			return fmt.Errorf("unknown lua library %q in ScriptLuaLibs", name)
+		}
+		allowed[name] = true
+	}
+	remove := []string{"dofile", "loadfile"}
+	for _, name := range luaLibs {
+		if !allowed[name] {
+			remove = append(remove, name)
+		}
+	}
+	if !allowed["package"] {
+		remove = append(remove, "require", "module")
+	}
+
+	L.OpenLibs()
+	// the count hook of the budget is not called from code compiled by
+	// LuaJIT, so the compiler is turned off
+	return L.DoString(`if jit then jit.off() end
+local remove = {"` + strings.Join(remove, `", "`) + `"}
+for _, name in ipairs(remove) do
+	if package then package.loaded[name] = nil end
+end
+for _, name in ipairs(remove) do
+	_G[name] = nil
+end`)
+}
+
+// luaBudget limits a single call of a Lua function.
+type luaBudget struct {
+	instructions int
+	timeout      time.Duration
+}
+
+// luaGuard runs calls within a budget. setLimit sets the instruction
+// count hook of the state and reset clears its stack after a panic.
+type luaGuard struct {
+	budget   luaBudget
+	setLimit func(int)
+	reset    func()
+	mu       sync.Mutex
+	seq      uint64
+	running  bool
+}
+
+// run calls fn within the instruction and time budget. An exceeded
+// budget, a Lua error or a Go panic only ends this call.
+func (g *luaGuard) run(name string, fn func() error) (err error) {
+	limit := g.budget.instructions
+	if limit <= 0 {
+		limit = math.MaxInt32
+	}
+
+	g.mu.Lock()
+	g.seq++
+	seq := g.seq
+	g.running = true
+	g.setLimit(limit)
+	g.mu.Unlock()
+
+	ctx := context.Background()
+	if g.budget.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, g.budget.timeout)
+		defer cancel()
+		// lua_sethook may be called from another thread, the next
+		// instruction of the running call then raises the hook error
+		stop := context.AfterFunc(ctx, func() {
+			g.mu.Lock()
+			if g.running && g.seq == seq {
+				g.setLimit(1)
+			}
+			g.mu.Unlock()
+		})
+		defer stop()
+	}
+
+	defer func() {
+		g.mu.Lock()
+		g.running = false
+		g.mu.Unlock()
+
+		if r := recover(); r != nil {
+			g.reset()
+			err = fmt.Errorf("panic in lua function %s: %v", name, r)
+		}
+		if err == nil {
+			return
+		}
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) || strings.Contains(err.Error(), luaQuantumExceeded) {
+			scriptTimeouts.WithLabelValues(name).Inc()
+			err = fmt.Errorf("lua function %s exceeded its budget of %d instructions or %v", name, limit, g.budget.timeout)
+		} else {
+			scriptErrors.WithLabelValues(name).Inc()
+		}
+	}()
+
+	return fn()
+}
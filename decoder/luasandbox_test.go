@@ -0,0 +1,77 @@
+package decoder
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeHook stands in for the count hook of a Lua state: a call fails
+// once the limit is set to a single instruction.
+type fakeHook struct {
+	mu     sync.Mutex
+	limit  int
+	resets int
+}
+
+func (h *fakeHook) setLimit(n int) {
+	h.mu.Lock()
+	h.limit = n
+	h.mu.Unlock()
+}
+
+func (h *fakeHook) exceeded() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.limit == 1
+}
+
+func TestLuaGuard(t *testing.T) {
+	h := &fakeHook{}
+	g := &luaGuard{
+		budget:   luaBudget{instructions: 1000, timeout: 20 * time.Millisecond},
+		setLimit: h.setLimit,
+		reset:    func() { h.resets++ },
+	}
+
+	assert.NoError(t, g.run("ok", func() error { return nil }))
+	assert.Equal(t, 1000, h.limit)
+
+	timeouts := testutil.ToFloat64(scriptTimeouts.WithLabelValues("loop"))
+	err := g.run("loop", func() error {
+		for !h.exceeded() {
+			time.Sleep(time.Millisecond)
+		}
+		return errors.New(luaQuantumExceeded)
+	})
+	assert.ErrorContains(t, err, "lua function loop exceeded its budget")
+	assert.Equal(t, timeouts+1, testutil.ToFloat64(scriptTimeouts.WithLabelValues("loop")))
+
+	// the next call gets the full budget again
+	assert.NoError(t, g.run("ok", func() error { return nil }))
+	assert.Equal(t, 1000, h.limit)
+
+	timeouts = testutil.ToFloat64(scriptTimeouts.WithLabelValues("count"))
+	assert.Error(t, g.run("count", func() error { return errors.New("[string \"...\"]:2: " + luaQuantumExceeded) }))
+	assert.Equal(t, timeouts+1, testutil.ToFloat64(scriptTimeouts.WithLabelValues("count")))
+
+	failures := testutil.ToFloat64(scriptErrors.WithLabelValues("broken"))
+	assert.EqualError(t, g.run("broken", func() error { return errors.New("attempt to call a nil value") }), "attempt to call a nil value")
+	err = g.run("broken", func() error { panic("index out of range") })
+	assert.ErrorContains(t, err, "panic in lua function broken")
+	assert.Equal(t, 1, h.resets)
+	assert.Equal(t, failures+2, testutil.ToFloat64(scriptErrors.WithLabelValues("broken")))
+
+	g.budget = luaBudget{}
+	assert.NoError(t, g.run("ok", func() error { return nil }))
+	assert.Greater(t, h.limit, 1000)
+}
+
+func TestOpenLuaLibsUnknown(t *testing.T) {
+	// the names are checked before the state is touched
+	assert.ErrorContains(t, openLuaLibs(nil, []string{"base", "string", "posix"}), `unknown lua library "posix"`)
+}
@@ -15,6 +15,7 @@ import (
 	"unicode"
 
 	"github.com/sipcapture/heplify-server/config"
+	"github.com/sipcapture/heplify-server/sipparser"
 )
 
 // ScriptEngine interface
@@ -34,6 +35,46 @@ func NewScriptEngine() (ScriptEngine, error) {
 	return nil, fmt.Errorf("unknown script engine %s", config.Setting.ScriptEngine)
 }
 
+// sipHeaders returns all values of a SIP header for both script engines
+func sipHeaders(h *HEP, name string) []string {
+	if h == nil || h.SIP == nil {
+		return nil
+	}
+	return h.SIP.GetHeaders(name)
+}
+
+// sipURI returns the uri of the request line or a SIP header
+func sipURI(h *HEP, name string) *sipparser.URI {
+	if h == nil || h.SIP == nil {
+		return nil
+	}
+	return h.SIP.GetURI(name)
+}
+
+// sipURIParam returns the value of a uri parameter. Parameters without
+// value like ;lr return their name.
+func sipURIParam(h *HEP, name, param string) string {
+	u := sipURI(h, name)
+	if u == nil {
+		return ""
+	}
+	p := u.GetParam(param)
+	if p == nil {
+		return ""
+	}
+	if p.Val == "" {
+		return p.Param
+	}
+	return p.Val
+}
+
+func sdpMedia(h *HEP) []*sipparser.SDPMedia {
+	if h == nil || h.SIP == nil || h.SIP.SDP() == nil {
+		return nil
+	}
+	return h.SIP.SDP().Media
+}
+
 func scanCode() ([]string, *bytes.Buffer, error) {
 	var files []string
 	buf := bytes.NewBuffer(nil)
@@ -0,0 +1,67 @@
+package decoder
+
+import (
+	"testing"
+
+	"github.com/sipcapture/heplify-server/sipparser"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScriptSIPAccessors(t *testing.T) {
+	m := "INVITE sip:bob@example.com;transport=tcp SIP/2.0\r\nv: SIP/2.0/UDP 10.0.0.1;branch=z9hG4bK1\r\nVia: SIP/2.0/UDP 10.0.0.2;branch=z9hG4bK2\r\n" +
+		"f: <sip:alice@example.com;user=phone>;tag=a\r\nTo: <sip:bob@example.com>\r\nCall-ID: accessors@example.com\r\nCSeq: 1 INVITE\r\n" +
+		"Content-Type: application/sdp\r\n\r\nv=0\r\nc=IN IP4 192.0.2.1\r\nm=audio 4000 RTP/AVP 0\r\n"
+	hep := &HEP{ProtoType: 1, SIP: sipparser.ParseMsg(m, nil, nil)}
+	lua := newTestEngine(hep)
+	ex := &ExprEngine{hepPkt: hep}
+
+	assert.Equal(t, "SIP/2.0/UDP 10.0.0.1;branch=z9hG4bK1", lua.GetSIPHeader("via"))
+	assert.Equal(t, lua.GetSIPHeader("via"), ex.GetSIPHeader("VIA"))
+	assert.Equal(t, []string{"SIP/2.0/UDP 10.0.0.1;branch=z9hG4bK1", "SIP/2.0/UDP 10.0.0.2;branch=z9hG4bK2"}, lua.GetSIPHeaders("Via"))
+	assert.Equal(t, lua.GetSIPHeaders("Via"), ex.GetSIPHeaders("v"))
+	assert.Equal(t, "v=0\r\nc=IN IP4 192.0.2.1\r\nm=audio 4000 RTP/AVP 0\r\n", ex.GetSIPBody())
+	assert.Equal(t, ex.GetSIPBody(), lua.GetSIPBody())
+	assert.Equal(t, 4000, ex.GetSDPMedia()[0].Port)
+	assert.Equal(t, "tcp", lua.GetSIPURIParam("ruri", "transport"))
+	assert.Equal(t, "phone", ex.GetSIPURIParam("from", "user"))
+	assert.Equal(t, "alice", ex.GetSIPURI("from").User)
+	assert.Equal(t, "", ex.GetSIPURIParam("to", "user"))
+
+	// missing values are empty for lua scripts
+	assert.Equal(t, []string{}, lua.GetSIPHeaders("Subject"))
+	assert.Equal(t, "", lua.GetSIPURI("pai"))
+	hep.SIP = nil
+	assert.Equal(t, "", lua.GetSIPHeader("Via"))
+	assert.Equal(t, "", lua.GetSDPMedia())
+	assert.Nil(t, ex.GetSIPHeaders("Via"))
+	assert.Nil(t, ex.GetSIPURI("ruri"))
+	assert.Equal(t, "", ex.GetSIPBody())
+}
+
+func TestScriptVerdicts(t *testing.T) {
+	hep := &HEP{ProtoType: 1}
+	ex := &ExprEngine{hepPkt: hep}
+	assert.True(t, hep.SendTo(OutputDB))
+
+	ex.SetOutputs("db", "Loki")
+	assert.True(t, hep.SendTo(OutputDB))
+	assert.True(t, hep.SendTo(OutputLoki))
+	assert.False(t, hep.SendTo(OutputES))
+	assert.False(t, hep.SendTo(OutputProm))
+
+	ex.SkipOutput("db")
+	assert.False(t, hep.SendTo(OutputDB))
+	assert.True(t, hep.SendTo(OutputLoki))
+
+	// unknown names leave the outputs alone
+	assert.Error(t, hep.SkipOutput("loki", "kafka"))
+	assert.True(t, hep.SendTo(OutputLoki))
+
+	hep = &HEP{ProtoType: 1}
+	lua := newTestEngine(hep)
+	lua.SkipOutput("es", "lineproto")
+	assert.Equal(t, OutputES|OutputLineproto, hep.SkipOutputs)
+	assert.False(t, hep.Dropped)
+	lua.Drop()
+	assert.True(t, hep.Dropped)
+}
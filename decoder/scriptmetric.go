@@ -0,0 +1,245 @@
+package decoder
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/negbie/logp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sipcapture/heplify-server/config"
+)
+
+var scriptMetricDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "heplify_script_metric_dropped_total",
+	Help: "Script metric updates dropped because the metric reached ScriptMetricMaxSeries"},
+	[]string{"metric"})
+
+var scriptMetricErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "heplify_script_metric_errors_total",
+	Help: "Script metric declarations and updates which failed"},
+	[]string{"metric"})
+
+var scriptMetrics = newMetricSet(prometheus.DefaultRegisterer)
+
+// maxLoggedErrors bounds the distinct errors remembered by errLog.
+const maxLoggedErrors = 1000
+
+var scriptMetricErrLog = &errLog{seen: make(map[string]struct{})}
+
+// errLog logs each distinct error of a metric function once, a script
+// calls them per packet and would flood the log otherwise. Repeats are
+// only counted in heplify_script_metric_errors_total.
+type errLog struct {
+	mu   sync.Mutex
+	seen map[string]struct{}
+}
+
+func (l *errLog) log(fn, name string, err error) bool {
+	if err == nil {
+		return true
+	}
+	scriptMetricErrors.WithLabelValues(name).Inc()
+	key := fn + "\xff" + name + "\xff" + err.Error()
+	l.mu.Lock()
+	_, seen := l.seen[key]
+	if !seen && len(l.seen) < maxLoggedErrors {
+		l.seen[key] = struct{}{}
+	} else {
+		seen = true
+	}
+	l.mu.Unlock()
+	if !seen {
+		logp.Err("%s: %v, repeats are only counted in heplify_script_metric_errors_total", fn, err)
+	}
+	return false
+}
+
+// metricSet holds the metrics declared by scripts. A declaration is
+// kept across script reloads, declaring it again does nothing.
+type metricSet struct {
+	mu      sync.RWMutex
+	reg     prometheus.Registerer
+	metrics map[string]*scriptMetric
+}
+
+type scriptMetric struct {
+	kind      string
+	help      string
+	labels    []string
+	buckets   []float64
+	collector prometheus.Collector
+	counter   *prometheus.CounterVec
+	gauge     *prometheus.GaugeVec
+	histogram *prometheus.HistogramVec
+	mu        sync.Mutex
+	series    map[string]struct{}
+	max       int
+}
+
+func newMetricSet(reg prometheus.Registerer) *metricSet {
+	return &metricSet{reg: reg, metrics: make(map[string]*scriptMetric)}
+}
+
+// define registers a counter, gauge or histogram. The registry keeps
+// the labels and help of a name until restart, so a later declaration
+// may only change the buckets.
+func (s *metricSet) define(kind, name, help string, buckets []float64, labels []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if help == "" {
+		help = "Script metric " + name
+	}
+	if old, ok := s.metrics[name]; ok {
+		if old.kind != kind || old.help != help || !slices.Equal(old.labels, labels) {
+			return fmt.Errorf("metric %s is already defined as %s with labels %v, a restart is needed to change it", name, old.kind, old.labels)
+		}
+		if slices.Equal(old.buckets, buckets) {
+			return nil
+		}
+	}
+
+	m := &scriptMetric{
+		kind:    kind,
+		help:    help,
+		labels:  slices.Clone(labels),
+		buckets: buckets,
+		series:  make(map[string]struct{}),
+		max:     config.Setting.ScriptMetricMaxSeries,
+	}
+	switch kind {
+	case "counter":
+		m.counter = prometheus.NewCounterVec(prometheus.CounterOpts{Name: name, Help: help}, labels)
+		m.collector = m.counter
+	case "gauge":
+		m.gauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: name, Help: help}, labels)
+		m.collector = m.gauge
+	case "histogram":
+		m.histogram = prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: name, Help: help, Buckets: buckets}, labels)
+		m.collector = m.histogram
+	}
+	// the registry refuses a second collector of the same name, so the
+	// new one is checked on its own before it replaces the old one.
+	if err := prometheus.NewRegistry().Register(m.collector); err != nil {
+		return fmt.Errorf("can't register metric %s: %v", name, err)
+	}
+	old, ok := s.metrics[name]
+	if ok {
+		s.reg.Unregister(old.collector)
+	}
+	if err := s.reg.Register(m.collector); err != nil {
+		if ok {
+			s.reg.MustRegister(old.collector)
+		}
+		return fmt.Errorf("can't register metric %s: %v", name, err)
+	}
+	s.metrics[name] = m
+	return nil
+}
+
+// update applies op with value to the series of the label values.
+// Invalid updates are rejected before a new series is counted, new
+// series above the limit of the metric are dropped.
+func (s *metricSet) update(op, name string, value float64, labels []string) error {
+	s.mu.RLock()
+	m, ok := s.metrics[name]
+	s.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("metric %s is not defined", name)
+	}
+	if len(labels) != len(m.labels) {
+		return fmt.Errorf("metric %s needs %d label values %v, got %d", name, len(m.labels), m.labels, len(labels))
+	}
+
+	switch {
+	case m.kind == "counter" && op == "add":
+		if value < 0 {
+			return fmt.Errorf("counter %s can't be decreased", name)
+		}
+	case m.kind == "gauge" && (op == "add" || op == "set"):
+	case m.kind == "histogram" && op == "observe":
+	default:
+		return fmt.Errorf("%s is not supported by %s %s", op, m.kind, name)
+	}
+
+	key := strings.Join(labels, "\xff")
+	m.mu.Lock()
+	if _, ok := m.series[key]; !ok {
+		if m.max > 0 && len(m.series) >= m.max {
+			m.mu.Unlock()
+			scriptMetricDropped.WithLabelValues(name).Inc()
+			return nil
+		}
+		m.series[key] = struct{}{}
+	}
+	m.mu.Unlock()
+
+	switch {
+	case m.kind == "counter":
+		m.counter.WithLabelValues(labels...).Add(value)
+	case m.kind == "gauge" && op == "add":
+		m.gauge.WithLabelValues(labels...).Add(value)
+	case m.kind == "gauge":
+		m.gauge.WithLabelValues(labels...).Set(value)
+	default:
+		m.histogram.WithLabelValues(labels...).Observe(value)
+	}
+	return nil
+}
+
+// parseBuckets parses comma separated increasing bucket bounds, an
+// empty string gives the default buckets.
+func parseBuckets(str string) ([]float64, error) {
+	if strings.TrimSpace(str) == "" {
+		return prometheus.DefBuckets, nil
+	}
+	var buckets []float64
+	for _, b := range strings.Split(str, ",") {
+		f, err := strconv.ParseFloat(strings.TrimSpace(b), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bucket %q", b)
+		}
+		if len(buckets) > 0 && f <= buckets[len(buckets)-1] {
+			return nil, fmt.Errorf("buckets %q are not increasing", str)
+		}
+		buckets = append(buckets, f)
+	}
+	return buckets, nil
+}
+
+// metricFuncs are the metric functions of both script engines.
+func metricFuncs() map[string]any {
+	s := scriptMetrics
+	logErr := scriptMetricErrLog.log
+	return map[string]any{
+		"DefineCounter": func(name, help string, labels ...string) bool {
+			return logErr("DefineCounter", name, s.define("counter", name, help, nil, labels))
+		},
+		"DefineGauge": func(name, help string, labels ...string) bool {
+			return logErr("DefineGauge", name, s.define("gauge", name, help, nil, labels))
+		},
+		"DefineHistogram": func(name, help, buckets string, labels ...string) bool {
+			b, err := parseBuckets(buckets)
+			if err != nil {
+				return logErr("DefineHistogram", name, err)
+			}
+			return logErr("DefineHistogram", name, s.define("histogram", name, help, b, labels))
+		},
+		"IncMetric": func(name string, labels ...string) bool {
+			return logErr("IncMetric", name, s.update("add", name, 1, labels))
+		},
+		"AddMetric": func(name string, value float64, labels ...string) bool {
+			return logErr("AddMetric", name, s.update("add", name, value, labels))
+		},
+		"SetMetric": func(name string, value float64, labels ...string) bool {
+			return logErr("SetMetric", name, s.update("set", name, value, labels))
+		},
+		"ObserveMetric": func(name string, value float64, labels ...string) bool {
+			return logErr("ObserveMetric", name, s.update("observe", name, value, labels))
+		},
+	}
+}
@@ -0,0 +1,95 @@
+package decoder
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/antonmedv/expr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sipcapture/heplify-server/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricSet(t *testing.T) {
+	config.Setting.ScriptMetricMaxSeries = 2
+	reg := prometheus.NewRegistry()
+	s := newMetricSet(reg)
+
+	assert.NoError(t, s.define("counter", "test_calls_total", "", nil, []string{"trunk"}))
+	assert.NoError(t, s.define("counter", "test_calls_total", "", nil, []string{"trunk"}))
+	assert.NoError(t, s.define("gauge", "test_active", "Active calls", nil, nil))
+	assert.NoError(t, s.define("histogram", "test_pdd_seconds", "", []float64{1, 2}, []string{"trunk"}))
+	assert.Error(t, s.define("counter", "bad-name", "", nil, nil))
+
+	assert.NoError(t, s.update("add", "test_calls_total", 1, []string{"a"}))
+	assert.NoError(t, s.update("add", "test_calls_total", 2, []string{"a"}))
+	// failing updates don't use up series
+	assert.Error(t, s.update("set", "test_calls_total", 1, []string{"x"}))
+	assert.Error(t, s.update("add", "test_calls_total", -1, []string{"y"}))
+	assert.NoError(t, s.update("add", "test_calls_total", 1, []string{"b"}))
+	assert.Equal(t, 3.0, testutil.ToFloat64(s.metrics["test_calls_total"].counter.WithLabelValues("a")))
+	assert.Error(t, s.update("add", "test_calls_total", -1, []string{"a"}))
+	assert.Error(t, s.update("add", "test_calls_total", 1, nil))
+	assert.Error(t, s.update("set", "test_calls_total", 1, []string{"a"}))
+	assert.Error(t, s.update("add", "test_unknown", 1, nil))
+
+	// a third trunk is above ScriptMetricMaxSeries
+	dropped := testutil.ToFloat64(scriptMetricDropped.WithLabelValues("test_calls_total"))
+	assert.NoError(t, s.update("add", "test_calls_total", 1, []string{"c"}))
+	assert.Equal(t, dropped+1, testutil.ToFloat64(scriptMetricDropped.WithLabelValues("test_calls_total")))
+	assert.Equal(t, 2, testutil.CollectAndCount(s.metrics["test_calls_total"].collector))
+
+	assert.NoError(t, s.update("set", "test_active", 5, nil))
+	assert.NoError(t, s.update("add", "test_active", -1, nil))
+	assert.Equal(t, 4.0, testutil.ToFloat64(s.metrics["test_active"].gauge))
+	assert.NoError(t, s.update("observe", "test_pdd_seconds", 1.5, []string{"a"}))
+	assert.Error(t, s.update("observe", "test_active", 1, nil))
+
+	// labels are fixed, buckets may change
+	assert.Error(t, s.define("counter", "test_calls_total", "", nil, []string{"trunk", "status"}))
+	assert.Error(t, s.define("gauge", "test_calls_total", "", nil, []string{"trunk"}))
+	assert.NoError(t, s.define("histogram", "test_pdd_seconds", "", []float64{1, 2, 4}, []string{"trunk"}))
+	assert.NoError(t, s.update("observe", "test_pdd_seconds", 3, []string{"a"}))
+	n, err := testutil.GatherAndCount(reg, "test_pdd_seconds")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	// a declaration which can't be registered keeps the old collector
+	old := s.metrics["test_pdd_seconds"]
+	assert.Error(t, s.define("histogram", "test_pdd_seconds", "", []float64{1, 2, 4}, []string{"le"}))
+	assert.Same(t, old, s.metrics["test_pdd_seconds"])
+	n, err = testutil.GatherAndCount(reg, "test_pdd_seconds")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	b, err := parseBuckets("0.5, 1,5")
+	assert.NoError(t, err)
+	assert.Equal(t, []float64{0.5, 1, 5}, b)
+	_, err = parseBuckets("1,x")
+	assert.Error(t, err)
+	_, err = parseBuckets("2,1")
+	assert.Error(t, err)
+}
+
+func TestMetricErrLog(t *testing.T) {
+	l := &errLog{seen: make(map[string]struct{})}
+	before := testutil.ToFloat64(scriptMetricErrors.WithLabelValues("test_errlog"))
+	assert.True(t, l.log("IncMetric", "test_errlog", nil))
+	for i := 0; i < 3; i++ {
+		assert.False(t, l.log("IncMetric", "test_errlog", errors.New("metric test_errlog is not defined")))
+	}
+	assert.Len(t, l.seen, 1, "repeats are logged once")
+	assert.Equal(t, before+3, testutil.ToFloat64(scriptMetricErrors.WithLabelValues("test_errlog")))
+}
+
+func TestMetricFuncsExpr(t *testing.T) {
+	config.Setting.ScriptMetricMaxSeries = 10
+	env := metricFuncs()
+	prog, err := expr.Compile(`DefineGauge("test_expr_gauge", "", "node") && SetMetric("test_expr_gauge", 2, "n1") && AddMetric("test_expr_gauge", 0.5, "n1")`, expr.Env(env))
+	assert.NoError(t, err)
+	out, err := expr.Run(prog, env)
+	assert.NoError(t, err)
+	assert.Equal(t, true, out)
+	assert.Equal(t, 2.5, testutil.ToFloat64(scriptMetrics.metrics["test_expr_gauge"].gauge.WithLabelValues("n1")))
+}
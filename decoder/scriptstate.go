@@ -0,0 +1,361 @@
+package decoder
+
+import (
+	"container/list"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sipcapture/heplify-server/sweep"
+)
+
+// stateOverhead is added to the size of every key for the entry, the
+// index and the list element.
+const stateOverhead = 96
+
+var (
+	stateKeys = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "heplify_script_state_keys",
+		Help: "Keys in the script state"})
+	stateNamespaceKeys = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "heplify_script_state_namespace_keys",
+		Help: "Keys in the script state of the namespaces in ScriptStateNamespaces"},
+		[]string{"namespace"})
+	stateBytes = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "heplify_script_state_bytes",
+		Help: "Approximate memory used by the script state"})
+	stateEvictions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "heplify_script_state_evictions_total",
+		Help: "Keys removed from the script state because they expired or the memory limit was reached"},
+		[]string{"reason"})
+)
+
+var scriptState = NewStateStore(32 * 1024 * 1024)
+
+// ScriptState returns the store which is shared by all script engines.
+// It survives script reloads.
+func ScriptState() *StateStore {
+	return scriptState
+}
+
+// StateStore holds namespaced keys with a string value or a list of
+// timestamped items. Keys expire after their TTL. When the memory limit
+// is reached the least recently used keys are evicted.
+type StateStore struct {
+	mu     sync.Mutex
+	spaces map[string]map[string]*list.Element
+	lru    *list.List
+	counts map[string]int
+	shown  map[string]bool
+	size   int64
+	limit  int64
+	now    func() time.Time
+	sweep  *sweep.Sweeper
+}
+
+type stateEntry struct {
+	ns, key string
+	val     string
+	items   []stateItem
+	expires time.Time
+	size    int64
+}
+
+type stateItem struct {
+	val  string
+	seen time.Time
+}
+
+// NewStateStore returns a store which uses about limit bytes.
+func NewStateStore(limit int64) *StateStore {
+	return &StateStore{
+		spaces: make(map[string]map[string]*list.Element),
+		lru:    list.New(),
+		counts: make(map[string]int),
+		shown:  make(map[string]bool),
+		limit:  limit,
+		now:    time.Now,
+	}
+}
+
+// SetLimit changes the memory limit and evicts keys above it.
+func (s *StateStore) SetLimit(limit int64) {
+	s.mu.Lock()
+	s.limit = limit
+	s.evict()
+	s.mu.Unlock()
+}
+
+// SetMetricNamespaces sets the namespaces which get their own key gauge.
+// Scripts may create any number of namespaces, so the others are only
+// part of the total.
+func (s *StateStore) SetMetricNamespaces(spaces []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for ns := range s.shown {
+		stateNamespaceKeys.DeleteLabelValues(ns)
+	}
+	clear(s.shown)
+	for _, ns := range spaces {
+		if ns != "" {
+			s.shown[ns] = true
+			s.showKeys(ns)
+		}
+	}
+}
+
+// Start runs the expiry sweep until Stop is called.
+func (s *StateStore) Start() {
+	s.sweep = sweep.Start(10*time.Second, s.expire)
+}
+
+func (s *StateStore) Stop() {
+	s.sweep.Stop()
+}
+
+// Get returns the value of a key or "" when it does not exist.
+func (s *StateStore) Get(ns, key string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e := s.lookup(ns, key); e != nil {
+		return e.val
+	}
+	return ""
+}
+
+// Set sets the value of a key. A ttl of 0 seconds keeps it until it is
+// deleted or evicted.
+func (s *StateStore) Set(ns, key, val string, ttl int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.lookup(ns, key)
+	if e == nil {
+		e = s.insert(ns, key)
+	}
+	e.val, e.items = val, nil
+	e.expires = s.expiry(ttl)
+	s.resize(e)
+}
+
+// SetNX sets the value of a key only if it does not exist and reports
+// whether it was set.
+func (s *StateStore) SetNX(ns, key, val string, ttl int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.lookup(ns, key) != nil {
+		return false
+	}
+	e := s.insert(ns, key)
+	e.val = val
+	e.expires = s.expiry(ttl)
+	s.resize(e)
+	return true
+}
+
+// Incr adds delta to the integer value of a key and returns the result.
+// A new key starts at 0 and gets the ttl, later calls keep the expiry,
+// so the counter covers a fixed window.
+func (s *StateStore) Incr(ns, key string, delta, ttl int) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.lookup(ns, key)
+	if e == nil {
+		e = s.insert(ns, key)
+		e.expires = s.expiry(ttl)
+	}
+	n, _ := strconv.Atoi(e.val)
+	n += delta
+	e.val, e.items = strconv.Itoa(n), nil
+	s.resize(e)
+	return n
+}
+
+// Del removes a key and reports whether it existed.
+func (s *StateStore) Del(ns, key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	el, ok := s.spaces[ns][key]
+	if ok {
+		s.remove(el)
+	}
+	return ok
+}
+
+// Push appends a value to the list of a key, keeps the last max items
+// when max is above 0 and returns the length of the list. The ttl is
+// renewed.
+func (s *StateStore) Push(ns, key, val string, max, ttl int) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.lookup(ns, key)
+	if e == nil {
+		e = s.insert(ns, key)
+	}
+	e.val = ""
+	e.items = append(e.items, stateItem{val: val, seen: s.now()})
+	if max > 0 && len(e.items) > max {
+		e.items = append(e.items[:0], e.items[len(e.items)-max:]...)
+	}
+	e.expires = s.expiry(ttl)
+	s.resize(e)
+	return len(e.items)
+}
+
+// List returns the items of a key, the oldest first.
+func (s *StateStore) List(ns, key string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	vals := []string{}
+	if e := s.lookup(ns, key); e != nil {
+		for _, it := range e.items {
+			vals = append(vals, it.val)
+		}
+	}
+	return vals
+}
+
+// Window adds a value to the list of a key, drops the items older than
+// window seconds and returns how many are left. It counts events in a
+// sliding window, the key expires when no event came within the window.
+func (s *StateStore) Window(ns, key, val string, window int) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	e := s.lookup(ns, key)
+	if e == nil {
+		e = s.insert(ns, key)
+	}
+	e.val = ""
+	if window > 0 {
+		from := now.Add(-time.Duration(window) * time.Second)
+		i := 0
+		for i < len(e.items) && !e.items[i].seen.After(from) {
+			i++
+		}
+		e.items = append(e.items[:0], e.items[i:]...)
+	}
+	e.items = append(e.items, stateItem{val: val, seen: now})
+	e.expires = s.expiry(window)
+	s.resize(e)
+	return len(e.items)
+}
+
+// Len returns the number of keys of a namespace.
+func (s *StateStore) Len(ns string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.counts[ns]
+}
+
+// lookup returns the entry of a key which has not expired and marks it
+// as recently used.
+func (s *StateStore) lookup(ns, key string) *stateEntry {
+	el, ok := s.spaces[ns][key]
+	if !ok {
+		return nil
+	}
+	e := el.Value.(*stateEntry)
+	if !e.expires.IsZero() && !s.now().Before(e.expires) {
+		s.remove(el)
+		stateEvictions.WithLabelValues("expired").Inc()
+		return nil
+	}
+	s.lru.MoveToFront(el)
+	return e
+}
+
+func (s *StateStore) insert(ns, key string) *stateEntry {
+	keys, ok := s.spaces[ns]
+	if !ok {
+		keys = make(map[string]*list.Element)
+		s.spaces[ns] = keys
+	}
+	e := &stateEntry{ns: ns, key: key}
+	keys[key] = s.lru.PushFront(e)
+	s.counts[ns]++
+	stateKeys.Set(float64(s.lru.Len()))
+	s.showKeys(ns)
+	return e
+}
+
+func (s *StateStore) showKeys(ns string) {
+	if s.shown[ns] {
+		stateNamespaceKeys.WithLabelValues(ns).Set(float64(s.counts[ns]))
+	}
+}
+
+func (s *StateStore) remove(el *list.Element) {
+	e := s.lru.Remove(el).(*stateEntry)
+	delete(s.spaces[e.ns], e.key)
+	if len(s.spaces[e.ns]) == 0 {
+		delete(s.spaces, e.ns)
+	}
+	s.counts[e.ns]--
+	if s.counts[e.ns] == 0 {
+		delete(s.counts, e.ns)
+	}
+	stateKeys.Set(float64(s.lru.Len()))
+	s.showKeys(e.ns)
+	s.size -= e.size
+	stateBytes.Set(float64(s.size))
+}
+
+// resize updates the size of a changed entry and evicts the least
+// recently used keys above the limit.
+func (s *StateStore) resize(e *stateEntry) {
+	size := int64(stateOverhead + len(e.ns) + len(e.key) + len(e.val))
+	for _, it := range e.items {
+		size += int64(len(it.val)) + 24
+	}
+	s.size += size - e.size
+	e.size = size
+	s.evict()
+	stateBytes.Set(float64(s.size))
+}
+
+func (s *StateStore) evict() {
+	for s.limit > 0 && s.size > s.limit && s.lru.Len() > 0 {
+		s.remove(s.lru.Back())
+		stateEvictions.WithLabelValues("limit").Inc()
+	}
+}
+
+func (s *StateStore) expire(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for el := s.lru.Back(); el != nil; {
+		prev := el.Prev()
+		if e := el.Value.(*stateEntry); !e.expires.IsZero() && !now.Before(e.expires) {
+			s.remove(el)
+			stateEvictions.WithLabelValues("expired").Inc()
+		}
+		el = prev
+	}
+}
+
+func (s *StateStore) expiry(ttl int) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return s.now().Add(time.Duration(ttl) * time.Second)
+}
+
+// stateFuncs are the state functions of both script engines.
+func stateFuncs() map[string]any {
+	s := scriptState
+	return map[string]any{
+		"StateGet": s.Get,
+		"StateSet": func(ns, key, val string, ttl int) bool {
+			s.Set(ns, key, val, ttl)
+			return true
+		},
+		"StateSetNX":  s.SetNX,
+		"StateIncr":   s.Incr,
+		"StateDel":    s.Del,
+		"StatePush":   s.Push,
+		"StateList":   s.List,
+		"StateWindow": s.Window,
+	}
+}
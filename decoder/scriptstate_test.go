@@ -0,0 +1,99 @@
+package decoder
+
+import (
+	"testing"
+	"time"
+
+	"github.com/antonmedv/expr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStateStore(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	s := NewStateStore(0)
+	s.now = func() time.Time { return now }
+
+	s.Set("test", "a", "1", 0)
+	s.Set("other", "a", "2", 60)
+	assert.Equal(t, "1", s.Get("test", "a"))
+	assert.Equal(t, "2", s.Get("other", "a"))
+	assert.False(t, s.SetNX("test", "a", "3", 0))
+	assert.True(t, s.SetNX("test", "b", "3", 0))
+	assert.Equal(t, 2, s.Len("test"))
+	assert.True(t, s.Del("test", "b"))
+	assert.False(t, s.Del("test", "b"))
+
+	// the counter window starts with the first increment
+	assert.Equal(t, 1, s.Incr("test", "fail", 1, 300))
+	now = now.Add(200 * time.Second)
+	assert.Equal(t, 3, s.Incr("test", "fail", 2, 300))
+	now = now.Add(100 * time.Second)
+	assert.Equal(t, 1, s.Incr("test", "fail", 1, 300))
+	assert.Equal(t, "", s.Get("other", "a"))
+
+	assert.Equal(t, 1, s.Push("test", "list", "x", 2, 0))
+	assert.Equal(t, 2, s.Push("test", "list", "y", 2, 0))
+	assert.Equal(t, 2, s.Push("test", "list", "z", 2, 0))
+	assert.Equal(t, []string{"y", "z"}, s.List("test", "list"))
+	assert.Equal(t, []string{}, s.List("test", "none"))
+
+	assert.Equal(t, 1, s.Window("test", "win", "e1", 60))
+	now = now.Add(30 * time.Second)
+	assert.Equal(t, 2, s.Window("test", "win", "e2", 60))
+	now = now.Add(40 * time.Second)
+	assert.Equal(t, 2, s.Window("test", "win", "e3", 60))
+	assert.Equal(t, []string{"e2", "e3"}, s.List("test", "win"))
+
+	expired := testutil.ToFloat64(stateEvictions.WithLabelValues("expired"))
+	s.expire(now.Add(time.Hour))
+	assert.Equal(t, []string{"y", "z"}, s.List("test", "list"))
+	assert.Equal(t, []string{}, s.List("test", "win"))
+	assert.Equal(t, 0, s.Len("other"))
+	assert.Equal(t, expired+2, testutil.ToFloat64(stateEvictions.WithLabelValues("expired")))
+}
+
+func TestStateStoreLimit(t *testing.T) {
+	s := NewStateStore(0)
+	for _, k := range []string{"a", "b", "c", "d"} {
+		s.Set("limit", k, "value", 0)
+	}
+	s.Get("limit", "a")
+
+	evicted := testutil.ToFloat64(stateEvictions.WithLabelValues("limit"))
+	s.SetLimit(int64(2 * (stateOverhead + len("limit") + len("a") + len("value"))))
+	assert.Equal(t, 2, s.Len("limit"))
+	assert.Equal(t, "value", s.Get("limit", "a"))
+	assert.Equal(t, "value", s.Get("limit", "d"))
+	assert.Equal(t, "", s.Get("limit", "b"))
+	assert.Equal(t, evicted+2, testutil.ToFloat64(stateEvictions.WithLabelValues("limit")))
+	assert.Equal(t, 2.0, testutil.ToFloat64(stateKeys))
+}
+
+func TestStateStoreMetricNamespaces(t *testing.T) {
+	s := NewStateStore(0)
+	s.SetMetricNamespaces([]string{"shown", ""})
+	s.Set("shown", "a", "v", 0)
+	s.Set("shown", "b", "v", 0)
+	s.Set("hidden", "a", "v", 0)
+	assert.Equal(t, 3.0, testutil.ToFloat64(stateKeys))
+	assert.Equal(t, 2.0, testutil.ToFloat64(stateNamespaceKeys.WithLabelValues("shown")))
+	assert.Equal(t, 1, testutil.CollectAndCount(stateNamespaceKeys))
+
+	s.SetMetricNamespaces([]string{"hidden"})
+	assert.Equal(t, 1.0, testutil.ToFloat64(stateNamespaceKeys.WithLabelValues("hidden")))
+	assert.Equal(t, 1, testutil.CollectAndCount(stateNamespaceKeys))
+	s.Del("hidden", "a")
+	assert.Equal(t, 0.0, testutil.ToFloat64(stateNamespaceKeys.WithLabelValues("hidden")))
+	s.SetMetricNamespaces(nil)
+}
+
+func TestStateFuncsExpr(t *testing.T) {
+	prog, err := expr.Compile(`StateSet("expr", "k", "v", 0) && StateIncr("expr", "n", 5, 0) == 5 && StateGet("expr", "k") == "v" && len(StateList("expr", "k")) == 0`, expr.Env(stateFuncs()))
+	assert.NoError(t, err)
+	out, err := expr.Run(prog, stateFuncs())
+	assert.NoError(t, err)
+	assert.Equal(t, true, out)
+	scriptState.Del("expr", "k")
+	scriptState.Del("expr", "n")
+}
@@ -17,6 +17,10 @@ func (h *HEP) parseSIP() error {
 	} else if len(h.SIP.CallID) < 1 {
 		return errors.New("could not find a valid Call-ID in packet")
 	}
+	if wantAllHeaders() {
+		// only the data header needs them, so parse errors are ignored
+		h.SIP.ParseAllHeaders()
+	}
 	if h.SIP.FirstMethod == "" {
 		h.SIP.FirstMethod = h.SIP.FirstResp
 	}
@@ -45,3 +49,22 @@ func (h *HEP) parseSIP() error {
 
 	return nil
 }
+
+// allHeaderTags are the SIPHeader tags which need ParseAllHeaders.
+var allHeaderTags = map[string]bool{
+	"via_chain": true, "route": true, "record_route": true,
+	"warning": true, "warning_code": true,
+	"require": true, "supported": true, "unsupported": true,
+	"allow": true, "allow_events": true, "rseq": true, "rack": true,
+	"www_authenticate": true, "proxy_authenticate": true,
+	"subject": true, "content_disposition": true,
+}
+
+func wantAllHeaders() bool {
+	for _, v := range config.Setting.SIPHeader {
+		if allHeaderTags[v] {
+			return true
+		}
+	}
+	return false
+}
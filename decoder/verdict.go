@@ -0,0 +1,80 @@
+package decoder
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Output is a set of outputs a HEP packet is sent to.
+type Output uint8
+
+// Outputs which can be chosen by scripts
+const (
+	OutputDB Output = 1 << iota
+	OutputProm
+	OutputES
+	OutputLoki
+	OutputLineproto
+	OutputForward
+
+	OutputAll = OutputDB | OutputProm | OutputES | OutputLoki | OutputLineproto | OutputForward
+)
+
+var outputNames = map[string]Output{
+	"db":        OutputDB,
+	"prom":      OutputProm,
+	"es":        OutputES,
+	"loki":      OutputLoki,
+	"lineproto": OutputLineproto,
+	"forward":   OutputForward,
+}
+
+// String returns the comma separated names of the outputs.
+func (o Output) String() string {
+	var names []string
+	for n, v := range outputNames {
+		if o&v != 0 {
+			names = append(names, n)
+		}
+	}
+	slices.Sort(names)
+	return strings.Join(names, ",")
+}
+
+func parseOutputs(names []string) (Output, error) {
+	var o Output
+	for _, n := range names {
+		v, ok := outputNames[strings.ToLower(strings.TrimSpace(n))]
+		if !ok {
+			return 0, fmt.Errorf("unknown output %q", n)
+		}
+		o |= v
+	}
+	return o, nil
+}
+
+// SetOutputs sends the packet only to the named outputs.
+func (h *HEP) SetOutputs(names ...string) error {
+	o, err := parseOutputs(names)
+	if err != nil {
+		return err
+	}
+	h.SkipOutputs = OutputAll &^ o
+	return nil
+}
+
+// SkipOutput keeps the packet away from the named outputs.
+func (h *HEP) SkipOutput(names ...string) error {
+	o, err := parseOutputs(names)
+	if err != nil {
+		return err
+	}
+	h.SkipOutputs |= o
+	return nil
+}
+
+// SendTo reports whether the packet goes to the output.
+func (h *HEP) SendTo(o Output) bool {
+	return h.SkipOutputs&o == 0
+}
@@ -0,0 +1,235 @@
+package dialog
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sipcapture/heplify-server/decoder"
+	"github.com/sipcapture/heplify-server/sipparser"
+	"github.com/sipcapture/heplify-server/sweep"
+)
+
+const (
+	register = "REGISTER"
+
+	// defaultExpires applies when neither the request nor the answer
+	// carries an expiry.
+	defaultExpires = 3600
+	// expireGrace is added to the expiry before a binding without refresh
+	// counts as expired.
+	expireGrace = 30 * time.Second
+	// pendingWait is how long a REGISTER waits for its final response.
+	pendingWait = 64 * time.Second
+)
+
+// Registrar follows REGISTER transactions and keeps the currently
+// registered contacts. Process is safe for concurrent use.
+type Registrar struct {
+	mu       sync.Mutex
+	bindings map[string]*decoder.Binding
+	pending  map[string]pendingRegister
+	emit     func(*decoder.HEP)
+	sweep    *sweep.Sweeper
+}
+
+type pendingRegister struct {
+	authUser string
+	expires  int
+	seen     time.Time
+}
+
+// NewRegistrar returns a registrar which hands every added, removed,
+// expired or failed binding as HEP record to emit.
+func NewRegistrar(emit func(*decoder.HEP)) *Registrar {
+	return &Registrar{
+		bindings: make(map[string]*decoder.Binding),
+		pending:  make(map[string]pendingRegister),
+		emit:     emit,
+	}
+}
+
+// Start runs the expiry sweep until Stop is called.
+func (r *Registrar) Start() {
+	r.sweep = sweep.Start(time.Second, r.expire)
+}
+
+func (r *Registrar) Stop() {
+	r.sweep.Stop()
+}
+
+// Process updates the bindings with a REGISTER request or response.
+func (r *Registrar) Process(pkt *decoder.HEP) {
+	if pkt.ProtoType != 1 || pkt.SIP == nil || pkt.SIP.CseqMethod != register {
+		return
+	}
+	s := pkt.SIP
+	now := time.Now()
+	tkey := s.CallID + "\x00" + s.CseqVal
+
+	if s.FirstResp == "" {
+		r.mu.Lock()
+		r.pending[tkey] = pendingRegister{authUser: s.AuthUser, expires: expiresOf(s), seen: now}
+		r.mu.Unlock()
+		return
+	}
+
+	code, _ := strconv.Atoi(s.FirstResp)
+	if code < 200 {
+		return
+	}
+	aor := s.ToHost
+	if s.ToUser != "" {
+		aor = s.ToUser + "@" + s.ToHost
+	}
+
+	r.mu.Lock()
+	p, found := r.pending[tkey]
+	delete(r.pending, tkey)
+
+	var events []*decoder.Binding
+	switch {
+	case code < 300:
+		contact := contactURI(s)
+		if contact == "" {
+			break
+		}
+		exp := expiresOf(s)
+		if exp < 0 && found {
+			exp = p.expires
+		}
+		if exp < 0 {
+			exp = defaultExpires
+		}
+		bkey := aor + "\x00" + contact
+		b, ok := r.bindings[bkey]
+		if exp == 0 {
+			if ok {
+				delete(r.bindings, bkey)
+				ev := *b
+				ev.Event = decoder.BindingRemoved
+				events = append(events, &ev)
+			}
+			break
+		}
+		if !ok {
+			b = &decoder.Binding{AoR: aor, Domain: s.ToHost, Contact: contact}
+			r.bindings[bkey] = b
+		}
+		if p.authUser != "" {
+			b.AuthUser = p.authUser
+		}
+		b.SrcIP, b.DstIP = pkt.DstIP, pkt.SrcIP
+		b.Expires = now.Add(time.Duration(exp) * time.Second)
+		b.LastSeen = now
+		if !ok {
+			ev := *b
+			ev.Event = decoder.BindingAdded
+			events = append(events, &ev)
+		}
+	case code == 401 || code == 403 || code == 407 || code >= 500:
+		events = append(events, &decoder.Binding{
+			AoR:      aor,
+			Domain:   s.ToHost,
+			AuthUser: p.authUser,
+			SrcIP:    pkt.DstIP,
+			DstIP:    pkt.SrcIP,
+			LastSeen: now,
+			Event:    decoder.BindingFailed,
+			Response: code,
+		})
+	}
+	r.mu.Unlock()
+
+	for _, ev := range events {
+		r.send(ev)
+	}
+}
+
+func (r *Registrar) expire(now time.Time) {
+	var events []*decoder.Binding
+	r.mu.Lock()
+	for key, b := range r.bindings {
+		if now.After(b.Expires.Add(expireGrace)) {
+			delete(r.bindings, key)
+			ev := *b
+			ev.Event = decoder.BindingExpired
+			events = append(events, &ev)
+		}
+	}
+	for key, p := range r.pending {
+		if now.Sub(p.seen) > pendingWait {
+			delete(r.pending, key)
+		}
+	}
+	r.mu.Unlock()
+
+	for _, ev := range events {
+		r.send(ev)
+	}
+}
+
+func (r *Registrar) send(b *decoder.Binding) {
+	r.emit(&decoder.HEP{
+		ProtoType:   1,
+		ProtoString: "binding",
+		SrcIP:       b.SrcIP,
+		DstIP:       b.DstIP,
+		Timestamp:   b.LastSeen,
+		Binding:     b,
+	})
+}
+
+// Bindings returns a copy of the current bindings ordered by AoR and
+// contact.
+func (r *Registrar) Bindings() []decoder.Binding {
+	r.mu.Lock()
+	list := make([]decoder.Binding, 0, len(r.bindings))
+	for _, b := range r.bindings {
+		list = append(list, *b)
+	}
+	r.mu.Unlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].AoR != list[j].AoR {
+			return list[i].AoR < list[j].AoR
+		}
+		return list[i].Contact < list[j].Contact
+	})
+	return list
+}
+
+// expiresOf returns the expires parameter of the Contact or else the
+// Expires header, -1 if there is neither.
+func expiresOf(s *sipparser.SipMsg) int {
+	v := strings.ToLower(s.ContactVal)
+	if i := strings.Index(v, ";expires="); i >= 0 {
+		v = v[i+len(";expires="):]
+		if end := strings.IndexAny(v, ";,> "); end >= 0 {
+			v = v[:end]
+		}
+		if n, err := strconv.Atoi(v); err == nil {
+			return n
+		}
+	}
+	if n, err := strconv.Atoi(strings.TrimSpace(s.Expires)); err == nil {
+		return n
+	}
+	return -1
+}
+
+func contactURI(s *sipparser.SipMsg) string {
+	if s.ContactHost == "" {
+		return ""
+	}
+	c := s.ContactHost
+	if s.ContactUser != "" {
+		c = s.ContactUser + "@" + c
+	}
+	if s.ContactPort > 0 {
+		c += ":" + strconv.Itoa(s.ContactPort)
+	}
+	return c
+}
@@ -0,0 +1,65 @@
+package dialog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sipcapture/heplify-server/decoder"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistrar(t *testing.T) {
+	var events []*decoder.Binding
+	r := NewRegistrar(func(pkt *decoder.HEP) {
+		events = append(events, pkt.Binding)
+	})
+	const (
+		req     = "REGISTER sip:example.com SIP/2.0"
+		contact = "Contact: <sip:bob@10.0.0.1:5060>"
+		auth    = `Authorization: Digest username="bob100", realm="example.com", nonce="abc", uri="sip:example.com", response="def"`
+	)
+
+	r.Process(sip(t, 0, req, "r1", "", "1 REGISTER", contact, "Expires: 600"))
+	r.Process(sip(t, 0, "SIP/2.0 401 Unauthorized", "r1", "x1", "1 REGISTER"))
+	r.Process(sip(t, 0, req, "r1", "", "2 REGISTER", contact, "Expires: 600", auth))
+	r.Process(sip(t, 0, "SIP/2.0 200 OK", "r1", "x2", "2 REGISTER", contact+";expires=300"))
+	// refresh
+	r.Process(sip(t, 0, req, "r1", "", "3 REGISTER", contact, "Expires: 600", auth))
+	r.Process(sip(t, 0, "SIP/2.0 200 OK", "r1", "x3", "3 REGISTER", contact+";expires=300"))
+
+	if assert.Len(t, events, 2) {
+		assert.Equal(t, decoder.BindingFailed, events[0].Event)
+		assert.Equal(t, 401, events[0].Response)
+		assert.Equal(t, "bob@example.com", events[0].AoR)
+		assert.Equal(t, decoder.BindingAdded, events[1].Event)
+	}
+
+	list := r.Bindings()
+	if assert.Len(t, list, 1) {
+		b := list[0]
+		assert.Equal(t, "bob@example.com", b.AoR)
+		assert.Equal(t, "example.com", b.Domain)
+		assert.Equal(t, "bob@10.0.0.1:5060", b.Contact)
+		assert.Equal(t, "bob100", b.AuthUser)
+		assert.Equal(t, "10.0.0.2", b.SrcIP)
+		assert.WithinDuration(t, time.Now().Add(300*time.Second), b.Expires, 5*time.Second)
+	}
+
+	r.expire(time.Now().Add(310 * time.Second))
+	assert.Len(t, events, 2)
+	r.expire(time.Now().Add(time.Hour))
+	if assert.Len(t, events, 3) {
+		assert.Equal(t, decoder.BindingExpired, events[2].Event)
+	}
+	assert.Empty(t, r.Bindings())
+	assert.Empty(t, r.pending)
+
+	// unregister without a request in the capture
+	r.Process(sip(t, 0, "SIP/2.0 200 OK", "r2", "x4", "1 REGISTER", contact))
+	r.Process(sip(t, 0, "SIP/2.0 200 OK", "r2", "x5", "2 REGISTER", contact, "Expires: 0"))
+	if assert.Len(t, events, 5) {
+		assert.Equal(t, decoder.BindingAdded, events[3].Event)
+		assert.Equal(t, decoder.BindingRemoved, events[4].Event)
+	}
+	assert.Empty(t, r.Bindings())
+}
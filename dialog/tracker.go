@@ -0,0 +1,329 @@
+// Package dialog follows SIP INVITE dialogs and summarises every call in a
+// CDR once it is released, rejected, cancelled or times out. It also keeps
+// the contacts registered through REGISTER transactions.
+package dialog
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/negbie/logp"
+	"github.com/sipcapture/heplify-server/decoder"
+	"github.com/sipcapture/heplify-server/sweep"
+)
+
+const (
+	invite = "INVITE"
+	cancel = "CANCEL"
+	bye    = "BYE"
+
+	// cancelWait is how long a cancelled call waits for its final
+	// response, it matches the SIP transaction timeout.
+	cancelWait = 32 * time.Second
+)
+
+// Tracker keeps the state of all calls in progress. Process is safe for
+// concurrent use.
+type Tracker struct {
+	mu           sync.Mutex
+	calls        map[string]*call
+	emit         func(*decoder.HEP)
+	setupTimeout time.Duration
+	idleTimeout  time.Duration
+	sweep        *sweep.Sweeper
+}
+
+type call struct {
+	cdr      decoder.CDR
+	cseq     string
+	stale    bool
+	cancel   bool
+	seen     time.Time
+	version  uint32
+	protocol uint32
+	srcPort  uint32
+	dstPort  uint32
+	nodeID   uint32
+	nodeName string
+}
+
+// New returns a tracker which hands every finished call as HEP record to
+// emit. Calls without final response end after setupTimeout, answered
+// calls after idleTimeout without any in-dialog message.
+func New(setupTimeout, idleTimeout time.Duration, emit func(*decoder.HEP)) *Tracker {
+	return &Tracker{
+		calls:        make(map[string]*call),
+		emit:         emit,
+		setupTimeout: setupTimeout,
+		idleTimeout:  idleTimeout,
+	}
+}
+
+// Start runs the timeout sweep until Stop is called. Calls still in
+// progress at Stop are dropped.
+func (t *Tracker) Start() {
+	t.sweep = sweep.Start(time.Second, t.expire)
+}
+
+func (t *Tracker) Stop() {
+	t.sweep.Stop()
+}
+
+// Process updates the call state with a SIP message.
+func (t *Tracker) Process(pkt *decoder.HEP) {
+	if pkt.ProtoType != 1 || pkt.SIP == nil || pkt.SIP.CallID == "" {
+		return
+	}
+	s := pkt.SIP
+	switch s.CseqMethod {
+	case invite, cancel, bye:
+	default:
+		t.touch(pkt)
+		return
+	}
+
+	t.mu.Lock()
+	c, key := t.lookup(s.CallID, s.FromTag, s.ToTag)
+	ts := pkt.Timestamp
+	code, _ := strconv.Atoi(s.FirstResp)
+	isReq := s.FirstResp == ""
+
+	if c == nil {
+		// Only the initial INVITE or a provisional or successful answer to
+		// it opens a call. Workers don't keep the order of the messages, so
+		// the answer can be seen first.
+		if s.CseqMethod != invite || isReq && s.ToTag != "" || !isReq && code >= 300 {
+			t.mu.Unlock()
+			return
+		}
+		c = &call{
+			cdr: decoder.CDR{
+				CallID:   s.CallID,
+				FromTag:  s.FromTag,
+				FromUser: s.FromUser,
+				ToUser:   s.ToUser,
+			},
+			cseq: s.CseqVal,
+		}
+		key = s.CallID + "\x00" + s.FromTag
+		t.calls[key] = c
+	}
+	c.seen = time.Now()
+
+	// After a challenge or another retryable rejection the caller sends
+	// the INVITE again with a higher CSeq, the call goes on with it.
+	if s.CseqMethod == invite && (!isReq || s.ToTag == "") && c.cdr.AnswerTime.IsZero() &&
+		cseqNum(s.CseqVal) > cseqNum(c.cseq) {
+		c.cseq = s.CseqVal
+		c.stale = true
+		c.cdr.FinalResponse = 0
+		c.cdr.FinalReason = ""
+	}
+
+	var done bool
+	switch {
+	case isReq && s.FirstMethod == invite:
+		if s.ToTag == "" && s.CseqVal == c.cseq {
+			if c.cdr.SetupTime.IsZero() || c.stale || ts.Before(c.cdr.SetupTime) {
+				c.stale = false
+				c.cdr.SetupTime = ts
+				c.cdr.RuriUser = s.URIUser
+				c.cdr.SrcIP, c.cdr.DstIP = pkt.SrcIP, pkt.DstIP
+				c.version, c.protocol = pkt.Version, pkt.Protocol
+				c.srcPort, c.dstPort = pkt.SrcPort, pkt.DstPort
+				c.nodeID, c.nodeName = pkt.NodeID, pkt.NodeName
+			}
+		}
+	case isReq && s.FirstMethod == cancel:
+		if c.cdr.AnswerTime.IsZero() && !c.cancel {
+			c.cancel = true
+			c.cdr.EndTime = ts
+			c.cdr.Disconnect = "caller"
+			c.cdr.Reason = s.ReasonVal
+		}
+	case isReq && s.FirstMethod == bye:
+		c.cdr.EndTime = ts
+		c.cdr.Reason = s.ReasonVal
+		c.cdr.Disconnect = "caller"
+		if s.FromTag != c.cdr.FromTag {
+			c.cdr.Disconnect = "callee"
+		}
+		done = true
+	case !isReq && s.CseqMethod == invite && s.CseqVal == c.cseq:
+		switch {
+		case code >= 180 && code < 200:
+			if c.cdr.RingTime.IsZero() || ts.Before(c.cdr.RingTime) {
+				c.cdr.RingTime = ts
+			}
+		case code >= 200 && code < 300:
+			if c.cdr.AnswerTime.IsZero() || ts.Before(c.cdr.AnswerTime) {
+				c.cdr.AnswerTime = ts
+				c.cdr.ToTag = s.ToTag
+				c.cdr.FinalResponse = code
+				c.cdr.FinalReason = s.FirstRespText
+				c.cancel = false
+				c.cdr.Disconnect = ""
+				c.cdr.Reason = ""
+			}
+		case retryable(code) && c.cdr.AnswerTime.IsZero():
+			// the call fails only if no new INVITE follows
+			c.cdr.FinalResponse = code
+			c.cdr.FinalReason = s.FirstRespText
+			c.cdr.EndTime = ts
+		case code >= 300 && c.cdr.AnswerTime.IsZero():
+			c.cdr.FinalResponse = code
+			c.cdr.FinalReason = s.FirstRespText
+			if !c.cancel {
+				c.cdr.EndTime = ts
+				c.cdr.Disconnect = "callee"
+				c.cdr.Reason = s.ReasonVal
+				if code == 487 {
+					// the CANCEL itself was not captured
+					c.cancel = true
+					c.cdr.Disconnect = "caller"
+				}
+			}
+			done = true
+		}
+	}
+
+	if done {
+		delete(t.calls, key)
+	}
+	t.mu.Unlock()
+
+	if done {
+		t.finish(c, "")
+	}
+}
+
+// retryable reports whether a final response to an INVITE is usually
+// followed by a new INVITE of the same call: digest challenges, Session
+// Interval Too Small and Request Pending.
+func retryable(code int) bool {
+	switch code {
+	case 401, 407, 422, 491:
+		return true
+	}
+	return false
+}
+
+// cseqNum returns the sequence number of a CSeq header value.
+func cseqNum(v string) int {
+	num, _, _ := strings.Cut(strings.TrimSpace(v), " ")
+	n, _ := strconv.Atoi(num)
+	return n
+}
+
+// touch refreshes the idle timer of a known call on any in-dialog request.
+func (t *Tracker) touch(pkt *decoder.HEP) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if c, _ := t.lookup(pkt.SIP.CallID, pkt.SIP.FromTag, pkt.SIP.ToTag); c != nil {
+		c.seen = time.Now()
+		if !c.cancel && pkt.Timestamp.After(c.cdr.EndTime) {
+			c.cdr.EndTime = pkt.Timestamp
+		}
+	}
+}
+
+// lookup finds a call by the tag of either side, requests from the callee
+// carry the tag of the caller in the To header.
+func (t *Tracker) lookup(callID, fromTag, toTag string) (*call, string) {
+	key := callID + "\x00" + fromTag
+	if c, ok := t.calls[key]; ok {
+		return c, key
+	}
+	if toTag != "" {
+		key = callID + "\x00" + toTag
+		if c, ok := t.calls[key]; ok {
+			return c, key
+		}
+	}
+	return nil, ""
+}
+
+func (t *Tracker) expire(now time.Time) {
+	var ended []*call
+	t.mu.Lock()
+	for key, c := range t.calls {
+		idle := now.Sub(c.seen)
+		switch {
+		case c.cancel && idle > cancelWait,
+			c.cdr.AnswerTime.IsZero() && idle > t.setupTimeout,
+			!c.cdr.AnswerTime.IsZero() && idle > t.idleTimeout:
+			delete(t.calls, key)
+			ended = append(ended, c)
+		}
+	}
+	t.mu.Unlock()
+
+	for _, c := range ended {
+		if c.cancel {
+			t.finish(c, "")
+		} else {
+			t.finish(c, "timeout")
+		}
+	}
+}
+
+func (t *Tracker) finish(c *call, disconnect string) {
+	cdr := &c.cdr
+	if cdr.SetupTime.IsZero() {
+		// the INVITE itself was never seen
+		return
+	}
+	if disconnect != "" {
+		cdr.Disconnect = disconnect
+	}
+	if cdr.EndTime.Before(cdr.SetupTime) {
+		cdr.EndTime = cdr.SetupTime
+	}
+	if !cdr.AnswerTime.IsZero() && cdr.EndTime.Before(cdr.AnswerTime) {
+		cdr.EndTime = cdr.AnswerTime
+	}
+
+	switch {
+	case !cdr.AnswerTime.IsZero():
+		cdr.Status = decoder.CDRAnswered
+		cdr.Duration = cdr.EndTime.Sub(cdr.AnswerTime).Seconds()
+	case c.cancel:
+		cdr.Status = decoder.CDRCancelled
+	case cdr.FinalResponse >= 300:
+		cdr.Status = decoder.CDRFailed
+	default:
+		cdr.Status = decoder.CDRTimeout
+	}
+	if !cdr.RingTime.IsZero() {
+		cdr.PDD = cdr.RingTime.Sub(cdr.SetupTime).Seconds()
+	}
+
+	payload, err := json.Marshal(cdr)
+	if err != nil {
+		logp.Err("cdr of %s: %v", cdr.CallID, err)
+		return
+	}
+
+	t.emit(&decoder.HEP{
+		Version:     c.version,
+		Protocol:    c.protocol,
+		SrcIP:       cdr.SrcIP,
+		DstIP:       cdr.DstIP,
+		SrcPort:     c.srcPort,
+		DstPort:     c.dstPort,
+		Tsec:        uint32(cdr.EndTime.Unix()),
+		Tmsec:       uint32(cdr.EndTime.Nanosecond() / 1000),
+		ProtoType:   1,
+		ProtoString: "cdr",
+		NodeID:      c.nodeID,
+		NodeName:    c.nodeName,
+		Payload:     string(payload),
+		CID:         cdr.CallID,
+		SID:         cdr.CallID,
+		Timestamp:   cdr.EndTime,
+		CDR:         cdr,
+	})
+}
@@ -0,0 +1,203 @@
+package dialog
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/sipcapture/heplify-server/decoder"
+	"github.com/stretchr/testify/assert"
+)
+
+var start = time.Unix(1577934245, 0)
+
+func sip(t *testing.T, offset time.Duration, firstLine, fromTag, toTag, cseq string, extra ...string) *decoder.HEP {
+	to := "<sip:bob@example.com>"
+	if toTag != "" {
+		to += ";tag=" + toTag
+	}
+	msg := fmt.Sprintf("%s\r\nVia: SIP/2.0/UDP 10.0.0.1:5060;branch=z9hG4bK1\r\nFrom: <sip:alice@example.com>;tag=%s\r\nTo: %s\r\nCall-ID: call-1@example.com\r\nCSeq: %s\r\n",
+		firstLine, fromTag, to, cseq)
+	for _, h := range extra {
+		msg += h + "\r\n"
+	}
+	msg += "Content-Length: 0\r\n\r\n"
+
+	ts := start.Add(offset)
+	raw, err := (&decoder.HEP{
+		Version:   2,
+		Protocol:  17,
+		SrcIP:     "10.0.0.1",
+		DstIP:     "10.0.0.2",
+		SrcPort:   5060,
+		DstPort:   5060,
+		Tsec:      uint32(ts.Unix()),
+		Tmsec:     uint32(ts.Nanosecond() / 1000),
+		ProtoType: 1,
+		NodeID:    2001,
+		Payload:   msg,
+	}).MarshalHEP3()
+	assert.NoError(t, err)
+	pkt, err := decoder.DecodeHEP(raw)
+	assert.NoError(t, err)
+	return pkt
+}
+
+func newTracker() (*Tracker, *[]*decoder.CDR) {
+	var cdrs []*decoder.CDR
+	tr := New(time.Minute, time.Hour, func(pkt *decoder.HEP) {
+		cdrs = append(cdrs, pkt.CDR)
+	})
+	return tr, &cdrs
+}
+
+func TestAnsweredCall(t *testing.T) {
+	tr, cdrs := newTracker()
+	const invite = "INVITE sip:bob@example.com SIP/2.0"
+
+	// the answer is processed before the INVITE
+	tr.Process(sip(t, 3*time.Second, "SIP/2.0 200 OK", "a1", "b1", "1 INVITE"))
+	tr.Process(sip(t, 0, invite, "a1", "", "1 INVITE"))
+	tr.Process(sip(t, 0, invite, "a1", "", "1 INVITE"))
+	tr.Process(sip(t, time.Second, "SIP/2.0 180 Ringing", "a1", "b1", "1 INVITE"))
+	tr.Process(sip(t, 3*time.Second, "ACK sip:bob@example.com SIP/2.0", "a1", "b1", "1 ACK"))
+	tr.Process(sip(t, 30*time.Second, invite, "a1", "b1", "2 INVITE"))
+	tr.Process(sip(t, 31*time.Second, "SIP/2.0 488 Not Acceptable Here", "a1", "b1", "2 INVITE"))
+	assert.Empty(t, *cdrs)
+
+	// the callee hangs up
+	tr.Process(sip(t, 63*time.Second, "BYE sip:alice@example.com SIP/2.0", "b1", "a1", "1 BYE", "Reason: Q.850;cause=16"))
+	tr.Process(sip(t, 63*time.Second, "SIP/2.0 200 OK", "b1", "a1", "1 BYE"))
+	assert.Empty(t, tr.calls)
+
+	if assert.Len(t, *cdrs, 1) {
+		c := (*cdrs)[0]
+		assert.Equal(t, "call-1@example.com", c.CallID)
+		assert.Equal(t, "a1", c.FromTag)
+		assert.Equal(t, "b1", c.ToTag)
+		assert.Equal(t, "bob", c.RuriUser)
+		assert.Equal(t, start, c.SetupTime)
+		assert.Equal(t, start.Add(time.Second), c.RingTime)
+		assert.Equal(t, start.Add(3*time.Second), c.AnswerTime)
+		assert.Equal(t, start.Add(63*time.Second), c.EndTime)
+		assert.Equal(t, 1.0, c.PDD)
+		assert.Equal(t, 60.0, c.Duration)
+		assert.Equal(t, 200, c.FinalResponse)
+		assert.Equal(t, decoder.CDRAnswered, c.Status)
+		assert.Equal(t, "callee", c.Disconnect)
+		assert.Equal(t, "Q.850;cause=16", c.Reason)
+	}
+}
+
+func TestFailedCalls(t *testing.T) {
+	tr, cdrs := newTracker()
+	const invite = "INVITE sip:bob@example.com SIP/2.0"
+
+	tr.Process(sip(t, 0, invite, "a1", "", "1 INVITE"))
+	tr.Process(sip(t, 2*time.Second, "SIP/2.0 486 Busy Here", "a1", "b1", "1 INVITE", "Reason: Q.850;cause=17"))
+
+	tr.Process(sip(t, 0, invite, "a2", "", "1 INVITE"))
+	tr.Process(sip(t, time.Second, "SIP/2.0 183 Session Progress", "a2", "b2", "1 INVITE"))
+	tr.Process(sip(t, 5*time.Second, "CANCEL sip:bob@example.com SIP/2.0", "a2", "", "1 CANCEL"))
+	tr.Process(sip(t, 5*time.Second, "SIP/2.0 200 OK", "a2", "", "1 CANCEL"))
+	tr.Process(sip(t, 5*time.Second, "SIP/2.0 487 Request Terminated", "a2", "b2", "1 INVITE"))
+	assert.Empty(t, tr.calls)
+
+	if assert.Len(t, *cdrs, 2) {
+		c := (*cdrs)[0]
+		assert.Equal(t, decoder.CDRFailed, c.Status)
+		assert.Equal(t, 486, c.FinalResponse)
+		assert.Equal(t, "Busy Here", c.FinalReason)
+		assert.Equal(t, "callee", c.Disconnect)
+		assert.Equal(t, "Q.850;cause=17", c.Reason)
+		assert.Equal(t, start.Add(2*time.Second), c.EndTime)
+		assert.Zero(t, c.Duration)
+
+		c = (*cdrs)[1]
+		assert.Equal(t, decoder.CDRCancelled, c.Status)
+		assert.Equal(t, 487, c.FinalResponse)
+		assert.Equal(t, "caller", c.Disconnect)
+		assert.Equal(t, start.Add(5*time.Second), c.EndTime)
+		assert.Equal(t, 1.0, c.PDD)
+	}
+}
+
+func TestAuthenticatedCall(t *testing.T) {
+	tr, cdrs := newTracker()
+	const invite = "INVITE sip:bob@example.com SIP/2.0"
+
+	tr.Process(sip(t, 0, invite, "a1", "", "1 INVITE"))
+	tr.Process(sip(t, 100*time.Millisecond, "SIP/2.0 407 Proxy Authentication Required", "a1", "p1", "1 INVITE"))
+	tr.Process(sip(t, 200*time.Millisecond, "ACK sip:bob@example.com SIP/2.0", "a1", "p1", "1 ACK"))
+	tr.Process(sip(t, time.Second, invite, "a1", "", "2 INVITE", "Proxy-Authorization: Digest username=\"alice\""))
+	tr.Process(sip(t, 2*time.Second, "SIP/2.0 180 Ringing", "a1", "b1", "2 INVITE"))
+	tr.Process(sip(t, 4*time.Second, "SIP/2.0 200 OK", "a1", "b1", "2 INVITE"))
+	tr.Process(sip(t, 4*time.Second, "ACK sip:bob@example.com SIP/2.0", "a1", "b1", "2 ACK"))
+	assert.Empty(t, *cdrs)
+	tr.Process(sip(t, 14*time.Second, "BYE sip:bob@example.com SIP/2.0", "a1", "b1", "3 BYE"))
+	assert.Empty(t, tr.calls)
+
+	if assert.Len(t, *cdrs, 1) {
+		c := (*cdrs)[0]
+		assert.Equal(t, decoder.CDRAnswered, c.Status)
+		assert.Equal(t, 200, c.FinalResponse)
+		assert.Equal(t, start.Add(time.Second), c.SetupTime)
+		assert.Equal(t, 1.0, c.PDD)
+		assert.Equal(t, 10.0, c.Duration)
+	}
+
+	// the answer to the second INVITE is processed first
+	*cdrs = nil
+	tr.Process(sip(t, 0, invite, "a2", "", "1 INVITE"))
+	tr.Process(sip(t, 100*time.Millisecond, "SIP/2.0 401 Unauthorized", "a2", "p2", "1 INVITE"))
+	tr.Process(sip(t, 2*time.Second, "SIP/2.0 200 OK", "a2", "b2", "2 INVITE"))
+	tr.Process(sip(t, time.Second, invite, "a2", "", "2 INVITE"))
+	tr.Process(sip(t, 5*time.Second, "BYE sip:bob@example.com SIP/2.0", "b2", "a2", "1 BYE"))
+	if assert.Len(t, *cdrs, 1) {
+		c := (*cdrs)[0]
+		assert.Equal(t, decoder.CDRAnswered, c.Status)
+		assert.Equal(t, start.Add(time.Second), c.SetupTime)
+	}
+
+	// a challenge without a new INVITE fails after the setup timeout
+	*cdrs = nil
+	tr.Process(sip(t, 0, invite, "a3", "", "1 INVITE"))
+	tr.Process(sip(t, time.Second, "SIP/2.0 407 Proxy Authentication Required", "a3", "p3", "1 INVITE"))
+	assert.Empty(t, *cdrs)
+	tr.expire(time.Now().Add(2 * time.Minute))
+	if assert.Len(t, *cdrs, 1) {
+		c := (*cdrs)[0]
+		assert.Equal(t, decoder.CDRFailed, c.Status)
+		assert.Equal(t, 407, c.FinalResponse)
+		assert.Equal(t, start.Add(time.Second), c.EndTime)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	tr, cdrs := newTracker()
+	const invite = "INVITE sip:bob@example.com SIP/2.0"
+
+	tr.Process(sip(t, 0, invite, "a1", "", "1 INVITE"))
+	tr.Process(sip(t, 0, invite, "a2", "", "1 INVITE"))
+	tr.Process(sip(t, time.Second, "SIP/2.0 200 OK", "a2", "b2", "1 INVITE"))
+	tr.Process(sip(t, 10*time.Second, "INFO sip:bob@example.com SIP/2.0", "a2", "b2", "2 INFO"))
+
+	tr.expire(time.Now())
+	assert.Empty(t, *cdrs)
+	tr.expire(time.Now().Add(2 * time.Minute))
+	if assert.Len(t, *cdrs, 1) {
+		c := (*cdrs)[0]
+		assert.Equal(t, "a1", c.FromTag)
+		assert.Equal(t, decoder.CDRTimeout, c.Status)
+		assert.Equal(t, "timeout", c.Disconnect)
+		assert.Equal(t, start, c.EndTime)
+	}
+	tr.expire(time.Now().Add(2 * time.Hour))
+	if assert.Len(t, *cdrs, 2) {
+		c := (*cdrs)[1]
+		assert.Equal(t, decoder.CDRAnswered, c.Status)
+		assert.Equal(t, "timeout", c.Disconnect)
+		assert.Equal(t, 9.0, c.Duration)
+	}
+	assert.Empty(t, tr.calls)
+}
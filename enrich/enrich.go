@@ -0,0 +1,167 @@
+// Package enrich tags packets with attributes like customer, trunk,
+// carrier or country. They come from lookup tables which map networks
+// or number prefixes to attributes and from a GeoIP database.
+package enrich
+
+import (
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+)
+
+// Data holds the loaded tables and GeoIP database. It is not changed
+// after Load, a reload builds a new one.
+type Data struct {
+	Tables map[string]*Table
+	Geo    *GeoDB
+	names  []string
+}
+
+var active atomic.Pointer[Data]
+
+// Active returns the data used for new packets or nil.
+func Active() *Data {
+	return active.Load()
+}
+
+// Store makes d the data used for new packets.
+func Store(d *Data) {
+	active.Store(d)
+}
+
+// IsTable reports whether a file name is loaded as table.
+func IsTable(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".csv", ".json":
+		return true
+	}
+	return false
+}
+
+// Load reads all tables of folder and the GeoIP database geoFile.
+// Both are optional.
+func Load(folder, geoFile string) (*Data, error) {
+	d := &Data{Tables: make(map[string]*Table)}
+	if folder != "" {
+		entries, err := os.ReadDir(folder)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if e.IsDir() || !IsTable(e.Name()) {
+				continue
+			}
+			t, err := loadTable(filepath.Join(folder, e.Name()))
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := d.Tables[t.Name]; ok {
+				return nil, fmt.Errorf("table %s is defined twice", t.Name)
+			}
+			d.Tables[t.Name] = t
+			d.names = append(d.names, t.Name)
+		}
+		slices.Sort(d.names)
+	}
+	if geoFile != "" {
+		g, err := OpenGeoDB(geoFile)
+		if err != nil {
+			return nil, err
+		}
+		d.Geo = g
+	}
+	return d, nil
+}
+
+// Lookup returns an attribute of the row of table which matches key.
+func (d *Data) Lookup(table, key, attr string) string {
+	t, ok := d.Tables[table]
+	if !ok {
+		return ""
+	}
+	return t.Lookup(key)[attr]
+}
+
+// GeoIP returns the value at path of the GeoIP record of ip, for
+// example country.iso_code or autonomous_system_number.
+func (d *Data) GeoIP(ip, path string) string {
+	if d.Geo == nil {
+		return ""
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ""
+	}
+	v, err := d.Geo.Lookup(addr)
+	if err != nil || v == nil {
+		return ""
+	}
+	return PathString(v, path)
+}
+
+// IPAttr returns one attribute of ip the way Packet finds it for an
+// address, without looking up all the others.
+func (d *Data) IPAttr(ip, attr string) string {
+	for i := len(d.names) - 1; i >= 0; i-- {
+		t := d.Tables[d.names[i]]
+		if t.Prefix {
+			continue
+		}
+		if v, ok := t.Lookup(ip)[attr]; ok {
+			return v
+		}
+	}
+	if d.Geo != nil {
+		if addr, err := netip.ParseAddr(ip); err == nil {
+			return d.Geo.Attrs(addr)[attr]
+		}
+	}
+	return ""
+}
+
+// Packet returns the attributes of the addresses and numbers of a
+// packet. Attributes of the source get the prefix src_ and of the
+// destination dst_. Networks match the IPs and number prefixes the
+// numbers, GeoIP adds country, city, subdivision, asn and as_org.
+// Tables override GeoIP and are applied in the order of their names.
+func (d *Data) Packet(srcIP, dstIP, srcNum, dstNum string) map[string]string {
+	var res map[string]string
+	set := func(prefix string, a Attrs) {
+		if len(a) == 0 {
+			return
+		}
+		if res == nil {
+			res = make(map[string]string)
+		}
+		for k, v := range a {
+			res[prefix+k] = v
+		}
+	}
+	if d.Geo != nil {
+		if ip, err := netip.ParseAddr(srcIP); err == nil {
+			set("src_", d.Geo.Attrs(ip))
+		}
+		if ip, err := netip.ParseAddr(dstIP); err == nil {
+			set("dst_", d.Geo.Attrs(ip))
+		}
+	}
+	for _, name := range d.names {
+		t := d.Tables[name]
+		if t.Prefix {
+			if srcNum != "" {
+				set("src_", t.Lookup(srcNum))
+			}
+			if dstNum != "" {
+				set("dst_", t.Lookup(dstNum))
+			}
+		} else {
+			set("src_", t.Lookup(srcIP))
+			set("dst_", t.Lookup(dstIP))
+		}
+	}
+	return res
+}
@@ -0,0 +1,358 @@
+package enrich
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// mmdbMarker starts the metadata section of a MaxMind DB file.
+var mmdbMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// maxGeoCache limits the cached attributes of GeoIP records.
+const maxGeoCache = 100000
+
+// GeoDB reads GeoIP databases in the MaxMind DB format, like the
+// GeoLite2 City, Country and ASN databases.
+type GeoDB struct {
+	Type       string
+	buf        []byte
+	data       []byte
+	nodeCount  uint32
+	recordSize int
+	ipVersion  int
+	ipv4Start  uint32
+
+	mu    sync.RWMutex
+	cache map[uint32]Attrs
+}
+
+// OpenGeoDB reads a MaxMind DB file.
+func OpenGeoDB(name string) (*GeoDB, error) {
+	buf, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	i := bytes.LastIndex(buf, mmdbMarker)
+	if i < 0 {
+		return nil, fmt.Errorf("%s is no MaxMind DB file", name)
+	}
+	meta := buf[i+len(mmdbMarker):]
+	v, _, err := decodeMMDB(meta, 0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("%s: bad metadata: %v", name, err)
+	}
+	m, ok := v.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("%s: bad metadata", name)
+	}
+	g := &GeoDB{
+		buf:        buf,
+		nodeCount:  uint32(metaUint(m["node_count"])),
+		recordSize: int(metaUint(m["record_size"])),
+		ipVersion:  int(metaUint(m["ip_version"])),
+		cache:      make(map[uint32]Attrs),
+	}
+	g.Type, _ = m["database_type"].(string)
+	switch g.recordSize {
+	case 24, 28, 32:
+	default:
+		return nil, fmt.Errorf("%s: unsupported record size %d", name, g.recordSize)
+	}
+	treeSize := int(g.nodeCount) * g.recordSize / 4
+	if treeSize+16 > i {
+		return nil, fmt.Errorf("%s: search tree exceeds file", name)
+	}
+	g.data = buf[treeSize+16 : i]
+
+	// IPv4 addresses are stored in IPv6 trees below ::/96
+	if g.ipVersion == 6 {
+		node := uint32(0)
+		for range 96 {
+			if node >= g.nodeCount {
+				break
+			}
+			node = g.record(node, 0)
+		}
+		g.ipv4Start = node
+	}
+	return g, nil
+}
+
+// Lookup returns the record of the network which contains ip or nil.
+func (g *GeoDB) Lookup(ip netip.Addr) (any, error) {
+	off, ok, err := g.find(ip)
+	if !ok || err != nil {
+		return nil, err
+	}
+	v, _, err := decodeMMDB(g.data, off, 0)
+	return v, err
+}
+
+// Attrs returns country, city, subdivision, asn and as_org of the
+// network which contains ip. Records are decoded once.
+func (g *GeoDB) Attrs(ip netip.Addr) Attrs {
+	off, ok, err := g.find(ip)
+	if !ok || err != nil {
+		return nil
+	}
+	g.mu.RLock()
+	a, ok := g.cache[off]
+	g.mu.RUnlock()
+	if ok {
+		return a
+	}
+
+	v, _, err := decodeMMDB(g.data, off, 0)
+	if err != nil {
+		return nil
+	}
+	a = make(Attrs)
+	for name, path := range map[string]string{
+		"country":     "country.iso_code",
+		"city":        "city.names.en",
+		"subdivision": "subdivisions.0.iso_code",
+		"asn":         "autonomous_system_number",
+		"as_org":      "autonomous_system_organization",
+	} {
+		if s := PathString(v, path); s != "" {
+			a[name] = s
+		}
+	}
+
+	g.mu.Lock()
+	if len(g.cache) >= maxGeoCache {
+		clear(g.cache)
+	}
+	g.cache[off] = a
+	g.mu.Unlock()
+	return a
+}
+
+// find walks the search tree and returns the offset of the record in
+// the data section.
+func (g *GeoDB) find(ip netip.Addr) (uint32, bool, error) {
+	ip = ip.Unmap()
+	node := uint32(0)
+	if ip.Is4() {
+		if g.ipVersion == 6 {
+			node = g.ipv4Start
+		}
+	} else if g.ipVersion == 4 {
+		return 0, false, nil
+	}
+	b := ip.AsSlice()
+	for i := 0; i < len(b)*8 && node < g.nodeCount; i++ {
+		node = g.record(node, b[i/8]>>(7-uint(i%8))&1)
+	}
+	switch {
+	case node == g.nodeCount:
+		return 0, false, nil
+	case node < g.nodeCount:
+		return 0, false, errors.New("invalid search tree")
+	}
+	off := node - g.nodeCount - 16
+	if int(off) >= len(g.data) {
+		return 0, false, errors.New("invalid data pointer in search tree")
+	}
+	return off, true, nil
+}
+
+func (g *GeoDB) record(node uint32, bit byte) uint32 {
+	switch g.recordSize {
+	case 24:
+		b := g.buf[node*6+uint32(bit)*3:]
+		return uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+	case 28:
+		b := g.buf[node*7:]
+		if bit == 0 {
+			return uint32(b[3]&0xf0)<<20 | uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+		}
+		return uint32(b[3]&0x0f)<<24 | uint32(b[4])<<16 | uint32(b[5])<<8 | uint32(b[6])
+	default:
+		return binary.BigEndian.Uint32(g.buf[node*8+uint32(bit)*4:])
+	}
+}
+
+// PathString follows a dotted path of map keys and array indexes in a
+// GeoIP record and formats the value.
+func PathString(v any, path string) string {
+	for _, p := range strings.Split(path, ".") {
+		switch t := v.(type) {
+		case map[string]any:
+			v = t[p]
+		case []any:
+			i, err := strconv.Atoi(p)
+			if err != nil || i < 0 || i >= len(t) {
+				return ""
+			}
+			v = t[i]
+		default:
+			return ""
+		}
+	}
+	switch t := v.(type) {
+	case string:
+		return t
+	case uint64:
+		return strconv.FormatUint(t, 10)
+	case int64:
+		return strconv.FormatInt(t, 10)
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(t)
+	}
+	return ""
+}
+
+// decodeMMDB decodes the value at off of the data section and returns
+// the offset after it. Pointers are followed up to a small depth.
+func decodeMMDB(data []byte, off uint32, depth int) (any, uint32, error) {
+	if depth > 32 {
+		return nil, 0, errors.New("data nested too deep")
+	}
+	if int(off) >= len(data) {
+		return nil, 0, errors.New("unexpected end of data")
+	}
+	ctrl := data[off]
+	off++
+	typ := int(ctrl >> 5)
+
+	if typ == 1 {
+		ss := int(ctrl>>3) & 0x3
+		if int(off)+ss+1 > len(data) {
+			return nil, 0, errors.New("unexpected end of data")
+		}
+		var p uint32
+		v := uint32(ctrl & 0x7)
+		b := data[off:]
+		switch ss {
+		case 0:
+			p = v<<8 | uint32(b[0])
+		case 1:
+			p = (v<<16 | uint32(b[0])<<8 | uint32(b[1])) + 2048
+		case 2:
+			p = (v<<24 | uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])) + 526336
+		case 3:
+			p = binary.BigEndian.Uint32(b)
+		}
+		val, _, err := decodeMMDB(data, p, depth+1)
+		return val, off + uint32(ss) + 1, err
+	}
+
+	if typ == 0 {
+		if int(off) >= len(data) {
+			return nil, 0, errors.New("unexpected end of data")
+		}
+		typ = 7 + int(data[off])
+		off++
+	}
+
+	size := uint32(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		if int(off+n) > len(data) {
+			return nil, 0, errors.New("unexpected end of data")
+		}
+		var ext uint32
+		for _, c := range data[off : off+n] {
+			ext = ext<<8 | uint32(c)
+		}
+		switch size {
+		case 29:
+			size = 29 + ext
+		case 30:
+			size = 285 + ext
+		case 31:
+			size = 65821 + ext
+		}
+		off += n
+	}
+
+	// containers and booleans do not use size as length in bytes
+	switch typ {
+	case 7:
+		m := make(map[string]any, size)
+		for range size {
+			k, next, err := decodeMMDB(data, off, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, 0, errors.New("map key is no string")
+			}
+			v, next, err := decodeMMDB(data, next, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			m[key] = v
+			off = next
+		}
+		return m, off, nil
+	case 11:
+		a := make([]any, 0, size)
+		for range size {
+			v, next, err := decodeMMDB(data, off, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			a = append(a, v)
+			off = next
+		}
+		return a, off, nil
+	case 14:
+		return size != 0, off, nil
+	}
+
+	if int(off+size) > len(data) {
+		return nil, 0, errors.New("unexpected end of data")
+	}
+	b := data[off : off+size]
+	off += size
+	switch typ {
+	case 2:
+		return string(b), off, nil
+	case 3:
+		if size != 8 {
+			return nil, 0, errors.New("bad double size")
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), off, nil
+	case 4:
+		return bytes.Clone(b), off, nil
+	case 5, 6, 9:
+		var u uint64
+		for _, c := range b {
+			u = u<<8 | uint64(c)
+		}
+		return u, off, nil
+	case 8:
+		var u uint32
+		for _, c := range b {
+			u = u<<8 | uint32(c)
+		}
+		return int64(int32(u)), off, nil
+	case 10:
+		// uint128 values are not used by the GeoIP databases
+		return nil, off, nil
+	case 15:
+		if size != 4 {
+			return nil, 0, errors.New("bad float size")
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), off, nil
+	}
+	return nil, 0, fmt.Errorf("unknown data type %d", typ)
+}
+
+func metaUint(v any) uint64 {
+	u, _ := v.(uint64)
+	return u
+}
@@ -0,0 +1,199 @@
+package enrich
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// mmdbWriter builds small MaxMind DB files for the tests.
+type mmdbWriter struct {
+	nodes [][2]int // >= 0 node, -1 empty, <= -2 record -(2+i)
+	data  []byte
+	offs  []int
+}
+
+func (w *mmdbWriter) insert(p netip.Prefix, ipVersion int, record []byte) {
+	if len(w.nodes) == 0 {
+		w.nodes = append(w.nodes, [2]int{-1, -1})
+	}
+	addr := p.Addr()
+	bits := p.Bits()
+	if ipVersion == 6 && addr.Is4() {
+		addr = netip.AddrFrom16(addr.As16())
+		b := addr.As16()
+		clear(b[10:12])
+		addr = netip.AddrFrom16(b)
+		bits += 96
+	}
+	w.offs = append(w.offs, len(w.data))
+	w.data = append(w.data, record...)
+	leaf := -(2 + len(w.offs) - 1)
+
+	b := addr.AsSlice()
+	node := 0
+	for i := range bits {
+		bit := b[i/8] >> (7 - uint(i%8)) & 1
+		if i == bits-1 {
+			w.nodes[node][bit] = leaf
+			break
+		}
+		if w.nodes[node][bit] < 0 {
+			w.nodes = append(w.nodes, [2]int{-1, -1})
+			w.nodes[node][bit] = len(w.nodes) - 1
+		}
+		node = w.nodes[node][bit]
+	}
+}
+
+func (w *mmdbWriter) bytes(ipVersion, recordSize int) []byte {
+	n := len(w.nodes)
+	value := func(r int) uint32 {
+		switch {
+		case r >= 0:
+			return uint32(r)
+		case r == -1:
+			return uint32(n)
+		}
+		return uint32(n + 16 + w.offs[-r-2])
+	}
+	var out []byte
+	for _, nd := range w.nodes {
+		l, r := value(nd[0]), value(nd[1])
+		switch recordSize {
+		case 24:
+			out = append(out, byte(l>>16), byte(l>>8), byte(l), byte(r>>16), byte(r>>8), byte(r))
+		case 28:
+			out = append(out, byte(l>>16), byte(l>>8), byte(l), byte(l>>20)&0xf0|byte(r>>24)&0x0f, byte(r>>16), byte(r>>8), byte(r))
+		case 32:
+			out = binary.BigEndian.AppendUint32(out, l)
+			out = binary.BigEndian.AppendUint32(out, r)
+		}
+	}
+	out = append(out, make([]byte, 16)...)
+	out = append(out, w.data...)
+	out = append(out, mmdbMarker...)
+	return append(out, enc(map[string]any{
+		"node_count":                  uint32(n),
+		"record_size":                 uint16(recordSize),
+		"ip_version":                  uint16(ipVersion),
+		"database_type":               "Test-City",
+		"binary_format_major_version": uint16(2),
+		"languages":                   []any{"en"},
+		"build_epoch":                 uint64(1700000000),
+	})...)
+}
+
+// enc encodes a value in the MaxMind DB data format.
+func enc(v any) []byte {
+	ctrl := func(typ, size int) []byte {
+		var ext []byte
+		if size >= 29 {
+			ext, size = []byte{byte(size - 29)}, 29
+		}
+		if typ > 7 {
+			return append([]byte{byte(size), byte(typ - 7)}, ext...)
+		}
+		return append([]byte{byte(typ<<5 | size)}, ext...)
+	}
+	switch v := v.(type) {
+	case string:
+		return append(ctrl(2, len(v)), v...)
+	case uint16:
+		return binary.BigEndian.AppendUint16(ctrl(5, 2), v)
+	case uint32:
+		return binary.BigEndian.AppendUint32(ctrl(6, 4), v)
+	case uint64:
+		return binary.BigEndian.AppendUint64(ctrl(9, 8), v)
+	case bool:
+		if v {
+			return ctrl(14, 1)
+		}
+		return ctrl(14, 0)
+	case []any:
+		b := ctrl(11, len(v))
+		for _, e := range v {
+			b = append(b, enc(e)...)
+		}
+		return b
+	case map[string]any:
+		b := ctrl(7, len(v))
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		for _, k := range keys {
+			b = append(b, enc(k)...)
+			b = append(b, enc(v[k])...)
+		}
+		return b
+	case pointer:
+		return []byte{1<<5 | byte(v>>8)&0x7, byte(v)}
+	}
+	panic("unsupported type")
+}
+
+// pointer is a data section offset below 2048.
+type pointer uint16
+
+func TestGeoDB(t *testing.T) {
+	de := map[string]any{"iso_code": "DE", "names": map[string]any{"en": "Germany"}}
+	for _, tc := range []struct {
+		ipVersion, recordSize int
+	}{{4, 24}, {6, 28}, {6, 32}} {
+		w := &mmdbWriter{}
+		w.insert(netip.MustParsePrefix("192.0.2.0/24"), tc.ipVersion, enc(map[string]any{
+			"country":                        de,
+			"city":                           map[string]any{"names": map[string]any{"en": "Berlin"}},
+			"subdivisions":                   []any{map[string]any{"iso_code": "BE"}},
+			"autonomous_system_number":       uint32(64500),
+			"autonomous_system_organization": "Example AS",
+			"anycast":                        true,
+		}))
+		// the country of the second network points to the first record
+		w.insert(netip.MustParsePrefix("198.51.100.0/25"), tc.ipVersion, enc(map[string]any{
+			"country": map[string]any{"iso_code": "FR"},
+			"city":    pointer(0),
+		}))
+		if tc.ipVersion == 6 {
+			w.insert(netip.MustParsePrefix("2001:db8::/32"), tc.ipVersion, enc(map[string]any{"country": map[string]any{"iso_code": "NL"}}))
+		}
+		name := filepath.Join(t.TempDir(), "test.mmdb")
+		assert.NoError(t, os.WriteFile(name, w.bytes(tc.ipVersion, tc.recordSize), 0644))
+
+		g, err := OpenGeoDB(name)
+		if !assert.NoError(t, err, tc) {
+			continue
+		}
+		assert.Equal(t, "Test-City", g.Type)
+		assert.Equal(t, Attrs{"country": "DE", "city": "Berlin", "subdivision": "BE", "asn": "64500", "as_org": "Example AS"},
+			g.Attrs(netip.MustParseAddr("192.0.2.77")), tc)
+		assert.Nil(t, g.Attrs(netip.MustParseAddr("192.0.3.1")), tc)
+		assert.Nil(t, g.Attrs(netip.MustParseAddr("198.51.100.200")), tc)
+
+		v, err := g.Lookup(netip.MustParseAddr("198.51.100.1"))
+		assert.NoError(t, err)
+		assert.Equal(t, "FR", PathString(v, "country.iso_code"), tc)
+		assert.Equal(t, "Germany", PathString(v, "city.country.names.en"), tc)
+		assert.Equal(t, "true", PathString(v, "city.anycast"), tc)
+		assert.Equal(t, "", PathString(v, "city.subdivisions.1.iso_code"), tc)
+
+		if tc.ipVersion == 6 {
+			assert.Equal(t, "NL", g.Attrs(netip.MustParseAddr("2001:db8::1"))["country"], tc)
+			assert.Equal(t, "DE", g.Attrs(netip.MustParseAddr("::ffff:192.0.2.1"))["country"], tc)
+		} else {
+			assert.Nil(t, g.Attrs(netip.MustParseAddr("2001:db8::1")), tc)
+		}
+	}
+
+	name := filepath.Join(t.TempDir(), "bad.mmdb")
+	assert.NoError(t, os.WriteFile(name, []byte("no database"), 0644))
+	_, err := OpenGeoDB(name)
+	assert.Error(t, err)
+}
@@ -0,0 +1,264 @@
+package enrich
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Attrs are the attributes of a table row or GeoIP record.
+type Attrs map[string]string
+
+// Table maps networks or number prefixes to attributes. The key
+// column of a table is named cidr, network or ip for networks and
+// prefix for number prefixes, all other columns are attributes.
+type Table struct {
+	Name     string
+	Prefix   bool
+	nets     map[int]map[netip.Prefix]Attrs
+	bits     []int
+	prefixes map[string]Attrs
+	maxLen   int
+	size     int
+}
+
+func newTable(name string, prefix bool) *Table {
+	t := &Table{Name: name, Prefix: prefix}
+	if prefix {
+		t.prefixes = make(map[string]Attrs)
+	} else {
+		t.nets = make(map[int]map[netip.Prefix]Attrs)
+	}
+	return t
+}
+
+// Len returns the number of rows.
+func (t *Table) Len() int {
+	return t.size
+}
+
+// Lookup returns the attributes of the longest network which contains
+// the IP or of the longest prefix of the number.
+func (t *Table) Lookup(key string) Attrs {
+	if t.Prefix {
+		num := normNumber(key)
+		for l := min(len(num), t.maxLen); l > 0; l-- {
+			if a, ok := t.prefixes[num[:l]]; ok {
+				return a
+			}
+		}
+		return nil
+	}
+	ip, err := netip.ParseAddr(key)
+	if err != nil {
+		return nil
+	}
+	ip = ip.Unmap()
+	for _, b := range t.bits {
+		if b > ip.BitLen() {
+			continue
+		}
+		p, _ := ip.Prefix(b)
+		if a, ok := t.nets[b][p]; ok {
+			return a
+		}
+	}
+	return nil
+}
+
+func (t *Table) add(key string, a Attrs) error {
+	key = strings.TrimSpace(key)
+	if t.Prefix {
+		num := normNumber(key)
+		if num == "" {
+			return fmt.Errorf("empty prefix")
+		}
+		t.prefixes[num] = a
+		t.maxLen = max(t.maxLen, len(num))
+		t.size++
+		return nil
+	}
+	var p netip.Prefix
+	var err error
+	if strings.IndexByte(key, '/') >= 0 {
+		p, err = netip.ParsePrefix(key)
+	} else {
+		var ip netip.Addr
+		ip, err = netip.ParseAddr(key)
+		p = netip.PrefixFrom(ip, ip.BitLen())
+	}
+	if err != nil {
+		return err
+	}
+	if p.Addr().Is4In6() && p.Bits() >= 96 {
+		p = netip.PrefixFrom(p.Addr().Unmap(), p.Bits()-96)
+	}
+	p = p.Masked()
+	if _, ok := t.nets[p.Bits()]; !ok {
+		t.nets[p.Bits()] = make(map[netip.Prefix]Attrs)
+		t.bits = append(t.bits, p.Bits())
+		slices.SortFunc(t.bits, func(a, b int) int { return b - a })
+	}
+	t.nets[p.Bits()][p] = a
+	t.size++
+	return nil
+}
+
+// loadTable reads a .csv or .json table. The table is named after the
+// file without extension.
+func loadTable(name string) (*Table, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	base := filepath.Base(name)
+	ext := filepath.Ext(base)
+	tname := strings.TrimSuffix(base, ext)
+	var t *Table
+	switch strings.ToLower(ext) {
+	case ".csv":
+		t, err = readCSV(tname, f)
+	case ".json":
+		t, err = readJSON(tname, f)
+	default:
+		return nil, fmt.Errorf("unknown table format %s", ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("table %s: %v", base, err)
+	}
+	return t, nil
+}
+
+func readCSV(name string, r io.Reader) (*Table, error) {
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("can't read header: %v", err)
+	}
+	prefix, err := keyKind(header[0])
+	if err != nil {
+		return nil, err
+	}
+	cols := make([]string, len(header))
+	for i, h := range header {
+		cols[i] = attrName(h)
+	}
+	t := newTable(name, prefix)
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return t, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		a := make(Attrs, len(rec)-1)
+		for i := 1; i < len(rec); i++ {
+			if rec[i] != "" {
+				a[cols[i]] = rec[i]
+			}
+		}
+		if err := t.add(rec[0], a); err != nil {
+			line, _ := cr.FieldPos(0)
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+	}
+}
+
+func readJSON(name string, r io.Reader) (*Table, error) {
+	var rows []map[string]any
+	if err := json.NewDecoder(r).Decode(&rows); err != nil {
+		return nil, err
+	}
+	var t *Table
+	for i, row := range rows {
+		var key string
+		a := make(Attrs, len(row))
+		for k, v := range row {
+			if prefix, err := keyKind(k); err == nil {
+				if t == nil {
+					t = newTable(name, prefix)
+				} else if t.Prefix != prefix {
+					return nil, fmt.Errorf("row %d: mixed cidr and prefix keys", i+1)
+				}
+				key = jsonString(v)
+				continue
+			}
+			if s := jsonString(v); s != "" {
+				a[attrName(k)] = s
+			}
+		}
+		if key == "" {
+			return nil, fmt.Errorf("row %d: no cidr or prefix key", i+1)
+		}
+		if err := t.add(key, a); err != nil {
+			return nil, fmt.Errorf("row %d: %v", i+1, err)
+		}
+	}
+	if t == nil {
+		t = newTable(name, false)
+	}
+	return t, nil
+}
+
+func keyKind(col string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(col)) {
+	case "cidr", "network", "ip":
+		return false, nil
+	case "prefix":
+		return true, nil
+	}
+	return false, fmt.Errorf("first column must be cidr, network, ip or prefix, got %q", col)
+}
+
+func jsonString(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case nil:
+		return ""
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// attrName makes a column name usable as Loki label and data header
+// key.
+func attrName(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '_':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + 'a' - 'A'
+		}
+		return '_'
+	}, strings.TrimSpace(s))
+}
+
+// normNumber strips a leading + and the visual separators of a number.
+func normNumber(s string) string {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "+")
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '-', '.', '(', ')':
+			return -1
+		}
+		return r
+	}, s)
+}
@@ -0,0 +1,82 @@
+package enrich
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadCSV(t *testing.T) {
+	csv := "cidr,Customer,Trunk Name\n# office\n192.0.2.0/24,acme,\n192.0.2.128/25,acme,sbc-1\n198.51.100.7,globex,sbc-2\n2001:db8::/32,initech,v6\n"
+	tb, err := readCSV("customers", strings.NewReader(csv))
+	assert.NoError(t, err)
+	assert.False(t, tb.Prefix)
+	assert.Equal(t, 4, tb.Len())
+
+	assert.Equal(t, Attrs{"customer": "acme"}, tb.Lookup("192.0.2.1"))
+	assert.Equal(t, Attrs{"customer": "acme", "trunk_name": "sbc-1"}, tb.Lookup("192.0.2.200"))
+	assert.Equal(t, "globex", tb.Lookup("::ffff:198.51.100.7")["customer"])
+	assert.Nil(t, tb.Lookup("198.51.100.8"))
+	assert.Equal(t, "initech", tb.Lookup("2001:db8::1")["customer"])
+	assert.Nil(t, tb.Lookup("not an ip"))
+
+	_, err = readCSV("bad", strings.NewReader("name,customer\nx,y\n"))
+	assert.Error(t, err)
+	_, err = readCSV("bad", strings.NewReader("cidr,customer\n192.0.2.0/33,y\n"))
+	assert.ErrorContains(t, err, "line 2")
+}
+
+func TestReadPrefix(t *testing.T) {
+	csv := "prefix,carrier\n+49,de\n49151,telekom\n1 (212),nyc\n"
+	tb, err := readCSV("carriers", strings.NewReader(csv))
+	assert.NoError(t, err)
+	assert.True(t, tb.Prefix)
+	assert.Equal(t, "telekom", tb.Lookup("+4915112345")["carrier"])
+	assert.Equal(t, "de", tb.Lookup("4930123")["carrier"])
+	assert.Equal(t, "nyc", tb.Lookup("+1-212-555-0100")["carrier"])
+	assert.Nil(t, tb.Lookup("33123"))
+}
+
+func TestReadJSON(t *testing.T) {
+	js := `[{"cidr": "10.0.0.0/8", "tenant": "lab", "id": 7}, {"cidr": "10.1.0.0/16", "tenant": "lab-1", "active": true}]`
+	tb, err := readJSON("tenants", strings.NewReader(js))
+	assert.NoError(t, err)
+	assert.Equal(t, Attrs{"tenant": "lab", "id": "7"}, tb.Lookup("10.2.0.1"))
+	assert.Equal(t, Attrs{"tenant": "lab-1", "active": "true"}, tb.Lookup("10.1.0.1"))
+
+	_, err = readJSON("bad", strings.NewReader(`[{"cidr": "10.0.0.0/8"}, {"prefix": "49"}]`))
+	assert.Error(t, err)
+	_, err = readJSON("bad", strings.NewReader(`[{"tenant": "lab"}]`))
+	assert.Error(t, err)
+}
+
+func TestLoadPacket(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(data), 0644))
+	}
+	write("customers.csv", "cidr,customer\n192.0.2.0/24,acme\n")
+	write("carriers.json", `[{"prefix": "49", "carrier": "de"}, {"prefix": "33", "carrier": "fr"}]`)
+	write("README.txt", "ignored")
+
+	d, err := Load(dir, "")
+	assert.NoError(t, err)
+	assert.Len(t, d.Tables, 2)
+	assert.Equal(t, "acme", d.Lookup("customers", "192.0.2.9", "customer"))
+	assert.Equal(t, "", d.Lookup("missing", "192.0.2.9", "customer"))
+	assert.Equal(t, "", d.GeoIP("192.0.2.9", "country.iso_code"))
+
+	assert.Equal(t, map[string]string{"src_customer": "acme", "src_carrier": "de", "dst_carrier": "fr"},
+		d.Packet("192.0.2.1", "198.51.100.1", "+4930123", "33123"))
+	assert.Nil(t, d.Packet("198.51.100.1", "198.51.100.2", "", ""))
+	assert.Equal(t, "acme", d.IPAttr("192.0.2.1", "customer"))
+	assert.Equal(t, "", d.IPAttr("192.0.2.1", "carrier"))
+	assert.Equal(t, "", d.IPAttr("198.51.100.1", "customer"))
+
+	write("broken.csv", "cidr,customer\nnope,x\n")
+	_, err = Load(dir, "")
+	assert.ErrorContains(t, err, "broken.csv")
+}
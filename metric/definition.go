@@ -23,14 +23,61 @@ var (
 		Name: "heplify_reason_isup_total",
 		Help: "ISUP Q.850 cause from reason header"},
 		[]string{"target_name", "cause", "method"})
-	srd = promauto.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "heplify_kpi_srd",
-		Help: "SIP Session Request Delay KPI"},
+	srd = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "heplify_kpi_srd_seconds",
+		Help:    "SIP Session Request Delay KPI",
+		Buckets: delayBuckets},
 		[]string{"target_name", "node_id"})
 	rrd = promauto.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "heplify_kpi_rrd",
 		Help: "SIP Registration Request Delay"},
 		[]string{"target_name", "node_id"})
+	kpiCalls = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "heplify_kpi_calls_total",
+		Help: "Finished calls by status"},
+		[]string{"target_name", "node_id", "status"})
+	kpiPDD = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "heplify_kpi_pdd_seconds",
+		Help:    "SIP Post Dial Delay KPI",
+		Buckets: delayBuckets},
+		[]string{"target_name", "node_id"})
+	kpiDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "heplify_kpi_call_duration_seconds",
+		Help:    "Duration of answered calls",
+		Buckets: []float64{10, 30, 60, 120, 300, 600, 1200, 1800, 3600, 7200}},
+		[]string{"target_name", "node_id"})
+	kpiASR = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "heplify_kpi_asr",
+		Help: "Answer Seizure Ratio over PromKPIWindow"},
+		[]string{"target_name", "node_id"})
+	kpiNER = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "heplify_kpi_ner",
+		Help: "Network Effectiveness Ratio over PromKPIWindow"},
+		[]string{"target_name", "node_id"})
+	kpiSCR = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "heplify_kpi_scr",
+		Help: "Session Completion Ratio over PromKPIWindow"},
+		[]string{"target_name", "node_id"})
+	kpiACD = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "heplify_kpi_acd_seconds",
+		Help: "Average Call Duration over PromKPIWindow"},
+		[]string{"target_name", "node_id"})
+	regActive = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "heplify_registrations",
+		Help: "Currently registered contacts"},
+		[]string{"target_name", "domain"})
+	regExpired = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "heplify_registrations_expired_total",
+		Help: "Registrations expired without refresh"},
+		[]string{"target_name", "domain"})
+	regFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "heplify_registration_failures_total",
+		Help: "Failed REGISTER transactions by AoR"},
+		[]string{"target_name", "aor", "response"})
+	sdpCodecs = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "heplify_sdp_codecs_total",
+		Help: "Negotiated codecs from SDP answers to INVITE"},
+		[]string{"target_name", "node_id", "media", "codec"})
 	logAlert = promauto.NewCounterVec(prometheus.CounterOpts{
 		Name: "heplify_log_alert_total",
 		Help: "Log errors and warnings"},
@@ -208,6 +255,8 @@ var (
 		Help: "Incoming RTCP maxLat"},
 		[]string{"sbc_name", "direction", "inc_realm", "out_realm"})
 
+	delayBuckets = []float64{0.1, 0.25, 0.5, 1, 2, 3, 4, 6, 8, 10, 15, 20, 30}
+
 	// JSON Paths
 	rtcpPaths = [][]string{
 		[]string{"report_blocks", "[0]", "fraction_lost"},
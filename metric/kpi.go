@@ -0,0 +1,195 @@
+package metric
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sipcapture/heplify-server/decoder"
+	"github.com/sipcapture/heplify-server/enrich"
+)
+
+// Final responses which are caused by the called user and therefore count
+// as effective for the NER.
+var userFailures = map[int]bool{
+	404: true, 480: true, 484: true, 486: true, 487: true, 600: true, 603: true, 604: true,
+}
+
+// Digest challenges which were not answered by a new INVITE. The caller
+// wasn't authorized to place the call, so it doesn't count as an attempt.
+var authFailures = map[int]bool{401: true, 407: true}
+
+type kpiKey struct {
+	target string
+	node   string
+}
+
+// kpiBucket holds the call outcomes of one minute.
+type kpiBucket struct {
+	minute    int64
+	attempts  int
+	answered  int
+	effective int
+	completed int
+	duration  float64
+}
+
+type kpiResult struct {
+	asr, ner, scr, acd float64
+	empty              bool
+}
+
+// kpiWindow sums call outcomes per target and node over a sliding window
+// of whole minutes.
+type kpiWindow struct {
+	mu      sync.Mutex
+	minutes int64
+	series  map[kpiKey][]kpiBucket
+}
+
+func newKPIWindow(minutes int) *kpiWindow {
+	if minutes < 1 {
+		minutes = 1
+	}
+	return &kpiWindow{
+		minutes: int64(minutes),
+		series:  make(map[kpiKey][]kpiBucket),
+	}
+}
+
+func (w *kpiWindow) add(key kpiKey, now time.Time, cdr *decoder.CDR) kpiResult {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	buckets, ok := w.series[key]
+	if !ok {
+		buckets = make([]kpiBucket, w.minutes)
+		w.series[key] = buckets
+	}
+	minute := now.Unix() / 60
+	b := &buckets[minute%w.minutes]
+	if b.minute != minute {
+		*b = kpiBucket{minute: minute}
+	}
+
+	b.attempts++
+	switch {
+	case cdr.Status == decoder.CDRAnswered:
+		b.answered++
+		b.effective++
+		b.duration += cdr.Duration
+		if cdr.Disconnect != "timeout" {
+			b.completed++
+		}
+	case cdr.Status == decoder.CDRCancelled, userFailures[cdr.FinalResponse]:
+		b.effective++
+	}
+	return w.result(buckets, minute)
+}
+
+// sweep returns the current results of all series and forgets the ones
+// without calls inside the window.
+func (w *kpiWindow) sweep(now time.Time) map[kpiKey]kpiResult {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	minute := now.Unix() / 60
+	res := make(map[kpiKey]kpiResult, len(w.series))
+	for key, buckets := range w.series {
+		r := w.result(buckets, minute)
+		if r.empty {
+			delete(w.series, key)
+		}
+		res[key] = r
+	}
+	return res
+}
+
+func (w *kpiWindow) result(buckets []kpiBucket, minute int64) kpiResult {
+	var sum kpiBucket
+	for _, b := range buckets {
+		if b.minute > minute-w.minutes && b.minute <= minute {
+			sum.attempts += b.attempts
+			sum.answered += b.answered
+			sum.effective += b.effective
+			sum.completed += b.completed
+			sum.duration += b.duration
+		}
+	}
+	if sum.attempts == 0 {
+		return kpiResult{empty: true}
+	}
+	r := kpiResult{
+		asr: float64(sum.answered) / float64(sum.attempts),
+		ner: float64(sum.effective) / float64(sum.attempts),
+		scr: float64(sum.completed) / float64(sum.attempts),
+	}
+	if sum.answered > 0 {
+		r.acd = sum.duration / float64(sum.answered)
+	}
+	return r
+}
+
+// exposeCDR updates the call KPIs with a finished call. The call is
+// accounted to the target of its destination or else of its source.
+func (p *Prometheus) exposeCDR(pkt *decoder.HEP) {
+	cdr := pkt.CDR
+	target := p.targetOf(pkt, cdr.DstIP, cdr.SrcIP)
+
+	kpiCalls.WithLabelValues(target, pkt.NodeName, cdr.Status).Inc()
+	switch {
+	case !cdr.RingTime.IsZero():
+		kpiPDD.WithLabelValues(target, pkt.NodeName).Observe(cdr.PDD)
+	case !cdr.AnswerTime.IsZero():
+		kpiPDD.WithLabelValues(target, pkt.NodeName).Observe(cdr.AnswerTime.Sub(cdr.SetupTime).Seconds())
+	}
+	if cdr.Status == decoder.CDRAnswered {
+		kpiDuration.WithLabelValues(target, pkt.NodeName).Observe(cdr.Duration)
+	}
+	if cdr.Status == decoder.CDRFailed && authFailures[cdr.FinalResponse] {
+		return
+	}
+
+	key := kpiKey{target, pkt.NodeName}
+	setKPI(key, p.kpi.add(key, time.Now(), cdr))
+}
+
+// targetOf returns the PromTargetName or EnrichPromTarget attribute of
+// the first mapped address.
+func (p *Prometheus) targetOf(pkt *decoder.HEP, ips ...string) string {
+	if p.TargetEmpty && p.enrichTarget == "" {
+		return pkt.TargetName
+	}
+	d := enrich.Active()
+	for _, ip := range ips {
+		if t, ok := p.TargetMap[ip]; ok && !p.TargetEmpty {
+			return t
+		}
+		if d != nil && p.enrichTarget != "" {
+			if t := d.IPAttr(ip, p.enrichTarget); t != "" {
+				return t
+			}
+		}
+	}
+	return "unknown"
+}
+
+// refreshKPI ages out the calls which left the window.
+func (p *Prometheus) refreshKPI(now time.Time) {
+	for key, r := range p.kpi.sweep(now) {
+		setKPI(key, r)
+	}
+}
+
+func setKPI(key kpiKey, r kpiResult) {
+	if r.empty {
+		kpiASR.DeleteLabelValues(key.target, key.node)
+		kpiNER.DeleteLabelValues(key.target, key.node)
+		kpiSCR.DeleteLabelValues(key.target, key.node)
+		kpiACD.DeleteLabelValues(key.target, key.node)
+		return
+	}
+	kpiASR.WithLabelValues(key.target, key.node).Set(r.asr)
+	kpiNER.WithLabelValues(key.target, key.node).Set(r.ner)
+	kpiSCR.WithLabelValues(key.target, key.node).Set(r.scr)
+	kpiACD.WithLabelValues(key.target, key.node).Set(r.acd)
+}
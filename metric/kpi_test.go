@@ -0,0 +1,110 @@
+package metric
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sipcapture/heplify-server/config"
+	"github.com/sipcapture/heplify-server/decoder"
+	"github.com/sipcapture/heplify-server/enrich"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKPIWindow(t *testing.T) {
+	w := newKPIWindow(5)
+	key := kpiKey{"sbc", "node1"}
+	now := time.Unix(1577934000, 0)
+
+	w.add(key, now, &decoder.CDR{Status: decoder.CDRAnswered, Disconnect: "caller", Duration: 30})
+	w.add(key, now, &decoder.CDR{Status: decoder.CDRAnswered, Disconnect: "timeout", Duration: 90})
+	w.add(key, now.Add(time.Minute), &decoder.CDR{Status: decoder.CDRCancelled, FinalResponse: 487})
+	w.add(key, now.Add(2*time.Minute), &decoder.CDR{Status: decoder.CDRFailed, FinalResponse: 486})
+	r := w.add(key, now.Add(2*time.Minute), &decoder.CDR{Status: decoder.CDRFailed, FinalResponse: 503})
+
+	assert.False(t, r.empty)
+	assert.InDelta(t, 0.4, r.asr, 1e-9)
+	assert.InDelta(t, 0.8, r.ner, 1e-9)
+	assert.InDelta(t, 0.2, r.scr, 1e-9)
+	assert.InDelta(t, 60, r.acd, 1e-9)
+
+	// the answered calls leave the window
+	r = w.sweep(now.Add(5 * time.Minute))[key]
+	assert.InDelta(t, 0, r.asr, 1e-9)
+	assert.InDelta(t, 2.0/3, r.ner, 1e-9)
+	assert.Zero(t, r.acd)
+
+	// a reused bucket starts from zero
+	r = w.add(key, now.Add(6*time.Minute), &decoder.CDR{Status: decoder.CDRAnswered, Duration: 10})
+	assert.InDelta(t, 1.0/3, r.asr, 1e-9)
+
+	assert.True(t, w.sweep(now.Add(20 * time.Minute))[key].empty)
+	assert.Empty(t, w.series)
+}
+
+func TestKPIAuthFailures(t *testing.T) {
+	p := &Prometheus{TargetEmpty: true, kpi: newKPIWindow(5)}
+	now := time.Now()
+	for _, code := range []int{401, 407} {
+		p.exposeCDR(&decoder.HEP{NodeName: "node1", CDR: &decoder.CDR{
+			Status: decoder.CDRFailed, FinalResponse: code, SetupTime: now, EndTime: now}})
+	}
+	assert.Empty(t, p.kpi.series)
+
+	p.exposeCDR(&decoder.HEP{NodeName: "node1", CDR: &decoder.CDR{Status: decoder.CDRFailed, FinalResponse: 503}})
+	assert.Len(t, p.kpi.series, 1)
+}
+
+func TestRefreshKPIStops(t *testing.T) {
+	config.Setting.PromTargetIP, config.Setting.PromTargetName = "", ""
+	p := &Prometheus{}
+	assert.NoError(t, p.setup())
+	p.end()
+	select {
+	case <-p.done:
+	default:
+		t.Error("refreshKPI still runs after end")
+	}
+}
+
+func TestRefreshBindings(t *testing.T) {
+	bindings := []decoder.Binding{
+		{AoR: "alice@a.example", Domain: "a.example", Contact: "alice@192.0.2.10"},
+		{AoR: "bob@a.example", Domain: "a.example", Contact: "bob@192.0.2.11"},
+		{AoR: "carol@b.example", Domain: "b.example", Contact: "carol@192.0.2.12"},
+	}
+	p := &Prometheus{TargetEmpty: true}
+	p.useBindings(func() []decoder.Binding { return bindings })
+
+	p.refreshBindings()
+	assert.Equal(t, 2, int(testutil.ToFloat64(regActive.WithLabelValues("", "a.example"))))
+	assert.Equal(t, 1, int(testutil.ToFloat64(regActive.WithLabelValues("", "b.example"))))
+
+	bindings = bindings[:1]
+	p.refreshBindings()
+	assert.Equal(t, 1, testutil.CollectAndCount(regActive))
+	assert.Equal(t, 1, int(testutil.ToFloat64(regActive.WithLabelValues("", "a.example"))))
+}
+
+func TestTargetOfEnrichment(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "trunks.csv"), []byte("cidr,trunk\n192.0.2.0/24,carrier-a\n"), 0644))
+	d, err := enrich.Load(dir, "")
+	assert.NoError(t, err)
+	enrich.Store(d)
+	defer enrich.Store(nil)
+
+	pkt := &decoder.HEP{TargetName: "node"}
+	p := &Prometheus{TargetEmpty: true}
+	assert.Equal(t, "node", p.targetOf(pkt, "192.0.2.1"))
+
+	p.enrichTarget = "trunk"
+	assert.Equal(t, "carrier-a", p.targetOf(pkt, "198.51.100.1", "192.0.2.1"))
+	assert.Equal(t, "unknown", p.targetOf(pkt, "198.51.100.1"))
+
+	p.TargetEmpty = false
+	p.TargetMap = map[string]string{"198.51.100.1": "proxy"}
+	assert.Equal(t, "proxy", p.targetOf(pkt, "198.51.100.1", "192.0.2.1"))
+}
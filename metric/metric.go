@@ -13,13 +13,17 @@ import (
 type Metric struct {
 	H    MetricHandler
 	Chan chan *decoder.HEP
-	quit chan bool
+	// Bindings returns the current registrations, optional
+	Bindings func() []decoder.Binding
+	quit     chan bool
 }
 
 type MetricHandler interface {
 	setup() error
 	reload()
 	expose(chan *decoder.HEP)
+	useBindings(func() []decoder.Binding)
+	end()
 }
 
 func New(name string) *Metric {
@@ -34,6 +38,9 @@ func New(name string) *Metric {
 }
 
 func (m *Metric) Run() error {
+	if m.Bindings != nil {
+		m.H.useBindings(m.Bindings)
+	}
 	err := m.H.setup()
 	if err != nil {
 		return err
@@ -66,5 +73,6 @@ func (m *Metric) End() {
 	m.quit <- true
 	<-m.quit
 	close(m.Chan)
+	m.H.end()
 	logp.Info("close metric channel")
 }
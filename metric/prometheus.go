@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/VictoriaMetrics/fastcache"
 	"github.com/negbie/logp"
@@ -16,6 +17,9 @@ const (
 	invite    = "INVITE"
 	register  = "REGISTER"
 	cacheSize = 60 * 1024 * 1024
+
+	// regRefresh is how often the registration gauge is recounted.
+	regRefresh = 10 * time.Second
 )
 
 type Prometheus struct {
@@ -24,14 +28,26 @@ type Prometheus struct {
 	TargetName  []string
 	TargetMap   map[string]string
 	TargetConf  *sync.RWMutex
-	cache       *fastcache.Cache
+	// enrichment attribute used as target name for IPs without target
+	enrichTarget string
+	cache        *fastcache.Cache
+	kpi          *kpiWindow
+	bindings     func() []decoder.Binding
+	regSeries    map[regKey]bool
+	quit         chan struct{}
+	done         chan struct{}
 }
 
 func (p *Prometheus) setup() (err error) {
 	p.TargetConf = new(sync.RWMutex)
 	p.TargetIP = strings.Split(cutSpace(config.Setting.PromTargetIP), ",")
 	p.TargetName = strings.Split(cutSpace(config.Setting.PromTargetName), ",")
+	p.enrichTarget = config.Setting.EnrichPromTarget
 	p.cache = fastcache.New(cacheSize)
+	p.kpi = newKPIWindow(config.Setting.PromKPIWindow)
+	p.quit = make(chan struct{})
+	p.done = make(chan struct{})
+	go p.refresh()
 
 	if len(p.TargetIP) == len(p.TargetName) && p.TargetIP != nil && p.TargetName != nil {
 		if len(p.TargetIP[0]) == 0 || len(p.TargetName[0]) == 0 {
@@ -56,8 +72,45 @@ func (p *Prometheus) setup() (err error) {
 	return err
 }
 
+func (p *Prometheus) useBindings(bindings func() []decoder.Binding) {
+	p.bindings = bindings
+}
+
+// refresh updates the metrics which are derived from state rather than
+// from single packets until end is called.
+func (p *Prometheus) refresh() {
+	defer close(p.done)
+	kpiTicker := time.NewTicker(time.Minute)
+	defer kpiTicker.Stop()
+	regTicker := time.NewTicker(regRefresh)
+	defer regTicker.Stop()
+	for {
+		select {
+		case <-p.quit:
+			return
+		case now := <-kpiTicker.C:
+			p.refreshKPI(now)
+		case <-regTicker.C:
+			p.refreshBindings()
+		}
+	}
+}
+
+func (p *Prometheus) end() {
+	close(p.quit)
+	<-p.done
+}
+
 func (p *Prometheus) expose(hCh chan *decoder.HEP) {
 	for pkt := range hCh {
+		if pkt.CDR != nil {
+			p.exposeCDR(pkt)
+			continue
+		}
+		if pkt.Binding != nil {
+			p.exposeBinding(pkt)
+			continue
+		}
 		packetsByType.WithLabelValues(pkt.NodeName, pkt.ProtoString).Inc()
 		packetsBySize.WithLabelValues(pkt.NodeName, pkt.ProtoString).Set(float64(len(pkt.Payload)))
 
@@ -68,9 +121,20 @@ func (p *Prometheus) expose(hCh chan *decoder.HEP) {
 			srcTarget, srcHit = p.TargetMap[pkt.SrcIP]
 			dstTarget, dstHit = p.TargetMap[pkt.DstIP]
 		}
+		if p.enrichTarget != "" {
+			if !srcHit {
+				srcTarget = pkt.Enrichment("src_" + p.enrichTarget)
+				srcHit = srcTarget != ""
+			}
+			if !dstHit {
+				dstTarget = pkt.Enrichment("dst_" + p.enrichTarget)
+				dstHit = dstTarget != ""
+			}
+		}
+		useTargets := !p.TargetEmpty || p.enrichTarget != ""
 
 		if pkt.SIP != nil && pkt.ProtoType == 1 {
-			if !p.TargetEmpty {
+			if useTargets {
 				if srcHit {
 					methodResponses.WithLabelValues(srcTarget, "src", pkt.NodeName, pkt.SIP.FirstMethod, pkt.SIP.CseqMethod).Inc()
 
@@ -87,7 +151,7 @@ func (p *Prometheus) expose(hCh chan *decoder.HEP) {
 			}
 
 			skip := false
-			if dstTarget == "" && srcTarget == "" && !p.TargetEmpty {
+			if dstTarget == "" && srcTarget == "" && useTargets {
 				skip = true
 			}
 
@@ -131,7 +195,7 @@ func (p *Prometheus) expose(hCh chan *decoder.HEP) {
 					}
 
 					if pkt.SIP.CseqMethod == invite {
-						srd.WithLabelValues(dstTarget, pkt.NodeName).Set(float64(d))
+						srd.WithLabelValues(dstTarget, pkt.NodeName).Observe(float64(d) / 1e9)
 					} else {
 						rrd.WithLabelValues(dstTarget, pkt.NodeName).Set(float64(d))
 						p.cache.Del([]byte(callID))
@@ -140,7 +204,7 @@ func (p *Prometheus) expose(hCh chan *decoder.HEP) {
 				}
 			}
 
-			if p.TargetEmpty {
+			if !useTargets {
 				k := []byte(callID + pkt.SIP.FirstMethod + pkt.SIP.CseqMethod)
 				if p.cache.Has(k) {
 					continue
@@ -153,6 +217,10 @@ func (p *Prometheus) expose(hCh chan *decoder.HEP) {
 				}
 			}
 
+			if pkt.SIP.Body != "" && pkt.SIP.FirstResp != "" && pkt.SIP.CseqMethod == invite {
+				p.exposeSDP(pkt)
+			}
+
 			if pkt.SIP.RTPStatVal != "" {
 				p.dissectXRTPStats(srcTarget, pkt.SIP.RTPStatVal)
 			}
@@ -0,0 +1,54 @@
+package metric
+
+import (
+	"strconv"
+
+	"github.com/sipcapture/heplify-server/decoder"
+)
+
+type regKey struct {
+	target string
+	domain string
+}
+
+// exposeBinding updates the registration metrics. A binding is accounted
+// to the target of the registrar or else of the registering client.
+func (p *Prometheus) exposeBinding(pkt *decoder.HEP) {
+	b := pkt.Binding
+	target := p.targetOf(pkt, b.DstIP, b.SrcIP)
+
+	switch b.Event {
+	case decoder.BindingExpired:
+		regExpired.WithLabelValues(target, b.Domain).Inc()
+	case decoder.BindingFailed:
+		response := strconv.Itoa(b.Response)
+		if b.Response >= 500 {
+			response = "5xx"
+		}
+		regFailures.WithLabelValues(target, b.AoR, response).Inc()
+	}
+}
+
+// refreshBindings counts the current bindings of the registrar. Counting
+// them instead of following the events keeps the gauge right when the
+// metric channel overflows.
+func (p *Prometheus) refreshBindings() {
+	if p.bindings == nil {
+		return
+	}
+	count := make(map[regKey]int)
+	for _, b := range p.bindings() {
+		target := p.targetOf(&decoder.HEP{}, b.DstIP, b.SrcIP)
+		count[regKey{target, b.Domain}]++
+	}
+	for key := range p.regSeries {
+		if _, ok := count[key]; !ok {
+			regActive.DeleteLabelValues(key.target, key.domain)
+		}
+	}
+	p.regSeries = make(map[regKey]bool, len(count))
+	for key, n := range count {
+		regActive.WithLabelValues(key.target, key.domain).Set(float64(n))
+		p.regSeries[key] = true
+	}
+}
@@ -0,0 +1,21 @@
+package metric
+
+import (
+	"github.com/sipcapture/heplify-server/decoder"
+)
+
+// exposeSDP counts the codec of every active stream of an SDP answer. The
+// answerer lists the negotiated codec first.
+func (p *Prometheus) exposeSDP(pkt *decoder.HEP) {
+	sdp := pkt.SIP.SDP()
+	if sdp == nil {
+		return
+	}
+	target := p.targetOf(pkt, pkt.SrcIP, pkt.DstIP)
+	for _, m := range sdp.Media {
+		if m.Port == 0 || len(m.Codecs) == 0 || m.Codecs[0].Name == "" {
+			continue
+		}
+		sdpCodecs.WithLabelValues(target, pkt.NodeName, m.Type, m.Codecs[0].Name).Inc()
+	}
+}
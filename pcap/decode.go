@@ -0,0 +1,501 @@
+package pcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/sipcapture/heplify-server/decoder"
+)
+
+const (
+	protoTCP = 6
+	protoUDP = 17
+
+	// HEP IP protocol family
+	familyIPv4 = 2
+	familyIPv6 = 10
+
+	fragTimeout    = 30 * time.Second
+	streamTimeout  = 5 * time.Minute
+	maxStreamBuf   = 1 << 16
+	maxOutOfOrder  = 64
+	maxFragmentBuf = 1 << 16
+)
+
+// Decoder turns captured frames into SIP HEP records. IPv4 and IPv6
+// fragments are reassembled, TCP streams are put back into order and split
+// into single SIP messages. Everything which doesn't look like SIP is
+// skipped.
+type Decoder struct {
+	NodeID   uint32
+	NodeName string
+	NodePW   string
+
+	frags   map[fragKey]*fragment
+	streams map[flowKey]*stream
+	last    time.Time
+}
+
+type fragKey struct {
+	src, dst string
+	id       uint32
+	proto    uint8
+}
+
+type fragment struct {
+	first time.Time
+	parts map[int][]byte
+	total int
+}
+
+type flowKey struct {
+	src, dst string
+	sport    uint16
+	dport    uint16
+}
+
+type stream struct {
+	seen    time.Time
+	synced  bool
+	next    uint32
+	buf     []byte
+	pending map[uint32][]byte
+}
+
+type layer struct {
+	family   uint32
+	src, dst string
+	proto    uint8
+	vlan     uint32
+	payload  []byte
+}
+
+func NewDecoder() *Decoder {
+	return &Decoder{
+		frags:   make(map[fragKey]*fragment),
+		streams: make(map[flowKey]*stream),
+	}
+}
+
+// Decode returns the SIP messages which are completed by the packet.
+func (d *Decoder) Decode(p *Packet) []*decoder.HEP {
+	d.expire(p.Timestamp)
+
+	l, ok := d.network(p)
+	if !ok {
+		return nil
+	}
+
+	switch l.proto {
+	case protoUDP:
+		if len(l.payload) < 8 {
+			return nil
+		}
+		sport := binary.BigEndian.Uint16(l.payload[0:2])
+		dport := binary.BigEndian.Uint16(l.payload[2:4])
+		data := l.payload[8:]
+		if ul := int(binary.BigEndian.Uint16(l.payload[4:6])); ul >= 8 && ul-8 < len(data) {
+			data = data[:ul-8]
+		}
+		if !isSIP(data) {
+			return nil
+		}
+		return []*decoder.HEP{d.hep(p.Timestamp, &l, protoUDP, sport, dport, data)}
+	case protoTCP:
+		return d.tcp(p.Timestamp, &l)
+	}
+	return nil
+}
+
+func (d *Decoder) hep(ts time.Time, l *layer, proto uint32, sport, dport uint16, data []byte) *decoder.HEP {
+	return &decoder.HEP{
+		Version:   l.family,
+		Protocol:  proto,
+		SrcIP:     l.src,
+		DstIP:     l.dst,
+		SrcPort:   uint32(sport),
+		DstPort:   uint32(dport),
+		Tsec:      uint32(ts.Unix()),
+		Tmsec:     uint32(ts.Nanosecond() / 1000),
+		ProtoType: 1,
+		NodeID:    d.NodeID,
+		NodeName:  d.NodeName,
+		NodePW:    d.NodePW,
+		Vlan:      l.vlan,
+		Payload:   string(data),
+		Timestamp: ts,
+	}
+}
+
+// network strips the link layer and returns the reassembled IP payload.
+func (d *Decoder) network(p *Packet) (layer, bool) {
+	var l layer
+	data := p.Data
+	var etype uint16
+
+	switch p.LinkType {
+	case LinkTypeEthernet:
+		if len(data) < 14 {
+			return l, false
+		}
+		etype = binary.BigEndian.Uint16(data[12:14])
+		data = data[14:]
+	case LinkTypeLinuxSLL:
+		if len(data) < 16 {
+			return l, false
+		}
+		etype = binary.BigEndian.Uint16(data[14:16])
+		data = data[16:]
+	case LinkTypeLinuxSL2:
+		if len(data) < 20 {
+			return l, false
+		}
+		etype = binary.BigEndian.Uint16(data[0:2])
+		data = data[20:]
+	case LinkTypeNull:
+		if len(data) < 4 {
+			return l, false
+		}
+		// the family is in host byte order of the capturing machine
+		family := binary.LittleEndian.Uint32(data[0:4])
+		if family > 0xffff {
+			family = binary.BigEndian.Uint32(data[0:4])
+		}
+		switch family {
+		case 2:
+			etype = 0x0800
+		case 24, 28, 30:
+			etype = 0x86dd
+		}
+		data = data[4:]
+	case LinkTypeRaw, LinkTypeIPv4, LinkTypeIPv6, 12, 14:
+		if len(data) == 0 {
+			return l, false
+		}
+		etype = 0x0800
+		if data[0]>>4 == 6 {
+			etype = 0x86dd
+		}
+	default:
+		return l, false
+	}
+
+	for etype == 0x8100 || etype == 0x88a8 {
+		if len(data) < 4 {
+			return l, false
+		}
+		if l.vlan == 0 {
+			l.vlan = uint32(binary.BigEndian.Uint16(data[0:2]) & 0x0fff)
+		}
+		etype = binary.BigEndian.Uint16(data[2:4])
+		data = data[4:]
+	}
+
+	switch etype {
+	case 0x0800:
+		return d.ipv4(p.Timestamp, l, data)
+	case 0x86dd:
+		return d.ipv6(p.Timestamp, l, data)
+	}
+	return l, false
+}
+
+func (d *Decoder) ipv4(ts time.Time, l layer, data []byte) (layer, bool) {
+	if len(data) < 20 || data[0]>>4 != 4 {
+		return l, false
+	}
+	ihl := int(data[0]&0x0f) * 4
+	total := int(binary.BigEndian.Uint16(data[2:4]))
+	if ihl < 20 || total < ihl || total > len(data) {
+		return l, false
+	}
+	l.family = familyIPv4
+	l.src = net.IP(data[12:16]).String()
+	l.dst = net.IP(data[16:20]).String()
+	l.proto = data[9]
+	l.payload = data[ihl:total]
+
+	flags := binary.BigEndian.Uint16(data[6:8])
+	more := flags&0x2000 != 0
+	offset := int(flags&0x1fff) * 8
+	if !more && offset == 0 {
+		return l, true
+	}
+	key := fragKey{l.src, l.dst, uint32(binary.BigEndian.Uint16(data[4:6])), l.proto}
+	l.payload = d.defrag(ts, key, offset, more, l.payload)
+	return l, l.payload != nil
+}
+
+func (d *Decoder) ipv6(ts time.Time, l layer, data []byte) (layer, bool) {
+	if len(data) < 40 || data[0]>>4 != 6 {
+		return l, false
+	}
+	plen := int(binary.BigEndian.Uint16(data[4:6]))
+	if 40+plen > len(data) {
+		return l, false
+	}
+	l.family = familyIPv6
+	l.src = net.IP(data[8:24]).String()
+	l.dst = net.IP(data[24:40]).String()
+	next := data[6]
+	data = data[40 : 40+plen]
+
+	for {
+		switch next {
+		case 0, 43, 60:
+			if len(data) < 8 {
+				return l, false
+			}
+			hl := (int(data[1]) + 1) * 8
+			if hl > len(data) {
+				return l, false
+			}
+			next, data = data[0], data[hl:]
+		case 44:
+			if len(data) < 8 {
+				return l, false
+			}
+			fo := binary.BigEndian.Uint16(data[2:4])
+			key := fragKey{l.src, l.dst, binary.BigEndian.Uint32(data[4:8]), data[0]}
+			l.proto = data[0]
+			l.payload = d.defrag(ts, key, int(fo&0xfff8), fo&1 != 0, data[8:])
+			return l, l.payload != nil
+		default:
+			l.proto = next
+			l.payload = data
+			return l, true
+		}
+	}
+}
+
+// defrag collects the fragment and returns the whole datagram once every
+// part is there.
+func (d *Decoder) defrag(ts time.Time, key fragKey, offset int, more bool, data []byte) []byte {
+	f, ok := d.frags[key]
+	if !ok {
+		f = &fragment{first: ts, parts: make(map[int][]byte), total: -1}
+		d.frags[key] = f
+	}
+	if offset+len(data) > maxFragmentBuf {
+		delete(d.frags, key)
+		return nil
+	}
+	f.parts[offset] = append([]byte(nil), data...)
+	if !more {
+		f.total = offset + len(data)
+	}
+	if f.total < 0 {
+		return nil
+	}
+
+	offsets := make([]int, 0, len(f.parts))
+	for o := range f.parts {
+		offsets = append(offsets, o)
+	}
+	sort.Ints(offsets)
+	out := make([]byte, 0, f.total)
+	for _, o := range offsets {
+		if o > len(out) {
+			return nil
+		}
+		p := f.parts[o]
+		if end := o + len(p); end > len(out) {
+			out = append(out, p[len(out)-o:]...)
+		}
+	}
+	if len(out) < f.total {
+		return nil
+	}
+	delete(d.frags, key)
+	return out[:f.total]
+}
+
+func (d *Decoder) tcp(ts time.Time, l *layer) []*decoder.HEP {
+	data := l.payload
+	if len(data) < 20 {
+		return nil
+	}
+	off := int(data[12]>>4) * 4
+	if off < 20 || off > len(data) {
+		return nil
+	}
+	key := flowKey{l.src, l.dst, binary.BigEndian.Uint16(data[0:2]), binary.BigEndian.Uint16(data[2:4])}
+	seq := binary.BigEndian.Uint32(data[4:8])
+	flags := data[13]
+	syn, fin, rst := flags&0x02 != 0, flags&0x01 != 0, flags&0x04 != 0
+	payload := data[off:]
+
+	s, ok := d.streams[key]
+	if !ok {
+		if len(payload) == 0 && !syn {
+			return nil
+		}
+		s = &stream{pending: make(map[uint32][]byte)}
+		d.streams[key] = s
+	}
+	s.seen = ts
+	if syn {
+		s.synced, s.next, s.buf = true, seq+1, s.buf[:0]
+		clear(s.pending)
+	} else if !s.synced {
+		s.synced, s.next = true, seq
+	}
+
+	var out []*decoder.HEP
+	if len(payload) > 0 {
+		s.add(seq, payload)
+		for _, msg := range s.messages() {
+			out = append(out, d.hep(ts, l, protoTCP, key.sport, key.dport, msg))
+		}
+	}
+	if fin || rst {
+		delete(d.streams, key)
+	}
+	return out
+}
+
+// add appends in order data and keeps segments after a gap until the gap is
+// filled. If too many segments are waiting the gap is skipped.
+func (s *stream) add(seq uint32, data []byte) {
+	if diff := int32(seq - s.next); diff > 0 {
+		if len(s.pending) < maxOutOfOrder {
+			s.pending[seq] = append([]byte(nil), data...)
+			return
+		}
+		// give up on the gap, whatever is buffered can't be completed
+		s.buf = s.buf[:0]
+		s.next = seq
+		for k := range s.pending {
+			if int32(k-seq) < 0 {
+				delete(s.pending, k)
+			}
+		}
+	} else if diff < 0 {
+		if int(-diff) >= len(data) {
+			return
+		}
+		data = data[-diff:]
+	}
+	s.buf = append(s.buf, data...)
+	s.next += uint32(len(data))
+
+	for len(s.pending) > 0 {
+		progress := false
+		for k, p := range s.pending {
+			diff := int32(k - s.next)
+			if diff > 0 {
+				continue
+			}
+			delete(s.pending, k)
+			if int(-diff) < len(p) {
+				s.buf = append(s.buf, p[-diff:]...)
+				s.next += uint32(len(p) + int(diff))
+			}
+			progress = true
+		}
+		if !progress {
+			break
+		}
+	}
+	if len(s.buf) > maxStreamBuf {
+		s.buf = s.buf[:0]
+	}
+}
+
+// messages splits the stream buffer at the SIP message boundaries given by
+// Content-Length and returns all complete messages.
+func (s *stream) messages() [][]byte {
+	var out [][]byte
+	for {
+		b := bytes.TrimLeft(s.buf, "\r\n")
+		if len(b) == 0 {
+			s.buf = s.buf[:0]
+			break
+		}
+		end := bytes.Index(b, []byte("\r\n\r\n"))
+		if end < 0 {
+			if !isSIPPrefix(b) {
+				s.buf = s.buf[:0]
+			}
+			break
+		}
+		if !isSIP(b) {
+			s.buf = s.buf[:0]
+			break
+		}
+		n := end + 4 + contentLength(b[:end+2])
+		if n > len(b) {
+			break
+		}
+		out = append(out, append([]byte(nil), b[:n]...))
+		s.buf = append(s.buf[:0], b[n:]...)
+	}
+	return out
+}
+
+func (d *Decoder) expire(now time.Time) {
+	if now.Sub(d.last) < time.Second {
+		return
+	}
+	d.last = now
+	for k, f := range d.frags {
+		if now.Sub(f.first) > fragTimeout {
+			delete(d.frags, k)
+		}
+	}
+	for k, s := range d.streams {
+		if now.Sub(s.seen) > streamTimeout {
+			delete(d.streams, k)
+		}
+	}
+}
+
+var sipVersion = []byte("SIP/2.0")
+
+// isSIP checks for a SIP status or request line.
+func isSIP(b []byte) bool {
+	line, _, ok := bytes.Cut(b, []byte("\r\n"))
+	if !ok {
+		return false
+	}
+	return bytes.HasPrefix(line, sipVersion) || bytes.HasSuffix(line, sipVersion) && bytes.IndexByte(line, ' ') > 0
+}
+
+// isSIPPrefix reports whether b could still become a SIP message.
+func isSIPPrefix(b []byte) bool {
+	if len(b) < len(sipVersion) {
+		return true
+	}
+	if bytes.HasPrefix(b, sipVersion) {
+		return true
+	}
+	method, _, _ := bytes.Cut(b, []byte(" "))
+	for _, c := range method {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+func contentLength(hdr []byte) int {
+	for _, line := range bytes.Split(hdr, []byte("\r\n")) {
+		name, value, ok := bytes.Cut(line, []byte(":"))
+		if !ok {
+			continue
+		}
+		name = bytes.TrimSpace(name)
+		if bytes.EqualFold(name, []byte("Content-Length")) || bytes.EqualFold(name, []byte("l")) {
+			n, err := strconv.Atoi(string(bytes.TrimSpace(value)))
+			if err != nil || n < 0 {
+				return 0
+			}
+			return n
+		}
+	}
+	return 0
+}
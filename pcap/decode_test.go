@@ -0,0 +1,159 @@
+package pcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	sipInvite = "INVITE sip:bob@example.com SIP/2.0\r\nCall-ID: abc@host\r\nContent-Length: 4\r\n\r\nv=0\n"
+	sipOK     = "SIP/2.0 200 OK\r\nCall-ID: abc@host\r\nl: 0\r\n\r\n"
+)
+
+var (
+	srcIP = net.IPv4(10, 0, 0, 1).To4()
+	dstIP = net.IPv4(10, 0, 0, 2).To4()
+)
+
+func ipv4Frame(proto uint8, id uint16, fragOff int, more bool, payload []byte) []byte {
+	eth := make([]byte, 14, 14+20+len(payload))
+	binary.BigEndian.PutUint16(eth[12:], 0x0800)
+	ip := make([]byte, 20)
+	ip[0] = 0x45
+	binary.BigEndian.PutUint16(ip[2:], uint16(20+len(payload)))
+	binary.BigEndian.PutUint16(ip[4:], id)
+	flags := uint16(fragOff / 8)
+	if more {
+		flags |= 0x2000
+	}
+	binary.BigEndian.PutUint16(ip[6:], flags)
+	ip[8] = 64
+	ip[9] = proto
+	copy(ip[12:], srcIP)
+	copy(ip[16:], dstIP)
+	return append(append(eth, ip...), payload...)
+}
+
+func ipv6Frame(proto uint8, payload []byte) []byte {
+	eth := make([]byte, 14, 14+40+len(payload))
+	binary.BigEndian.PutUint16(eth[12:], 0x86dd)
+	ip := make([]byte, 40)
+	ip[0] = 0x60
+	binary.BigEndian.PutUint16(ip[4:], uint16(len(payload)))
+	ip[6] = proto
+	ip[7] = 64
+	copy(ip[8:], net.ParseIP("2001:db8::1"))
+	copy(ip[24:], net.ParseIP("2001:db8::2"))
+	return append(append(eth, ip...), payload...)
+}
+
+func udpSegment(data string) []byte {
+	u := make([]byte, 8)
+	binary.BigEndian.PutUint16(u[0:], 5060)
+	binary.BigEndian.PutUint16(u[2:], 5080)
+	binary.BigEndian.PutUint16(u[4:], uint16(8+len(data)))
+	return append(u, data...)
+}
+
+func tcpSegment(seq uint32, flags byte, data string) []byte {
+	t := make([]byte, 20)
+	binary.BigEndian.PutUint16(t[0:], 5060)
+	binary.BigEndian.PutUint16(t[2:], 5080)
+	binary.BigEndian.PutUint32(t[4:], seq)
+	t[12] = 5 << 4
+	t[13] = flags
+	return append(t, data...)
+}
+
+func TestDecodeUDP(t *testing.T) {
+	d := NewDecoder()
+	d.NodeID = 7
+	ts := time.Date(2020, 1, 2, 3, 4, 5, 678901000, time.UTC)
+
+	heps := d.Decode(&Packet{Timestamp: ts, LinkType: LinkTypeEthernet, Data: ipv4Frame(protoUDP, 1, 0, false, udpSegment(sipInvite))})
+	if assert.Len(t, heps, 1) {
+		h := heps[0]
+		assert.Equal(t, sipInvite, h.Payload)
+		assert.Equal(t, "10.0.0.1", h.SrcIP)
+		assert.Equal(t, "10.0.0.2", h.DstIP)
+		assert.Equal(t, uint32(5060), h.SrcPort)
+		assert.Equal(t, uint32(5080), h.DstPort)
+		assert.Equal(t, uint32(17), h.Protocol)
+		assert.Equal(t, uint32(2), h.Version)
+		assert.Equal(t, uint32(1), h.ProtoType)
+		assert.Equal(t, uint32(7), h.NodeID)
+		assert.Equal(t, uint32(ts.Unix()), h.Tsec)
+		assert.Equal(t, uint32(678901), h.Tmsec)
+	}
+
+	heps = d.Decode(&Packet{Timestamp: ts, LinkType: LinkTypeEthernet, Data: ipv6Frame(protoUDP, udpSegment(sipInvite))})
+	if assert.Len(t, heps, 1) {
+		assert.Equal(t, "2001:db8::1", heps[0].SrcIP)
+		assert.Equal(t, uint32(10), heps[0].Version)
+	}
+
+	// keepalives and other UDP traffic are skipped
+	assert.Empty(t, d.Decode(&Packet{Timestamp: ts, LinkType: LinkTypeEthernet, Data: ipv4Frame(protoUDP, 2, 0, false, udpSegment("\r\n\r\n"))}))
+	assert.Empty(t, d.Decode(&Packet{Timestamp: ts, LinkType: LinkTypeEthernet, Data: ipv4Frame(protoUDP, 3, 0, false, udpSegment("hello world"))}))
+}
+
+func TestDecodeFragments(t *testing.T) {
+	d := NewDecoder()
+	ts := time.Now()
+	udp := udpSegment(sipInvite)
+
+	// second fragment first
+	assert.Empty(t, d.Decode(&Packet{Timestamp: ts, LinkType: LinkTypeEthernet, Data: ipv4Frame(protoUDP, 9, 48, false, udp[48:])}))
+	heps := d.Decode(&Packet{Timestamp: ts, LinkType: LinkTypeEthernet, Data: ipv4Frame(protoUDP, 9, 0, true, udp[:48])})
+	if assert.Len(t, heps, 1) {
+		assert.Equal(t, sipInvite, heps[0].Payload)
+	}
+	assert.Empty(t, d.frags)
+}
+
+func TestDecodeTCP(t *testing.T) {
+	d := NewDecoder()
+	ts := time.Now()
+	pkt := func(seq uint32, flags byte, data string) *Packet {
+		return &Packet{Timestamp: ts, LinkType: LinkTypeEthernet, Data: ipv4Frame(protoTCP, 0, 0, false, tcpSegment(seq, flags, data))}
+	}
+	decode := func(p *Packet) (out []string) {
+		for _, h := range d.Decode(p) {
+			assert.Equal(t, uint32(6), h.Protocol)
+			out = append(out, h.Payload)
+		}
+		return out
+	}
+
+	stream := sipInvite + "\r\n\r\n" + sipOK
+	assert.Empty(t, decode(pkt(99, 0x02, "")))
+	// out of order segments
+	assert.Empty(t, decode(pkt(120, 0x18, stream[20:50])))
+	assert.Empty(t, decode(pkt(100, 0x18, stream[:20])))
+	// retransmission overlapping the buffered data
+	assert.Equal(t, []string{sipInvite}, decode(pkt(140, 0x18, stream[40:len(sipInvite)+10])))
+	assert.Equal(t, []string{sipOK}, decode(pkt(100+uint32(len(sipInvite))+10, 0x19, stream[len(sipInvite)+10:])))
+	assert.Empty(t, d.streams)
+
+	// stream without handshake in the capture, two messages in one segment
+	assert.Equal(t, []string{sipOK, sipOK}, decode(pkt(5000, 0x18, sipOK+sipOK)))
+
+	// garbage resets the stream buffer
+	assert.Empty(t, decode(pkt(5000+2*uint32(len(sipOK)), 0x18, "\x16\x03\x01binary")))
+	assert.Empty(t, d.streams[flowKey{"10.0.0.1", "10.0.0.2", 5060, 5080}].buf)
+}
+
+func TestContentLength(t *testing.T) {
+	assert.Equal(t, 12, contentLength([]byte("SIP/2.0 200 OK\r\ncontent-length:  12 \r\n")))
+	assert.Equal(t, 3, contentLength([]byte("SIP/2.0 200 OK\r\nl: 3\r\n")))
+	assert.Equal(t, 0, contentLength([]byte("SIP/2.0 200 OK\r\nContent-Length: x\r\n")))
+	assert.True(t, isSIP([]byte(sipInvite)))
+	assert.False(t, isSIP([]byte("GET / HTTP/1.1\r\n")))
+	assert.True(t, isSIPPrefix([]byte("REGIS")))
+	assert.False(t, isSIPPrefix(bytes.Repeat([]byte{0}, 10)))
+}
@@ -0,0 +1,275 @@
+// Package pcap reads and writes pcap and pcapng capture files and turns the
+// captured frames into HEP records.
+package pcap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"time"
+)
+
+// LinkType is the link layer header type of a capture interface.
+type LinkType uint16
+
+const (
+	LinkTypeNull     LinkType = 0
+	LinkTypeEthernet LinkType = 1
+	LinkTypeRaw      LinkType = 101
+	LinkTypeLinuxSLL LinkType = 113
+	LinkTypeIPv4     LinkType = 228
+	LinkTypeIPv6     LinkType = 229
+	LinkTypeLinuxSL2 LinkType = 276
+)
+
+const (
+	magicMicro        = 0xa1b2c3d4
+	magicNano         = 0xa1b23c4d
+	magicMicroSwapped = 0xd4c3b2a1
+	magicNanoSwapped  = 0x4d3cb2a1
+
+	blockSHB = 0x0a0d0d0a
+	blockIDB = 0x00000001
+	blockPB  = 0x00000002
+	blockSPB = 0x00000003
+	blockEPB = 0x00000006
+
+	byteOrderMagic = 0x1a2b3c4d
+	maxBlockLen    = 16 << 20
+)
+
+var ErrFormat = errors.New("pcap: unknown file format")
+
+// Packet is a single captured frame.
+type Packet struct {
+	Timestamp time.Time
+	LinkType  LinkType
+	Data      []byte
+}
+
+type iface struct {
+	linkType LinkType
+	snapLen  uint32
+	units    uint64 // timestamp units per second
+	offset   int64  // seconds added to every timestamp
+}
+
+// Reader reads packets from a pcap or pcapng stream. The format is detected
+// by the magic number at the start of the stream.
+type Reader struct {
+	r      *bufio.Reader
+	order  binary.ByteOrder
+	ng     bool
+	ifaces []iface
+	buf    []byte
+}
+
+func NewReader(r io.Reader) (*Reader, error) {
+	pr := &Reader{r: bufio.NewReaderSize(r, 1<<16)}
+	hdr, err := pr.r.Peek(4)
+	if err != nil {
+		return nil, ErrFormat
+	}
+
+	if binary.BigEndian.Uint32(hdr) == blockSHB {
+		pr.ng = true
+		return pr, nil
+	}
+
+	var fh [24]byte
+	if _, err := io.ReadFull(pr.r, fh[:]); err != nil {
+		return nil, ErrFormat
+	}
+	var units uint64
+	switch binary.LittleEndian.Uint32(fh[:4]) {
+	case magicMicro:
+		pr.order, units = binary.LittleEndian, 1e6
+	case magicNano:
+		pr.order, units = binary.LittleEndian, 1e9
+	case magicMicroSwapped:
+		pr.order, units = binary.BigEndian, 1e6
+	case magicNanoSwapped:
+		pr.order, units = binary.BigEndian, 1e9
+	default:
+		return nil, ErrFormat
+	}
+	pr.ifaces = []iface{{
+		linkType: LinkType(pr.order.Uint32(fh[20:24])),
+		snapLen:  pr.order.Uint32(fh[16:20]),
+		units:    units,
+	}}
+	return pr, nil
+}
+
+// ReadPacket returns the next packet. The Data slice is only valid until the
+// next call. At the end of the stream it returns io.EOF.
+func (pr *Reader) ReadPacket() (*Packet, error) {
+	if pr.ng {
+		return pr.readBlock()
+	}
+
+	var ph [16]byte
+	if _, err := io.ReadFull(pr.r, ph[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+	capLen := pr.order.Uint32(ph[8:12])
+	if capLen > maxBlockLen {
+		return nil, fmt.Errorf("pcap: invalid capture length %d", capLen)
+	}
+	data, err := pr.read(int(capLen))
+	if err != nil {
+		return nil, err
+	}
+	ifc := &pr.ifaces[0]
+	return &Packet{
+		Timestamp: ifc.time(uint64(pr.order.Uint32(ph[0:4])), uint64(pr.order.Uint32(ph[4:8]))),
+		LinkType:  ifc.linkType,
+		Data:      data,
+	}, nil
+}
+
+func (pr *Reader) readBlock() (*Packet, error) {
+	for {
+		var bh [8]byte
+		if _, err := io.ReadFull(pr.r, bh[:]); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				return nil, io.EOF
+			}
+			return nil, err
+		}
+
+		if binary.BigEndian.Uint32(bh[:4]) == blockSHB {
+			bom, err := pr.r.Peek(4)
+			if err != nil {
+				return nil, io.EOF
+			}
+			switch {
+			case binary.LittleEndian.Uint32(bom) == byteOrderMagic:
+				pr.order = binary.LittleEndian
+			case binary.BigEndian.Uint32(bom) == byteOrderMagic:
+				pr.order = binary.BigEndian
+			default:
+				return nil, ErrFormat
+			}
+			pr.ifaces = pr.ifaces[:0]
+		} else if pr.order == nil {
+			return nil, ErrFormat
+		}
+
+		blockLen := pr.order.Uint32(bh[4:8])
+		if blockLen < 12 || blockLen%4 != 0 || blockLen > maxBlockLen {
+			return nil, fmt.Errorf("pcap: invalid block length %d", blockLen)
+		}
+		body, err := pr.read(int(blockLen) - 8)
+		if err != nil {
+			return nil, err
+		}
+		body = body[:len(body)-4]
+
+		switch pr.order.Uint32(bh[:4]) {
+		case blockIDB:
+			if len(body) < 8 {
+				return nil, errors.New("pcap: short interface block")
+			}
+			pr.ifaces = append(pr.ifaces, pr.parseIDB(body))
+		case blockEPB:
+			if len(body) < 20 {
+				return nil, errors.New("pcap: short packet block")
+			}
+			return pr.packet(pr.order.Uint32(body[0:4]), body[4:12], pr.order.Uint32(body[12:16]), body[20:])
+		case blockPB:
+			if len(body) < 20 {
+				return nil, errors.New("pcap: short packet block")
+			}
+			return pr.packet(uint32(pr.order.Uint16(body[0:2])), body[4:12], pr.order.Uint32(body[12:16]), body[20:])
+		case blockSPB:
+			if len(body) < 4 || len(pr.ifaces) == 0 {
+				return nil, errors.New("pcap: invalid simple packet block")
+			}
+			capLen := pr.order.Uint32(body[0:4])
+			if snap := pr.ifaces[0].snapLen; snap > 0 && capLen > snap {
+				capLen = snap
+			}
+			if int(capLen) > len(body)-4 {
+				capLen = uint32(len(body) - 4)
+			}
+			return &Packet{LinkType: pr.ifaces[0].linkType, Data: body[4 : 4+capLen]}, nil
+		}
+	}
+}
+
+func (pr *Reader) packet(id uint32, ts []byte, capLen uint32, data []byte) (*Packet, error) {
+	if int(id) >= len(pr.ifaces) {
+		return nil, fmt.Errorf("pcap: packet for unknown interface %d", id)
+	}
+	if int(capLen) > len(data) {
+		return nil, fmt.Errorf("pcap: invalid capture length %d", capLen)
+	}
+	ifc := &pr.ifaces[id]
+	t := uint64(pr.order.Uint32(ts[0:4]))<<32 | uint64(pr.order.Uint32(ts[4:8]))
+	return &Packet{
+		Timestamp: ifc.time(t/ifc.units, t%ifc.units),
+		LinkType:  ifc.linkType,
+		Data:      data[:capLen],
+	}, nil
+}
+
+func (pr *Reader) parseIDB(body []byte) iface {
+	ifc := iface{
+		linkType: LinkType(pr.order.Uint16(body[0:2])),
+		snapLen:  pr.order.Uint32(body[4:8]),
+		units:    1e6,
+	}
+	opts := body[8:]
+	for len(opts) >= 4 {
+		code := pr.order.Uint16(opts[0:2])
+		l := int(pr.order.Uint16(opts[2:4]))
+		if code == 0 || 4+l > len(opts) {
+			break
+		}
+		v := opts[4 : 4+l]
+		switch {
+		case code == 9 && l == 1:
+			res := uint(v[0] & 0x7f)
+			if v[0]&0x80 != 0 && res < 64 {
+				ifc.units = 1 << res
+			} else if v[0]&0x80 == 0 && res <= 19 {
+				ifc.units = 1
+				for ; res > 0; res-- {
+					ifc.units *= 10
+				}
+			}
+		case code == 14 && l == 8:
+			ifc.offset = int64(pr.order.Uint64(v))
+		}
+		opts = opts[4+(l+3)&^3:]
+	}
+	return ifc
+}
+
+// time converts seconds and fractional units to a UTC time.
+func (ifc *iface) time(sec, frac uint64) time.Time {
+	hi, lo := bits.Mul64(frac%ifc.units, 1e9)
+	nsec, _ := bits.Div64(hi, lo, ifc.units)
+	return time.Unix(int64(sec)+ifc.offset, int64(nsec)).UTC()
+}
+
+func (pr *Reader) read(n int) ([]byte, error) {
+	if cap(pr.buf) < n {
+		pr.buf = make([]byte, n)
+	}
+	pr.buf = pr.buf[:n]
+	if _, err := io.ReadFull(pr.r, pr.buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return pr.buf, nil
+}
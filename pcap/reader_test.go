@@ -0,0 +1,112 @@
+package pcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func pcapFile(order binary.ByteOrder, magic uint32, frames ...[]byte) []byte {
+	b := make([]byte, 24)
+	order.PutUint32(b[0:], magic)
+	order.PutUint16(b[4:], 2)
+	order.PutUint16(b[6:], 4)
+	order.PutUint32(b[16:], 65535)
+	order.PutUint32(b[20:], uint32(LinkTypeEthernet))
+	for i, f := range frames {
+		ph := make([]byte, 16)
+		order.PutUint32(ph[0:], 1577934245+uint32(i))
+		order.PutUint32(ph[4:], 500)
+		order.PutUint32(ph[8:], uint32(len(f)))
+		order.PutUint32(ph[12:], uint32(len(f)))
+		b = append(append(b, ph...), f...)
+	}
+	return b
+}
+
+func ngBlock(typ uint32, body []byte) []byte {
+	for len(body)%4 != 0 {
+		body = append(body, 0)
+	}
+	b := make([]byte, 8, 12+len(body))
+	binary.LittleEndian.PutUint32(b[0:], typ)
+	binary.LittleEndian.PutUint32(b[4:], uint32(12+len(body)))
+	b = append(b, body...)
+	return binary.LittleEndian.AppendUint32(b, uint32(12+len(body)))
+}
+
+func TestReadPcap(t *testing.T) {
+	frame := ipv4Frame(protoUDP, 1, 0, false, udpSegment(sipOK))
+	for _, tc := range []struct {
+		order binary.ByteOrder
+		magic uint32
+		nsec  int
+	}{
+		{binary.LittleEndian, magicMicro, 500000},
+		{binary.BigEndian, magicMicro, 500000},
+		{binary.LittleEndian, magicNano, 500},
+	} {
+		r, err := NewReader(bytes.NewReader(pcapFile(tc.order, tc.magic, frame, frame)))
+		if !assert.NoError(t, err) {
+			continue
+		}
+		for i := 0; i < 2; i++ {
+			p, err := r.ReadPacket()
+			if assert.NoError(t, err) {
+				assert.Equal(t, time.Unix(1577934245+int64(i), int64(tc.nsec)).UTC(), p.Timestamp)
+				assert.Equal(t, LinkTypeEthernet, p.LinkType)
+				assert.Equal(t, frame, p.Data)
+			}
+		}
+		_, err = r.ReadPacket()
+		assert.Equal(t, io.EOF, err)
+	}
+
+	_, err := NewReader(bytes.NewReader([]byte("not a capture file")))
+	assert.Equal(t, ErrFormat, err)
+}
+
+func TestReadPcapng(t *testing.T) {
+	frame := ipv4Frame(protoUDP, 1, 0, false, udpSegment(sipOK))
+
+	shb := binary.LittleEndian.AppendUint32(nil, byteOrderMagic)
+	shb = append(shb, 1, 0, 0, 0, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff)
+
+	// nanosecond resolution through if_tsresol
+	idb := []byte{byte(LinkTypeEthernet), 0, 0, 0, 0xff, 0xff, 0, 0}
+	idb = append(idb, 9, 0, 1, 0, 9, 0, 0, 0, 0, 0, 0, 0)
+
+	ts := uint64(1577934245)*1e9 + 123456789
+	epb := binary.LittleEndian.AppendUint32(nil, 0)
+	epb = binary.LittleEndian.AppendUint32(epb, uint32(ts>>32))
+	epb = binary.LittleEndian.AppendUint32(epb, uint32(ts))
+	epb = binary.LittleEndian.AppendUint32(epb, uint32(len(frame)))
+	epb = binary.LittleEndian.AppendUint32(epb, uint32(len(frame)))
+	epb = append(epb, frame...)
+
+	var file []byte
+	file = append(file, ngBlock(blockSHB, shb)...)
+	file = append(file, ngBlock(blockIDB, idb)...)
+	file = append(file, ngBlock(0x0bad, []byte("custom"))...)
+	file = append(file, ngBlock(blockEPB, epb)...)
+
+	r, err := NewReader(bytes.NewReader(file))
+	if !assert.NoError(t, err) {
+		return
+	}
+	p, err := r.ReadPacket()
+	if assert.NoError(t, err) {
+		assert.Equal(t, time.Unix(1577934245, 123456789).UTC(), p.Timestamp)
+		assert.Equal(t, frame, p.Data)
+		heps := NewDecoder().Decode(p)
+		if assert.Len(t, heps, 1) {
+			assert.Equal(t, sipOK, heps[0].Payload)
+		}
+	}
+	_, err = r.ReadPacket()
+	assert.Equal(t, io.EOF, err)
+}
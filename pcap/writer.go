@@ -0,0 +1,234 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/sipcapture/heplify-server/decoder"
+)
+
+const (
+	optEnd     = 0
+	optComment = 1
+	optTSResol = 9
+)
+
+// NgWriter writes a pcapng file with a single raw IP interface and
+// microsecond timestamps.
+type NgWriter struct {
+	w   io.Writer
+	buf []byte
+}
+
+func NewNgWriter(w io.Writer) (*NgWriter, error) {
+	nw := &NgWriter{w: w}
+
+	shb := binary.LittleEndian.AppendUint32(nil, byteOrderMagic)
+	shb = binary.LittleEndian.AppendUint16(shb, 1)
+	shb = binary.LittleEndian.AppendUint16(shb, 0)
+	shb = binary.LittleEndian.AppendUint64(shb, 0xffffffffffffffff)
+	shb = appendOpt(shb, 4, []byte("heplify-server"))
+	shb = appendOpt(shb, optEnd, nil)
+	if err := nw.block(blockSHB, shb); err != nil {
+		return nil, err
+	}
+
+	idb := binary.LittleEndian.AppendUint16(nil, uint16(LinkTypeRaw))
+	idb = binary.LittleEndian.AppendUint16(idb, 0)
+	idb = binary.LittleEndian.AppendUint32(idb, 0)
+	idb = appendOpt(idb, optTSResol, []byte{6})
+	idb = appendOpt(idb, optEnd, nil)
+	if err := nw.block(blockIDB, idb); err != nil {
+		return nil, err
+	}
+	return nw, nil
+}
+
+// WritePacket writes an enhanced packet block with an optional comment.
+func (nw *NgWriter) WritePacket(ts time.Time, data []byte, comment string) error {
+	us := uint64(ts.UnixMicro())
+	epb := binary.LittleEndian.AppendUint32(nw.buf[:0], 0)
+	epb = binary.LittleEndian.AppendUint32(epb, uint32(us>>32))
+	epb = binary.LittleEndian.AppendUint32(epb, uint32(us))
+	epb = binary.LittleEndian.AppendUint32(epb, uint32(len(data)))
+	epb = binary.LittleEndian.AppendUint32(epb, uint32(len(data)))
+	epb = append(epb, data...)
+	epb = pad(epb)
+	if comment != "" {
+		epb = appendOpt(epb, optComment, []byte(comment))
+		epb = appendOpt(epb, optEnd, nil)
+	}
+	nw.buf = epb
+	return nw.block(blockEPB, epb)
+}
+
+func (nw *NgWriter) block(typ uint32, body []byte) error {
+	l := uint32(12 + len(body))
+	var hdr [8]byte
+	binary.LittleEndian.PutUint32(hdr[0:], typ)
+	binary.LittleEndian.PutUint32(hdr[4:], l)
+	if _, err := nw.w.Write(hdr[:]); err != nil {
+		return err
+	}
+	if _, err := nw.w.Write(body); err != nil {
+		return err
+	}
+	_, err := nw.w.Write(hdr[4:8])
+	return err
+}
+
+func appendOpt(b []byte, code uint16, v []byte) []byte {
+	b = binary.LittleEndian.AppendUint16(b, code)
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(v)))
+	return pad(append(b, v...))
+}
+
+func pad(b []byte) []byte {
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// FrameBuilder rebuilds IP packets from stored HEP records. TCP sequence
+// and acknowledgement numbers are kept per flow so that dissectors can
+// follow the stream.
+type FrameBuilder struct {
+	seq map[flowKey]uint32
+	id  uint16
+}
+
+func NewFrameBuilder() *FrameBuilder {
+	return &FrameBuilder{seq: make(map[flowKey]uint32)}
+}
+
+// Frame returns a raw IPv4 or IPv6 packet with a UDP or TCP header in front
+// of the payload.
+func (b *FrameBuilder) Frame(h *decoder.HEP) ([]byte, error) {
+	src, dst := net.ParseIP(h.SrcIP), net.ParseIP(h.DstIP)
+	if src == nil || dst == nil {
+		return nil, fmt.Errorf("invalid address %q -> %q", h.SrcIP, h.DstIP)
+	}
+	src4, dst4 := src.To4(), dst.To4()
+	if (src4 == nil) != (dst4 == nil) {
+		return nil, fmt.Errorf("mixed address family %s -> %s", h.SrcIP, h.DstIP)
+	}
+
+	var l4 []byte
+	proto := uint8(protoUDP)
+	sport, dport := uint16(h.SrcPort), uint16(h.DstPort)
+	if h.Protocol == protoTCP {
+		proto = protoTCP
+		key := flowKey{h.SrcIP, h.DstIP, sport, dport}
+		rev := flowKey{h.DstIP, h.SrcIP, dport, sport}
+		seq, ok := b.seq[key]
+		if !ok {
+			seq = 1
+		}
+		ack, ok := b.seq[rev]
+		if !ok {
+			ack = 1
+		}
+		b.seq[key] = seq + uint32(len(h.Payload))
+
+		l4 = make([]byte, 20, 20+len(h.Payload))
+		binary.BigEndian.PutUint16(l4[0:], sport)
+		binary.BigEndian.PutUint16(l4[2:], dport)
+		binary.BigEndian.PutUint32(l4[4:], seq)
+		binary.BigEndian.PutUint32(l4[8:], ack)
+		l4[12] = 5 << 4
+		l4[13] = 0x18 // PSH, ACK
+		binary.BigEndian.PutUint16(l4[14:], 65535)
+	} else {
+		l4 = make([]byte, 8, 8+len(h.Payload))
+		binary.BigEndian.PutUint16(l4[0:], sport)
+		binary.BigEndian.PutUint16(l4[2:], dport)
+		binary.BigEndian.PutUint16(l4[4:], uint16(8+len(h.Payload)))
+	}
+	l4 = append(l4, h.Payload...)
+	if len(l4) > 65535-40 {
+		return nil, fmt.Errorf("payload of %d bytes is too big", len(h.Payload))
+	}
+
+	var ip []byte
+	var sum uint32
+	if src4 != nil {
+		b.id++
+		ip = make([]byte, 20, 20+len(l4))
+		ip[0] = 0x45
+		binary.BigEndian.PutUint16(ip[2:], uint16(20+len(l4)))
+		binary.BigEndian.PutUint16(ip[4:], b.id)
+		ip[8] = 64
+		ip[9] = proto
+		copy(ip[12:], src4)
+		copy(ip[16:], dst4)
+		binary.BigEndian.PutUint16(ip[10:], ^fold(checksum(0, ip)))
+		sum = checksum(checksum(0, src4), dst4)
+	} else {
+		ip = make([]byte, 40, 40+len(l4))
+		ip[0] = 0x60
+		binary.BigEndian.PutUint16(ip[4:], uint16(len(l4)))
+		ip[6] = proto
+		ip[7] = 64
+		copy(ip[8:], src.To16())
+		copy(ip[24:], dst.To16())
+		sum = checksum(checksum(0, src.To16()), dst.To16())
+	}
+
+	sum += uint32(proto) + uint32(len(l4))
+	csum := ^fold(checksum(sum, l4))
+	if proto == protoUDP && csum == 0 {
+		csum = 0xffff
+	}
+	if proto == protoTCP {
+		binary.BigEndian.PutUint16(l4[16:], csum)
+	} else {
+		binary.BigEndian.PutUint16(l4[6:], csum)
+	}
+	return append(ip, l4...), nil
+}
+
+func checksum(sum uint32, b []byte) uint32 {
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	return sum
+}
+
+func fold(sum uint32) uint16 {
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return uint16(sum)
+}
+
+// WriteNg writes the HEP records as pcapng. Every packet carries a comment
+// with the capture node. Records which can't be rebuilt are skipped.
+func WriteNg(w io.Writer, pkts []*decoder.HEP) error {
+	nw, err := NewNgWriter(w)
+	if err != nil {
+		return err
+	}
+	fb := NewFrameBuilder()
+	for _, h := range pkts {
+		frame, err := fb.Frame(h)
+		if err != nil {
+			continue
+		}
+		node := h.NodeName
+		if node == "" {
+			node = strconv.FormatUint(uint64(h.NodeID), 10)
+		}
+		if err := nw.WritePacket(h.Timestamp, frame, "node: "+node); err != nil {
+			return err
+		}
+	}
+	return nil
+}
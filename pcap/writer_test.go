@@ -0,0 +1,70 @@
+package pcap
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/sipcapture/heplify-server/decoder"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteNg(t *testing.T) {
+	ts := time.Date(2020, 1, 2, 3, 4, 5, 6000, time.UTC)
+	pkts := []*decoder.HEP{
+		{Protocol: 17, SrcIP: "10.0.0.1", DstIP: "10.0.0.2", SrcPort: 5060, DstPort: 5060, NodeName: "sbc01", Payload: sipInvite, Timestamp: ts},
+		{Protocol: 6, SrcIP: "2001:db8::1", DstIP: "2001:db8::2", SrcPort: 5060, DstPort: 5061, NodeID: 2001, Payload: sipOK, Timestamp: ts.Add(time.Second)},
+		{Protocol: 6, SrcIP: "2001:db8::1", DstIP: "2001:db8::2", SrcPort: 5060, DstPort: 5061, NodeID: 2001, Payload: sipOK, Timestamp: ts.Add(2 * time.Second)},
+		{Protocol: 17, SrcIP: "10.0.0.1", DstIP: "2001:db8::2", Payload: sipOK, Timestamp: ts},
+	}
+
+	var buf bytes.Buffer
+	assert.NoError(t, WriteNg(&buf, pkts))
+	assert.Contains(t, buf.String(), "node: sbc01")
+	assert.Contains(t, buf.String(), "node: 2001")
+
+	r, err := NewReader(&buf)
+	if !assert.NoError(t, err) {
+		return
+	}
+	d := NewDecoder()
+	var got []*decoder.HEP
+	for {
+		p, err := r.ReadPacket()
+		if err == io.EOF {
+			break
+		}
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, LinkTypeRaw, p.LinkType)
+		got = append(got, d.Decode(p)...)
+	}
+
+	// the mixed address family record is skipped
+	if assert.Len(t, got, 3) {
+		for i, h := range got {
+			assert.Equal(t, pkts[i].Payload, h.Payload)
+			assert.Equal(t, pkts[i].Protocol, h.Protocol)
+			assert.Equal(t, pkts[i].SrcIP, h.SrcIP)
+			assert.Equal(t, pkts[i].DstIP, h.DstIP)
+			assert.Equal(t, pkts[i].SrcPort, h.SrcPort)
+			assert.Equal(t, pkts[i].DstPort, h.DstPort)
+			assert.True(t, pkts[i].Timestamp.Equal(h.Timestamp))
+		}
+	}
+}
+
+func TestFrameChecksum(t *testing.T) {
+	fb := NewFrameBuilder()
+	frame, err := fb.Frame(&decoder.HEP{Protocol: 17, SrcIP: "192.168.1.1", DstIP: "192.168.1.2", SrcPort: 1, DstPort: 2, Payload: "odd"})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, uint16(0xffff), fold(checksum(0, frame[:20])))
+
+	// pseudo header plus the UDP segment sums up to all ones
+	sum := checksum(checksum(0, frame[12:16]), frame[16:20]) + 17 + uint32(len(frame)-20)
+	assert.Equal(t, uint16(0xffff), fold(checksum(sum, frame[20:])))
+}
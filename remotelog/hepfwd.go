@@ -0,0 +1,226 @@
+package remotelog
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/negbie/logp"
+	"github.com/sipcapture/heplify-server/config"
+	"github.com/sipcapture/heplify-server/decoder"
+)
+
+const (
+	hepfwdDialTimeout  = 5 * time.Second
+	hepfwdWriteTimeout = 5 * time.Second
+	hepfwdMinBackoff   = 500 * time.Millisecond
+	hepfwdMaxBackoff   = 30 * time.Second
+)
+
+// HEPForward re-encodes decoded packets as HEPv3 and sends them to one or
+// more upstream collectors. Upstreams are configured as URLs:
+//
+//	udp://10.0.0.1:9060
+//	tcp://10.0.0.2:9061?filter=1,5,100
+//	tls://collector.example.com:9062?filter=1&insecure=true
+type HEPForward struct {
+	upstreams []*hepUpstream
+}
+
+type hepUpstream struct {
+	network  string
+	addr     string
+	tlsConf  *tls.Config
+	filter   []uint32
+	queue    chan []byte
+	stop     chan struct{}
+	conn     net.Conn
+	backoff  time.Duration
+	lastWarn time.Time
+}
+
+func (f *HEPForward) setup() error {
+	f.upstreams = nil
+	for _, v := range config.Setting.HEPForwardAddr {
+		u, err := parseUpstream(v)
+		if err != nil {
+			return err
+		}
+		u.queue = make(chan []byte, max(config.Setting.HEPForwardBuffer, 1))
+		u.stop = make(chan struct{})
+		f.upstreams = append(f.upstreams, u)
+	}
+	if len(f.upstreams) == 0 {
+		return fmt.Errorf("no valid HEPForwardAddr configured")
+	}
+
+	for _, u := range f.upstreams {
+		go u.run()
+	}
+	return nil
+}
+
+func parseUpstream(s string) (*hepUpstream, error) {
+	u, err := url.Parse(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("invalid HEPForwardAddr %q: %v", s, err)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid HEPForwardAddr %q: missing host:port", s)
+	}
+
+	up := &hepUpstream{
+		network: u.Scheme,
+		addr:    u.Host,
+		backoff: hepfwdMinBackoff,
+	}
+
+	switch u.Scheme {
+	case "udp", "tcp":
+	case "tls":
+		up.network = "tcp"
+		host, _, _ := net.SplitHostPort(u.Host)
+		up.tlsConf = &tls.Config{
+			ServerName:         host,
+			InsecureSkipVerify: u.Query().Get("insecure") == "true",
+		}
+	default:
+		return nil, fmt.Errorf("invalid HEPForwardAddr %q: scheme must be udp, tcp or tls", s)
+	}
+
+	if fv := u.Query().Get("filter"); fv != "" {
+		for _, p := range strings.Split(fv, ",") {
+			t, err := strconv.ParseUint(strings.TrimSpace(p), 10, 8)
+			if err != nil {
+				return nil, fmt.Errorf("invalid filter in HEPForwardAddr %q: %v", s, err)
+			}
+			up.filter = append(up.filter, uint32(t))
+		}
+	}
+	return up, nil
+}
+
+func (f *HEPForward) start(hCh chan *decoder.HEP) {
+	for pkt := range hCh {
+		var msg []byte
+		for _, u := range f.upstreams {
+			if !u.match(pkt.ProtoType) {
+				continue
+			}
+			if msg == nil {
+				var err error
+				if msg, err = pkt.MarshalHEP3(); err != nil {
+					logp.Debug("hepfwd", "skip packet from nodeID %d: %v", pkt.NodeID, err)
+					break
+				}
+			}
+			select {
+			case u.queue <- msg:
+			default:
+				if time.Since(u.lastWarn) > 1e9 {
+					logp.Warn("overflowing hep forward queue for %s", u.addr)
+				}
+				u.lastWarn = time.Now()
+			}
+		}
+	}
+
+	for _, u := range f.upstreams {
+		close(u.queue)
+		close(u.stop)
+	}
+}
+
+func (u *hepUpstream) match(protoType uint32) bool {
+	if len(u.filter) == 0 {
+		return true
+	}
+	for _, v := range u.filter {
+		if v == protoType {
+			return true
+		}
+	}
+	return false
+}
+
+func (u *hepUpstream) run() {
+	for msg := range u.queue {
+		if !u.deliver(msg) {
+			logp.Warn("hep forward to %s is down on shutdown, drop %d queued packets", u.addr, len(u.queue)+1)
+			break
+		}
+	}
+	if u.conn != nil {
+		u.conn.Close()
+	}
+}
+
+// deliver sends msg. While the upstream is unreachable it keeps the message
+// and retries after the backoff, meanwhile the queue buffers the following
+// packets. A message which fails on a fresh connection is dropped, so one
+// the upstream doesn't take can't block the queue. It returns false if the
+// forwarder stops while the upstream is down.
+func (u *hepUpstream) deliver(msg []byte) bool {
+	for {
+		fresh := false
+		if u.conn == nil {
+			if !u.connect() {
+				select {
+				case <-u.stop:
+					return false
+				default:
+				}
+				continue
+			}
+			fresh = true
+		}
+		err := u.send(msg)
+		if err == nil {
+			return true
+		}
+		logp.Warn("hep forward to %s failed: %v", u.addr, err)
+		u.conn.Close()
+		u.conn = nil
+		if fresh {
+			return true
+		}
+	}
+}
+
+func (u *hepUpstream) connect() bool {
+	var (
+		conn net.Conn
+		err  error
+	)
+	d := &net.Dialer{Timeout: hepfwdDialTimeout}
+	if u.tlsConf != nil {
+		conn, err = tls.DialWithDialer(d, u.network, u.addr, u.tlsConf)
+	} else {
+		conn, err = d.Dial(u.network, u.addr)
+	}
+	if err != nil {
+		logp.Err("hep forward couldn't connect to %s, retry in %v: %v", u.addr, u.backoff, err)
+		select {
+		case <-time.After(u.backoff):
+		case <-u.stop:
+		}
+		u.backoff = min(u.backoff*2, hepfwdMaxBackoff)
+		return false
+	}
+	logp.Info("hep forward connected to %s://%s", u.network, u.addr)
+	u.conn = conn
+	u.backoff = hepfwdMinBackoff
+	return true
+}
+
+func (u *hepUpstream) send(msg []byte) error {
+	if u.network != "udp" {
+		u.conn.SetWriteDeadline(time.Now().Add(hepfwdWriteTimeout))
+	}
+	_, err := u.conn.Write(msg)
+	return err
+}
@@ -0,0 +1,157 @@
+package remotelog
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sipcapture/heplify-server/config"
+	"github.com/sipcapture/heplify-server/decoder"
+)
+
+func TestParseUpstream(t *testing.T) {
+	u, err := parseUpstream("tls://collector.example.com:9062?filter=1,5&insecure=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.network != "tcp" || u.addr != "collector.example.com:9062" || u.tlsConf == nil {
+		t.Errorf("unexpected upstream %+v", u)
+	}
+	if !u.tlsConf.InsecureSkipVerify || u.tlsConf.ServerName != "collector.example.com" {
+		t.Errorf("unexpected tls config %+v", u.tlsConf)
+	}
+	if !u.match(5) || u.match(100) {
+		t.Errorf("unexpected filter %v", u.filter)
+	}
+
+	u, err = parseUpstream("udp://127.0.0.1:9060")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !u.match(100) {
+		t.Errorf("upstream without filter should match everything")
+	}
+
+	for _, s := range []string{"http://127.0.0.1:9060", "127.0.0.1:9060", "tcp://127.0.0.1:9060?filter=sip"} {
+		if _, err := parseUpstream(s); err == nil {
+			t.Errorf("expected error for %q", s)
+		}
+	}
+}
+
+func TestHEPForwardTCP(t *testing.T) {
+	withConfig(t, func() {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ln.Close()
+
+		config.Setting.HEPForwardAddr = []string{"tcp://" + ln.Addr().String() + "?filter=1"}
+		config.Setting.HEPForwardBuffer = 10
+
+		f := &HEPForward{}
+		if err := f.setup(); err != nil {
+			t.Fatal(err)
+		}
+		hCh := make(chan *decoder.HEP, 2)
+		go f.start(hCh)
+
+		ts := time.Unix(1600000000, 123456000)
+		hCh <- &decoder.HEP{Version: 2, Protocol: 17, SrcIP: "10.0.0.1", DstIP: "10.0.0.2", SrcPort: 5060, DstPort: 5080,
+			Timestamp: ts, ProtoType: 100, NodeID: 7, Payload: "filtered"}
+		hCh <- &decoder.HEP{Version: 2, Protocol: 17, SrcIP: "10.0.0.1", DstIP: "10.0.0.2", SrcPort: 5060, DstPort: 5080,
+			Timestamp: ts, ProtoType: 1, NodeID: 7, CID: "abc", Payload: "forwarded"}
+		close(hCh)
+
+		conn, err := ln.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+		hdr := make([]byte, 6)
+		if _, err := io.ReadFull(conn, hdr); err != nil {
+			t.Fatal(err)
+		}
+		msg := make([]byte, binary.BigEndian.Uint16(hdr[4:6]))
+		copy(msg, hdr)
+		if _, err := io.ReadFull(conn, msg[6:]); err != nil {
+			t.Fatal(err)
+		}
+
+		h, err := decoder.DecodeHEP(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h.Payload != "forwarded" || h.CID != "abc" || h.NodeID != 7 || h.SrcIP != "10.0.0.1" || h.DstPort != 5080 {
+			t.Errorf("unexpected packet %+v", h)
+		}
+		if !h.Timestamp.Equal(ts) {
+			t.Errorf("timestamp is %v, want %v", h.Timestamp, ts)
+		}
+	})
+}
+
+func TestHEPForwardOutage(t *testing.T) {
+	withConfig(t, func() {
+		// reserve a port and close it, the upstream comes up later
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr := ln.Addr().String()
+		ln.Close()
+
+		config.Setting.HEPForwardAddr = []string{"tcp://" + addr}
+		config.Setting.HEPForwardBuffer = 10
+
+		f := &HEPForward{}
+		if err := f.setup(); err != nil {
+			t.Fatal(err)
+		}
+		hCh := make(chan *decoder.HEP, 3)
+		go f.start(hCh)
+		defer close(hCh)
+
+		for _, cid := range []string{"a", "b", "c"} {
+			hCh <- &decoder.HEP{Version: 2, Protocol: 17, SrcIP: "10.0.0.1", DstIP: "10.0.0.2",
+				Timestamp: time.Now(), ProtoType: 1, CID: cid, Payload: "queued"}
+		}
+		time.Sleep(200 * time.Millisecond)
+
+		ln, err = net.Listen("tcp", addr)
+		if err != nil {
+			t.Skipf("can't listen on %s again: %v", addr, err)
+		}
+		defer ln.Close()
+		conn, err := ln.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+
+		for _, want := range []string{"a", "b", "c"} {
+			hdr := make([]byte, 6)
+			if _, err := io.ReadFull(conn, hdr); err != nil {
+				t.Fatal(err)
+			}
+			msg := make([]byte, binary.BigEndian.Uint16(hdr[4:6]))
+			copy(msg, hdr)
+			if _, err := io.ReadFull(conn, msg[6:]); err != nil {
+				t.Fatal(err)
+			}
+			h, err := decoder.DecodeHEP(msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if h.CID != want {
+				t.Errorf("got packet %q, want %q", h.CID, want)
+			}
+		}
+	})
+}
@@ -166,6 +166,17 @@ func (l *Lineproto) createEntry(pkt *decoder.HEP, timestamp time.Time, payload s
 	entry.fields["payload"] = payload
 	entry.fields["payload_size"] = len(payload)
 
+	// CDRs get their own measurement with the call outcome as tags
+	if cdr := pkt.CDR; cdr != nil {
+		entry.measurement = "hep_1_cdr"
+		entry.tags["status"] = cdr.Status
+		entry.tags["disconnect"] = cdr.Disconnect
+		entry.fields["call_id"] = cdr.CallID
+		entry.fields["final_response"] = cdr.FinalResponse
+		entry.fields["pdd"] = cdr.PDD
+		entry.fields["duration"] = cdr.Duration
+	}
+
 	// Add SIP-specific fields when available
 	if pkt.SIP != nil && pkt.ProtoType == 1 {
 		if pkt.SIP.CseqMethod != "" {
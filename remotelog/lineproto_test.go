@@ -73,6 +73,40 @@ func TestLineprotoCreateEntry(t *testing.T) {
 	}
 }
 
+func TestLineprotoCreateEntryCDR(t *testing.T) {
+	hep := &decoder.HEP{
+		SrcIP:     "192.168.1.1",
+		DstIP:     "192.168.1.2",
+		ProtoType: 1,
+		Timestamp: time.Unix(1618426800, 0),
+		Payload:   `{"call_id":"a84b4c76e66710@example.com"}`,
+		CDR: &decoder.CDR{
+			CallID:        "a84b4c76e66710@example.com",
+			Status:        decoder.CDRAnswered,
+			Disconnect:    "caller",
+			FinalResponse: 200,
+			PDD:           1.5,
+			Duration:      60,
+		},
+	}
+
+	lp := &Lineproto{}
+	entry := lp.createEntry(hep, hep.Timestamp, hep.Payload, "test-host")
+
+	if entry.measurement != "hep_1_cdr" {
+		t.Errorf("Expected measurement hep_1_cdr, got %s", entry.measurement)
+	}
+	if entry.tags["status"] != "answered" || entry.tags["disconnect"] != "caller" {
+		t.Errorf("Unexpected CDR tags %v", entry.tags)
+	}
+	if entry.fields["final_response"] != 200 || entry.fields["duration"] != 60.0 || entry.fields["pdd"] != 1.5 {
+		t.Errorf("Unexpected CDR fields %v", entry.fields)
+	}
+	if entry.fields["call_id"] != "a84b4c76e66710@example.com" {
+		t.Errorf("Expected call_id %s, got %s", "a84b4c76e66710@example.com", entry.fields["call_id"])
+	}
+}
+
 func TestLineprotoEncodeBatch(t *testing.T) {
 	// Create test entries
 	entries := []LineprotoEntry{
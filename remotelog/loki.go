@@ -157,6 +157,13 @@ func (l *Loki) start(hCh chan *decoder.HEP) {
 			}
 
 			switch {
+			case pkt.CDR != nil:
+				l.entry.labels["status"] = model.LabelValue(pkt.CDR.Status)
+				l.entry.labels["disconnect"] = model.LabelValue(pkt.CDR.Disconnect)
+				l.entry.labels["response"] = model.LabelValue(strconv.Itoa(pkt.CDR.FinalResponse))
+				if config.Setting.LokiCallIDLabels {
+					l.entry.labels["call_id"] = model.LabelValue(pkt.CDR.CallID)
+				}
 			case pkt.SIP != nil && pkt.ProtoType == 1:
 				l.entry.labels["method"] = model.LabelValue(pkt.SIP.CseqMethod)
 				l.entry.labels["response"] = model.LabelValue(pkt.SIP.FirstMethod)
@@ -198,6 +205,16 @@ func (l *Loki) start(hCh chan *decoder.HEP) {
 				l.entry.labels["dst_port"] = model.LabelValue(strconv.FormatUint(uint64(pkt.DstPort), 10))
 			}
 
+			for _, k := range config.Setting.EnrichLokiLabels {
+				name := model.LabelName(k)
+				if _, ok := l.entry.labels[name]; ok {
+					continue
+				}
+				if v := pkt.Enrichment(k); v != "" {
+					l.entry.labels[name] = model.LabelValue(v)
+				}
+			}
+
 			for k, v := range pkt.CustomLokiLabels {
 				l.entry.labels[model.LabelName(k)] = model.LabelValue(v)
 			}
@@ -20,6 +20,7 @@ func New(name string) *Remotelog {
 		"elasticsearch": new(Elasticsearch),
 		"loki":          new(Loki),
 		"lineproto":     new(Lineproto),
+		"hepfwd":        new(HEPForward),
 	}
 
 	return &Remotelog{
@@ -30,7 +31,7 @@ func New(name string) *Remotelog {
 func (r *Remotelog) Run() error {
 	err := r.H.setup()
 	if err != nil {
-		logp.Err("loki couldn't establish connection on start... anyway continue")
+		logp.Err("%v, remotelog couldn't establish connection on start... anyway continue", err)
 	}
 
 	go func() {
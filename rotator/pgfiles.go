@@ -8,6 +8,7 @@ var (
 	selectcallpg     = "SELECT tablename FROM pg_tables WHERE tablename LIKE 'hep_proto_1_call_%' and tablename < 'hep_proto_1_call_{{date}}_{{time}}';"
 	selectregisterpg = "SELECT tablename FROM pg_tables WHERE tablename LIKE 'hep_proto_1_registration_%' and tablename < 'hep_proto_1_registration_{{date}}_{{time}}';"
 	selectdefaultpg  = "SELECT tablename FROM pg_tables WHERE tablename LIKE 'hep_proto_1_default_%' and tablename < 'hep_proto_1_default_{{date}}_{{time}}';"
+	selectcdrpg      = "SELECT tablename FROM pg_tables WHERE tablename LIKE 'hep_proto_1_cdr_%' and tablename < 'hep_proto_1_cdr_{{date}}_{{time}}';"
 )
 
 var (
@@ -21,6 +22,7 @@ var (
 	dropcallpg     = "DROP TABLE IF EXISTS {{partName}};"
 	dropregisterpg = "DROP TABLE IF EXISTS {{partName}};"
 	dropdefaultpg  = "DROP TABLE IF EXISTS {{partName}};"
+	dropcdrpg      = "DROP TABLE IF EXISTS {{partName}};"
 )
 
 var idxlogpg = []string{
@@ -103,6 +105,11 @@ var idxsippg = []string{
 	"CREATE INDEX IF NOT EXISTS hep_proto_1_default_{{date}}_{{time}}_auth_user ON hep_proto_1_default_{{date}}_{{time}} ((data_header->>'auth_user'));",
 	"CREATE INDEX IF NOT EXISTS hep_proto_1_default_{{date}}_{{time}}_callid ON hep_proto_1_default_{{date}}_{{time}} ((data_header->>'callid'));",
 	"CREATE INDEX IF NOT EXISTS hep_proto_1_default_{{date}}_{{time}}_method ON hep_proto_1_default_{{date}}_{{time}} ((data_header->>'method'));",
+	"CREATE INDEX IF NOT EXISTS hep_proto_1_cdr_{{date}}_{{time}}_create_date ON hep_proto_1_cdr_{{date}}_{{time}} (create_date);",
+	"CREATE INDEX IF NOT EXISTS hep_proto_1_cdr_{{date}}_{{time}}_sid ON hep_proto_1_cdr_{{date}}_{{time}} (sid);",
+	"CREATE INDEX IF NOT EXISTS hep_proto_1_cdr_{{date}}_{{time}}_from_user ON hep_proto_1_cdr_{{date}}_{{time}} ((data_header->>'from_user'));",
+	"CREATE INDEX IF NOT EXISTS hep_proto_1_cdr_{{date}}_{{time}}_to_user ON hep_proto_1_cdr_{{date}}_{{time}} ((data_header->>'to_user'));",
+	"CREATE INDEX IF NOT EXISTS hep_proto_1_cdr_{{date}}_{{time}}_status ON hep_proto_1_cdr_{{date}}_{{time}} ((data_header->>'status'));",
 }
 
 var parlogpg = []string{
@@ -122,6 +129,7 @@ var parsippg = []string{
 	"CREATE TABLE IF NOT EXISTS hep_proto_1_call_{{date}}_{{time}} PARTITION OF hep_proto_1_call FOR VALUES FROM ('{{startTime}}') TO ('{{endTime}}');",
 	"CREATE TABLE IF NOT EXISTS hep_proto_1_registration_{{date}}_{{time}} PARTITION OF hep_proto_1_registration FOR VALUES FROM ('{{startTime}}') TO ('{{endTime}}');",
 	"CREATE TABLE IF NOT EXISTS hep_proto_1_default_{{date}}_{{time}} PARTITION OF hep_proto_1_default FOR VALUES FROM ('{{startTime}}') TO ('{{endTime}}');",
+	"CREATE TABLE IF NOT EXISTS hep_proto_1_cdr_{{date}}_{{time}} PARTITION OF hep_proto_1_cdr FOR VALUES FROM ('{{startTime}}') TO ('{{endTime}}');",
 }
 
 var tbldatapg = []string{
@@ -179,6 +187,15 @@ var tbldatapg = []string{
 		raw varchar NOT NULL
 	) PARTITION BY RANGE (create_date);`,
 
+	`CREATE TABLE IF NOT EXISTS hep_proto_1_cdr (
+		id BIGSERIAL NOT NULL,
+		sid varchar NOT NULL,
+		create_date timestamp with time zone DEFAULT CURRENT_TIMESTAMP NOT NULL,
+		protocol_header jsonb NOT NULL,
+		data_header jsonb NOT NULL,
+		raw varchar NOT NULL
+	) PARTITION BY RANGE (create_date);`,
+
 	`CREATE TABLE IF NOT EXISTS hep_proto_54_default (
 		id BIGSERIAL NOT NULL,
 		sid varchar NOT NULL,
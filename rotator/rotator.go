@@ -275,6 +275,7 @@ func (r *Rotator) UsageProtection(scheme string) error {
 		r.dbExecDropTablesForFreeSpace(db, selectcallpg, dropcallpg, r.dropLimit)
 		r.dbExecDropTablesForFreeSpace(db, selectregisterpg, dropregisterpg, r.dropDaysRegister)
 		r.dbExecDropTablesForFreeSpace(db, selectdefaultpg, dropdefaultpg, r.dropDaysDefault)
+		r.dbExecDropTablesForFreeSpace(db, selectcdrpg, dropcdrpg, r.dropDays)
 		curSize, err = r.GetDatabaseSize(db, scheme)
 		if err != nil {
 			logp.Err("%v", err)
@@ -322,6 +323,7 @@ func (r *Rotator) DropTables() (err error) {
 		r.dbExecDropTables(db, selectcallpg, dropcallpg, r.dropDaysCall)
 		r.dbExecDropTables(db, selectregisterpg, dropregisterpg, r.dropDaysRegister)
 		r.dbExecDropTables(db, selectdefaultpg, dropdefaultpg, r.dropDaysDefault)
+		r.dbExecDropTables(db, selectcdrpg, dropcdrpg, r.dropDays)
 	}
 	return nil
 }
@@ -1,3 +1,6 @@
+-- metrics are declared once when the scripts are loaded
+-- DefineCounter("sip_requests_by_method_total", "SIP requests by method", "method")
+
 
 -- this function will be executed first
 function checkRAW()
@@ -47,6 +50,42 @@ function checkSIP()
 
 	SetSIPHeader("FromHost", "1.1.1.1")
 
+	if sip.Method ~= "" then
+		-- IncMetric("sip_requests_by_method_total", sip.Method)
+	end
+
+	-- all Via values, also from compact "v:" headers
+	local vias = GetSIPHeaders("Via")
+	if #vias > 1 then
+		-- Logp("DEBUG", "first hop", vias[1])
+	end
+
+	if GetSIPURIParam("ruri", "transport") == "tls" then
+		-- Logp("DEBUG", "tls request to", GetSIPHeader("To"))
+	end
+
+	-- keep keepalives out of all outputs and OPTIONS out of the database
+	if sip.Method == "NOTIFY" and GetSIPHeader("Event") == "keep-alive" then
+		-- Drop()
+		-- return
+	end
+	if sip.CseqMethod == "OPTIONS" then
+		-- SkipOutput("db")
+	end
+
+	-- REGISTER failures per user within five minutes
+	if sip.CseqMethod == "REGISTER" and sip.FirstResp == "401" then
+		if StateIncr("regfail", sip.FromUser, 1, 300) > 10 then
+			-- SetCustomSIPHeader("reg_abuse", "true")
+		end
+	end
+
+	-- the parsed SDP body, empty if there is none
+	local sdp = GetSDPStruct()
+	if (sdp ~= nil and sdp ~= '') then
+		-- Logp("DEBUG", "codecs", sdp:Codecs())
+	end
+
 	return 
 
 end
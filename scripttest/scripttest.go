@@ -0,0 +1,206 @@
+// Package scripttest runs the scripts of ScriptFolder against fixture
+// packets and reports what they changed.
+package scripttest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/sipcapture/heplify-server/config"
+	"github.com/sipcapture/heplify-server/decoder"
+	"github.com/sipcapture/heplify-server/pcap"
+)
+
+// ExpectedSuffix is appended to a fixture name for its expected report.
+const ExpectedSuffix = ".expected"
+
+// Load reads the packets of a fixture file. Files ending in .pcap or
+// .pcapng are captures, .json files hold one HEP object or a list of them
+// and anything else is a single SIP message. The packets are encoded as
+// HEPv3 and decoded again like the server does.
+func Load(name string) ([]*decoder.HEP, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var pkts []*decoder.HEP
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".pcap", ".pcapng":
+		r, err := pcap.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		d := pcap.NewDecoder()
+		for {
+			p, err := r.ReadPacket()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+			pkts = append(pkts, d.Decode(p)...)
+		}
+	case ".json":
+		data = bytes.TrimSpace(data)
+		if bytes.HasPrefix(data, []byte("[")) {
+			err = json.Unmarshal(data, &pkts)
+		} else {
+			h := &decoder.HEP{}
+			err = json.Unmarshal(data, h)
+			pkts = append(pkts, h)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+	default:
+		pkts = append(pkts, sipPacket(string(data)))
+	}
+
+	for i, p := range pkts {
+		b, err := p.MarshalHEP3()
+		if err != nil {
+			return nil, fmt.Errorf("%s packet %d: %v", name, i+1, err)
+		}
+		if pkts[i], err = decoder.DecodeHEP(b); err != nil {
+			return nil, fmt.Errorf("%s packet %d: %v", name, i+1, err)
+		}
+	}
+	return pkts, nil
+}
+
+// sipPacket wraps a SIP message from a text file. Lines may end in LF.
+func sipPacket(msg string) *decoder.HEP {
+	msg = strings.ReplaceAll(strings.ReplaceAll(msg, "\r\n", "\n"), "\n", "\r\n")
+	if !strings.Contains(msg, "\r\n\r\n") {
+		msg = strings.TrimRight(msg, "\r\n") + "\r\n\r\n"
+	}
+	return &decoder.HEP{
+		Version:   2,
+		Protocol:  17,
+		SrcIP:     "127.0.0.1",
+		DstIP:     "127.0.0.2",
+		SrcPort:   5060,
+		DstPort:   5060,
+		Tsec:      1,
+		ProtoType: 1,
+		Payload:   msg,
+	}
+}
+
+// Snapshot returns the fields a script can change.
+func Snapshot(h *decoder.HEP) map[string]string {
+	s := map[string]string{
+		"ProtoType":  strconv.FormatUint(uint64(h.ProtoType), 10),
+		"SrcIP":      h.SrcIP,
+		"SrcPort":    strconv.FormatUint(uint64(h.SrcPort), 10),
+		"DstIP":      h.DstIP,
+		"DstPort":    strconv.FormatUint(uint64(h.DstPort), 10),
+		"NodeID":     strconv.FormatUint(uint64(h.NodeID), 10),
+		"NodeName":   h.NodeName,
+		"TargetName": h.TargetName,
+		"CID":        h.CID,
+		"SID":        h.SID,
+		"Payload":    h.Payload,
+		"Drop":       strconv.FormatBool(h.Dropped),
+		"Outputs":    (decoder.OutputAll &^ h.SkipOutputs).String(),
+	}
+	for k, v := range h.CustomLokiLabels {
+		s["LokiLabel."+k] = v
+	}
+	if h.SIP == nil {
+		return s
+	}
+	v := reflect.ValueOf(h.SIP).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		if f.IsExported() && f.Type.Kind() == reflect.String && f.Name != "Msg" {
+			s["SIP."+f.Name] = v.Field(i).String()
+		}
+	}
+	for k, v := range h.SIP.CustomHeader {
+		s["SIP.CustomHeader."+k] = v
+	}
+	return s
+}
+
+// Diff returns the changed fields, one per line, sorted by name.
+func Diff(before, after map[string]string) []string {
+	var lines []string
+	for _, k := range slices.Sorted(maps.Keys(after)) {
+		old, ok := before[k]
+		switch {
+		case !ok:
+			lines = append(lines, fmt.Sprintf("+ %s: %q", k, after[k]))
+		case old != after[k]:
+			lines = append(lines, fmt.Sprintf("~ %s: %q -> %q", k, old, after[k]))
+		}
+	}
+	for _, k := range slices.Sorted(maps.Keys(before)) {
+		if _, ok := after[k]; !ok {
+			lines = append(lines, fmt.Sprintf("- %s: %q", k, before[k]))
+		}
+	}
+	return lines
+}
+
+// Run feeds the packets to the script engine like a server worker and
+// returns the report of what the scripts changed. Packets with a
+// ProtoType outside ScriptHEPFilter are not passed to the scripts.
+func Run(script decoder.ScriptEngine, pkts []*decoder.HEP) (string, error) {
+	var b strings.Builder
+	for i, h := range pkts {
+		fmt.Fprintf(&b, "# packet %d: %s\n", i+1, describe(h))
+		if !slices.Contains(config.Setting.ScriptHEPFilter, int(h.ProtoType)) {
+			fmt.Fprintf(&b, "skipped, ProtoType %d is not in ScriptHEPFilter\n", h.ProtoType)
+			continue
+		}
+		before := Snapshot(h)
+		if err := script.Run(h); err != nil {
+			return b.String(), fmt.Errorf("packet %d: %v", i+1, err)
+		}
+		diff := Diff(before, Snapshot(h))
+		if len(diff) == 0 {
+			b.WriteString("unchanged\n")
+		}
+		for _, l := range diff {
+			b.WriteString(l + "\n")
+		}
+	}
+	return b.String(), nil
+}
+
+func describe(h *decoder.HEP) string {
+	if h.SIP == nil {
+		return fmt.Sprintf("%s %s:%d -> %s:%d", h.ProtoString, h.SrcIP, h.SrcPort, h.DstIP, h.DstPort)
+	}
+	return fmt.Sprintf("%s %s", h.SIP.FirstMethod, h.SIP.CallID)
+}
+
+// Compare returns the lines of two reports which differ, prefixed with
+// - for the expected and + for the actual line.
+func Compare(want, got string) []string {
+	w := strings.Split(strings.TrimSuffix(want, "\n"), "\n")
+	g := strings.Split(strings.TrimSuffix(got, "\n"), "\n")
+	var lines []string
+	for i := 0; i < len(w) || i < len(g); i++ {
+		switch {
+		case i >= len(g):
+			lines = append(lines, "- "+w[i])
+		case i >= len(w):
+			lines = append(lines, "+ "+g[i])
+		case w[i] != g[i]:
+			lines = append(lines, "- "+w[i], "+ "+g[i])
+		}
+	}
+	return lines
+}
@@ -0,0 +1,69 @@
+package scripttest
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sipcapture/heplify-server/config"
+	"github.com/sipcapture/heplify-server/decoder"
+	"github.com/sipcapture/heplify-server/pcap"
+	"github.com/stretchr/testify/assert"
+)
+
+const testInvite = "INVITE sip:bob@example.com SIP/2.0\nVia: SIP/2.0/UDP 10.0.0.1;branch=z9hG4bK1\nFrom: <sip:alice@example.com>;tag=a\n" +
+	"To: <sip:bob@example.com>\nCall-ID: fixture-1@example.com\nCSeq: 1 INVITE\nContent-Length: 0\n\n"
+
+func TestScriptTest(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) string {
+		p := filepath.Join(dir, name)
+		assert.NoError(t, os.WriteFile(p, []byte(data), 0644))
+		return p
+	}
+	write("node.expr", `GetSIPHeader("Via") != "" ? SetHEPField("NodeName", "edge") : 0`)
+	write("drop.expr", `GetHEPSrcIP() == "10.1.1.1" ? Drop() : SkipOutput("es")`)
+	config.Setting.ScriptEngine = "expr"
+	config.Setting.ScriptFolder = dir
+	config.Setting.ScriptHEPFilter = []int{1}
+	script, err := decoder.NewScriptEngine()
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer script.Close()
+
+	pkts, err := Load(write("invite.sip", testInvite))
+	if assert.NoError(t, err) && assert.Len(t, pkts, 1) {
+		assert.Equal(t, "fixture-1@example.com", pkts[0].SIP.CallID)
+	}
+	report, err := Run(script, pkts)
+	assert.NoError(t, err)
+	assert.Equal(t, "# packet 1: INVITE fixture-1@example.com\n"+
+		"~ NodeName: \"0\" -> \"edge\"\n"+
+		"~ Outputs: \"db,es,forward,lineproto,loki,prom\" -> \"db,forward,lineproto,loki,prom\"\n", report)
+
+	pkts, err = Load(write("hep.json", `[{"SrcIP":"10.1.1.1","DstIP":"10.1.1.2","ProtoType":1,"NodeID":7,"Payload":"SIP/2.0 200 OK\r\nCall-ID: fixture-2@example.com\r\nCSeq: 1 INVITE\r\n\r\n"},`+
+		`{"SrcIP":"10.1.1.1","DstIP":"10.1.1.2","ProtoType":5,"Payload":"{}"}]`))
+	if assert.NoError(t, err) && assert.Len(t, pkts, 2) {
+		report, err = Run(script, pkts)
+		assert.NoError(t, err)
+		assert.Equal(t, "# packet 1: 200 fixture-2@example.com\n"+
+			"~ Drop: \"false\" -> \"true\"\n"+
+			"# packet 2: rtcp 10.1.1.1:0 -> 10.1.1.2:0\n"+
+			"skipped, ProtoType 5 is not in ScriptHEPFilter\n", report)
+	}
+
+	f, err := os.Create(filepath.Join(dir, "call.pcapng"))
+	assert.NoError(t, err)
+	sip := &decoder.HEP{Version: 2, Protocol: 17, SrcIP: "10.0.0.1", DstIP: "10.0.0.2", SrcPort: 5060, DstPort: 5060, Tsec: 1, ProtoType: 1, Payload: sipPacket(testInvite).Payload}
+	assert.NoError(t, pcap.WriteNg(f, []*decoder.HEP{sip}))
+	f.Close()
+	pkts, err = Load(f.Name())
+	if assert.NoError(t, err) && assert.Len(t, pkts, 1) {
+		assert.Equal(t, "10.0.0.1", pkts[0].SrcIP)
+		assert.Equal(t, "INVITE", pkts[0].SIP.FirstMethod)
+	}
+
+	assert.Empty(t, Compare(report, report))
+	assert.Equal(t, []string{"- b", "+ c", "+ d"}, Compare("a\nb\n", "a\nc\nd\n"))
+}
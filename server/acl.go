@@ -0,0 +1,196 @@
+package input
+
+import (
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/negbie/logp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sipcapture/heplify-server/config"
+	"github.com/sipcapture/heplify-server/decoder"
+)
+
+var cidrRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "heplify_hep_cidr_rejected_total",
+	Help: "HEP packets or connections rejected by the CIDR allow and deny lists"},
+	[]string{"listener"})
+
+var aclListeners = []string{"udp", "tcp", "tls", "ws", "http"}
+
+type cidrList struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+func (l *cidrList) empty() bool {
+	return l == nil || len(l.allow) == 0 && len(l.deny) == 0
+}
+
+// permit rejects ip when it is inside a deny net, or when there are allow
+// nets and none of them contains ip.
+func (l *cidrList) permit(ip net.IP) bool {
+	if l.empty() {
+		return true
+	}
+	if ip == nil {
+		return false
+	}
+	for _, n := range l.deny {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	if len(l.allow) == 0 {
+		return true
+	}
+	for _, n := range l.allow {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ingressACL holds the CIDR lists of every listener. Entries in HEPAllowCIDR
+// and HEPDenyCIDR are either a plain CIDR which applies to all listeners or
+// prefixed with the listener like "udp,10.0.0.0/8". The encapsulated SrcIP
+// and DstIP are checked against the separate HEPEncapAllowCIDR and
+// HEPEncapDenyCIDR lists.
+type ingressACL struct {
+	sync.RWMutex
+	lists map[string]*cidrList
+	encap *cidrList
+}
+
+func newIngressACL() *ingressACL {
+	a := &ingressACL{}
+	a.set(&config.Setting)
+	return a
+}
+
+func (a *ingressACL) set(c *config.HeplifyServer) {
+	lists := make(map[string]*cidrList)
+	for _, l := range append(aclListeners, "") {
+		lists[l] = &cidrList{}
+	}
+
+	add := func(entries []string, deny bool) {
+		for _, e := range entries {
+			listener, cidr, ok := strings.Cut(e, ",")
+			if !ok {
+				listener, cidr = "", e
+			}
+			listener = strings.ToLower(strings.TrimSpace(listener))
+			l, ok := lists[listener]
+			if !ok {
+				logp.Warn("skip CIDR entry %q with unknown listener %q", e, listener)
+				continue
+			}
+			n := parseCIDR(cidr)
+			if n == nil {
+				continue
+			}
+			if deny {
+				l.deny = append(l.deny, n)
+			} else {
+				l.allow = append(l.allow, n)
+			}
+		}
+	}
+	add(c.HEPAllowCIDR, false)
+	add(c.HEPDenyCIDR, true)
+
+	all := lists[""]
+	for _, name := range aclListeners {
+		l := lists[name]
+		l.allow = append(l.allow, all.allow...)
+		l.deny = append(l.deny, all.deny...)
+	}
+	delete(lists, "")
+
+	encap := &cidrList{}
+	for _, e := range c.HEPEncapAllowCIDR {
+		if n := parseCIDR(e); n != nil {
+			encap.allow = append(encap.allow, n)
+		}
+	}
+	for _, e := range c.HEPEncapDenyCIDR {
+		if n := parseCIDR(e); n != nil {
+			encap.deny = append(encap.deny, n)
+		}
+	}
+	if encap.empty() {
+		encap = nil
+	}
+
+	a.Lock()
+	a.lists = lists
+	a.encap = encap
+	a.Unlock()
+}
+
+func parseCIDR(e string) *net.IPNet {
+	_, n, err := net.ParseCIDR(strings.TrimSpace(e))
+	if err != nil {
+		logp.Warn("skip CIDR entry %q: %v", e, err)
+		return nil
+	}
+	return n
+}
+
+func (a *ingressACL) reload() {
+	cfg, err := config.ReadFile()
+	if err != nil {
+		logp.Err("failed to reload HEP CIDR lists: %v", err)
+		return
+	}
+	a.set(cfg)
+}
+
+// allowPeer checks the socket peer address of the listener.
+func (a *ingressACL) allowPeer(listener string, ip net.IP) bool {
+	a.RLock()
+	ok := a.lists[listener].permit(ip)
+	a.RUnlock()
+	if !ok {
+		cidrRejected.WithLabelValues(listener).Inc()
+	}
+	return ok
+}
+
+// allowHEP checks the decoded SrcIP and DstIP of a packet which came in on
+// listener. Neither may be denied and, if there are allow nets, one of them
+// must be allowed, so calls between allowed and outside parties pass.
+func (a *ingressACL) allowHEP(listener string, h *decoder.HEP) bool {
+	a.RLock()
+	l := a.encap
+	a.RUnlock()
+	if l == nil || l.encapPermit(net.ParseIP(h.SrcIP), net.ParseIP(h.DstIP)) {
+		return true
+	}
+	cidrRejected.WithLabelValues(listener).Inc()
+	return false
+}
+
+func (l *cidrList) encapPermit(src, dst net.IP) bool {
+	for _, n := range l.deny {
+		if src != nil && n.Contains(src) || dst != nil && n.Contains(dst) {
+			return false
+		}
+	}
+	if len(l.allow) == 0 {
+		return true
+	}
+	for _, n := range l.allow {
+		if src != nil && n.Contains(src) || dst != nil && n.Contains(dst) {
+			return true
+		}
+	}
+	return false
+}
+
+func addrIP(a net.Addr) net.IP {
+	return net.ParseIP(remoteIP(a))
+}
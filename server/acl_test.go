@@ -0,0 +1,62 @@
+package input
+
+import (
+	"net"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sipcapture/heplify-server/config"
+	"github.com/sipcapture/heplify-server/decoder"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIngressACL(t *testing.T) {
+	a := &ingressACL{}
+	a.set(&config.HeplifyServer{
+		HEPAllowCIDR: []string{"udp,10.0.0.0/8", "tls,192.168.0.0/16", "bogus"},
+		HEPDenyCIDR:  []string{"10.6.6.0/24", "ws,0.0.0.0/0", "sctp,10.0.0.0/8"},
+	})
+
+	assert.True(t, a.allowPeer("udp", net.ParseIP("10.1.2.3")))
+	assert.False(t, a.allowPeer("udp", net.ParseIP("172.16.0.1")))
+	assert.False(t, a.allowPeer("udp", net.ParseIP("10.6.6.6")))
+	assert.True(t, a.allowPeer("tcp", net.ParseIP("172.16.0.1")))
+	assert.False(t, a.allowPeer("tcp", net.ParseIP("10.6.6.6")))
+	assert.True(t, a.allowPeer("tls", net.ParseIP("192.168.1.1")))
+	assert.False(t, a.allowPeer("tls", net.ParseIP("10.1.2.3")))
+
+	before := testutil.ToFloat64(cidrRejected.WithLabelValues("ws"))
+	assert.False(t, a.allowPeer("ws", net.ParseIP("127.0.0.1")))
+	assert.Equal(t, before+1, testutil.ToFloat64(cidrRejected.WithLabelValues("ws")))
+
+	// the listener lists don't apply to encapsulated addresses
+	h := &decoder.HEP{SrcIP: "10.6.6.1", DstIP: "172.16.1.1"}
+	assert.True(t, a.allowHEP("udp", h))
+}
+
+func TestIngressACLEncap(t *testing.T) {
+	a := &ingressACL{}
+	a.set(&config.HeplifyServer{
+		HEPAllowCIDR:      []string{"10.0.0.0/8"},
+		HEPEncapAllowCIDR: []string{"198.51.100.0/24", "2001:db8::/32"},
+		HEPEncapDenyCIDR:  []string{"198.51.100.66/32"},
+	})
+
+	// one allowed side is enough, the peer lists don't matter
+	assert.True(t, a.allowHEP("udp", &decoder.HEP{SrcIP: "203.0.113.1", DstIP: "198.51.100.1"}))
+	assert.True(t, a.allowHEP("udp", &decoder.HEP{SrcIP: "2001:db8::1", DstIP: ""}))
+	assert.True(t, a.allowPeer("udp", net.ParseIP("10.1.2.3")))
+
+	udp := testutil.ToFloat64(cidrRejected.WithLabelValues("udp"))
+	tls := testutil.ToFloat64(cidrRejected.WithLabelValues("tls"))
+	assert.False(t, a.allowHEP("udp", &decoder.HEP{SrcIP: "198.51.100.1", DstIP: "198.51.100.66"}))
+	assert.False(t, a.allowHEP("udp", &decoder.HEP{SrcIP: "203.0.113.1", DstIP: "10.1.1.1"}))
+	assert.False(t, a.allowHEP("tls", &decoder.HEP{SrcIP: "", DstIP: "10.1.1.1"}))
+	assert.Equal(t, udp+2, testutil.ToFloat64(cidrRejected.WithLabelValues("udp")))
+	assert.Equal(t, tls+1, testutil.ToFloat64(cidrRejected.WithLabelValues("tls")))
+
+	a.set(&config.HeplifyServer{HEPEncapDenyCIDR: []string{"198.51.100.66/32"}})
+	assert.True(t, a.allowHEP("udp", &decoder.HEP{SrcIP: "172.16.1.1", DstIP: "10.6.6.1"}))
+	assert.False(t, a.allowHEP("udp", &decoder.HEP{SrcIP: "198.51.100.66", DstIP: "10.6.6.1"}))
+	assert.True(t, a.allowPeer("udp", nil))
+}
@@ -0,0 +1,94 @@
+package input
+
+import (
+	"crypto/subtle"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/negbie/logp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sipcapture/heplify-server/config"
+	"github.com/sipcapture/heplify-server/decoder"
+)
+
+var authRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "heplify_hep_auth_rejected_total",
+	Help: "HEP packets rejected because of a missing or wrong NodePW"},
+	[]string{"node"})
+
+// nodeAuth holds the allowed NodeID or NodeName to NodePW pairs. Entries in
+// HEPAuthNodes look like "2001,secret" or "sbc-core,secret".
+type nodeAuth struct {
+	sync.RWMutex
+	nodes map[string]string
+}
+
+func newNodeAuth(entries []string) *nodeAuth {
+	a := &nodeAuth{}
+	a.set(entries)
+	return a
+}
+
+func (a *nodeAuth) set(entries []string) {
+	nodes := make(map[string]string, len(entries))
+	for _, e := range entries {
+		node, pw, ok := strings.Cut(e, ",")
+		node = strings.TrimSpace(node)
+		if !ok || node == "" || pw == "" {
+			logp.Warn("skip invalid HEPAuthNodes entry for %q, want node,password", node)
+			continue
+		}
+		nodes[node] = pw
+	}
+	a.Lock()
+	a.nodes = nodes
+	a.Unlock()
+	logp.Info("loaded %d HEP auth nodes", len(nodes))
+}
+
+func (a *nodeAuth) reload() {
+	cfg, err := config.ReadFile()
+	if err != nil {
+		logp.Err("failed to reload HEPAuthNodes: %v", err)
+		return
+	}
+	a.set(cfg.HEPAuthNodes)
+}
+
+// check reports whether the packet carries the password of its node. The
+// NodeName entry wins over the NodeID entry. On success the password is
+// removed so it won't be stored or forwarded.
+func (a *nodeAuth) check(h *decoder.HEP) bool {
+	a.RLock()
+	node := h.NodeName
+	pw, ok := a.nodes[node]
+	if !ok {
+		node = strconv.FormatUint(uint64(h.NodeID), 10)
+		pw, ok = a.nodes[node]
+	}
+	a.RUnlock()
+
+	if !ok {
+		authRejected.WithLabelValues("unknown").Inc()
+		return false
+	}
+	if h.NodePW == "" || subtle.ConstantTimeCompare([]byte(h.NodePW), []byte(pw)) != 1 {
+		authRejected.WithLabelValues(node).Inc()
+		return false
+	}
+	h.NodePW = ""
+	return true
+}
+
+// maskAuthNodes returns the entries with their passwords replaced, for
+// logging the configuration.
+func maskAuthNodes(entries []string) []string {
+	masked := make([]string, len(entries))
+	for i, e := range entries {
+		node, _, _ := strings.Cut(e, ",")
+		masked[i] = node + ",<private>"
+	}
+	return masked
+}
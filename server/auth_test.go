@@ -0,0 +1,54 @@
+package input
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sipcapture/heplify-server/config"
+	"github.com/sipcapture/heplify-server/decoder"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNodeAuth(t *testing.T) {
+	a := newNodeAuth([]string{"2001,secret", "sbc-core,pa,ss", "broken", ",nopw"})
+	assert.Len(t, a.nodes, 2)
+
+	h := &decoder.HEP{NodeID: 2001, NodeName: "2001", NodePW: "secret"}
+	assert.True(t, a.check(h))
+	assert.Empty(t, h.NodePW)
+
+	assert.True(t, a.check(&decoder.HEP{NodeID: 7, NodeName: "sbc-core", NodePW: "pa,ss"}))
+	assert.True(t, a.check(&decoder.HEP{NodeID: 2001, NodeName: "edge", NodePW: "secret"}))
+
+	before := testutil.ToFloat64(authRejected.WithLabelValues("2001"))
+	assert.False(t, a.check(&decoder.HEP{NodeID: 2001, NodeName: "2001", NodePW: "wrong"}))
+	assert.False(t, a.check(&decoder.HEP{NodeID: 2001, NodeName: "2001"}))
+	assert.Equal(t, before+2, testutil.ToFloat64(authRejected.WithLabelValues("2001")))
+
+	before = testutil.ToFloat64(authRejected.WithLabelValues("unknown"))
+	assert.False(t, a.check(&decoder.HEP{NodeID: 9, NodeName: "9", NodePW: "secret"}))
+	assert.Equal(t, before+1, testutil.ToFloat64(authRejected.WithLabelValues("unknown")))
+}
+
+func TestNodeAuthReload(t *testing.T) {
+	f := filepath.Join(t.TempDir(), "heplify-server.toml")
+	err := os.WriteFile(f, []byte("HEPAuthEnable = true\nHEPAuthNodes = [\"3001,new\"]\n"), 0600)
+	assert.NoError(t, err)
+
+	orig := config.Setting.Config
+	config.Setting.Config = f
+	defer func() { config.Setting.Config = orig }()
+
+	a := newNodeAuth([]string{"2001,secret"})
+	a.reload()
+	assert.False(t, a.check(&decoder.HEP{NodeID: 2001, NodeName: "2001", NodePW: "secret"}))
+	assert.True(t, a.check(&decoder.HEP{NodeID: 3001, NodeName: "3001", NodePW: "new"}))
+}
+
+func TestMaskAuthNodes(t *testing.T) {
+	entries := []string{"2001,secret", "sbc-core,other"}
+	assert.Equal(t, []string{"2001,<private>", "sbc-core,<private>"}, maskAuthNodes(entries))
+	assert.Equal(t, "2001,secret", entries[0])
+}
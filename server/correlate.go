@@ -0,0 +1,124 @@
+package input
+
+import (
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sipcapture/heplify-server/decoder"
+	"github.com/sipcapture/heplify-server/sweep"
+)
+
+var mediaCorrelation = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "heplify_media_correlation_total",
+	Help: "RTCP and RTP agent packets without correlation ID by SDP lookup result"},
+	[]string{"type", "result"})
+
+// mediaCorrelator remembers the media addresses of SDP bodies and sets
+// the Call-ID as correlation ID of RTCP and RTP agent packets which were
+// sent without one.
+type mediaCorrelator struct {
+	mu    sync.Mutex
+	addrs map[string]*mediaCall
+	ttl   time.Duration
+	sweep *sweep.Sweeper
+}
+
+type mediaCall struct {
+	callID string
+	seen   time.Time
+}
+
+func newMediaCorrelator(ttl time.Duration) *mediaCorrelator {
+	return &mediaCorrelator{
+		addrs: make(map[string]*mediaCall),
+		ttl:   ttl,
+	}
+}
+
+// Start runs the expiry sweep until Stop is called.
+func (c *mediaCorrelator) Start() {
+	c.sweep = sweep.Start(10*time.Second, c.expire)
+}
+
+func (c *mediaCorrelator) Stop() {
+	c.sweep.Stop()
+}
+
+// Process learns the media addresses of a SIP packet or correlates a
+// RTCP, rtpagent or rtcpxr packet.
+func (c *mediaCorrelator) Process(pkt *decoder.HEP) {
+	switch pkt.ProtoType {
+	case 1:
+		c.learn(pkt)
+	case 5, 34, 35:
+		if pkt.CID == "" {
+			c.correlate(pkt)
+		}
+	}
+}
+
+func (c *mediaCorrelator) learn(pkt *decoder.HEP) {
+	if pkt.SIP == nil || pkt.SIP.Body == "" || pkt.SID == "" {
+		return
+	}
+	switch pkt.SIP.CseqMethod {
+	case "INVITE", "ACK", "PRACK", "UPDATE":
+	default:
+		return
+	}
+	sdp := pkt.SIP.SDP()
+	if sdp == nil {
+		return
+	}
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, m := range sdp.Media {
+		if m.Port == 0 || m.Connection == nil {
+			continue
+		}
+		rtcp := m.RTCPPort
+		if rtcp == 0 {
+			rtcp = m.Port + 1
+		}
+		call := &mediaCall{callID: pkt.SID, seen: now}
+		c.addrs[net.JoinHostPort(m.Connection.Address, strconv.Itoa(m.Port))] = call
+		c.addrs[net.JoinHostPort(m.Connection.Address, strconv.Itoa(rtcp))] = call
+	}
+}
+
+func (c *mediaCorrelator) correlate(pkt *decoder.HEP) {
+	src := net.JoinHostPort(pkt.SrcIP, strconv.FormatUint(uint64(pkt.SrcPort), 10))
+	dst := net.JoinHostPort(pkt.DstIP, strconv.FormatUint(uint64(pkt.DstPort), 10))
+
+	c.mu.Lock()
+	call, ok := c.addrs[src]
+	if !ok {
+		call, ok = c.addrs[dst]
+	}
+	if ok {
+		call.seen = time.Now()
+		pkt.CID = call.callID
+	}
+	c.mu.Unlock()
+
+	if ok {
+		mediaCorrelation.WithLabelValues(pkt.ProtoString, "hit").Inc()
+	} else {
+		mediaCorrelation.WithLabelValues(pkt.ProtoString, "miss").Inc()
+	}
+}
+
+func (c *mediaCorrelator) expire(now time.Time) {
+	c.mu.Lock()
+	for addr, call := range c.addrs {
+		if now.Sub(call.seen) > c.ttl {
+			delete(c.addrs, addr)
+		}
+	}
+	c.mu.Unlock()
+}
@@ -0,0 +1,48 @@
+package input
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sipcapture/heplify-server/decoder"
+	"github.com/sipcapture/heplify-server/sipparser"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMediaCorrelator(t *testing.T) {
+	c := newMediaCorrelator(time.Minute)
+
+	invite := "INVITE sip:bob@example.com SIP/2.0\r\nCall-ID: media-1@example.com\r\nCSeq: 1 INVITE\r\nContent-Type: application/sdp\r\n\r\n" +
+		"v=0\r\nc=IN IP4 192.0.2.1\r\nm=audio 4000 RTP/AVP 0\r\n"
+	answer := "SIP/2.0 200 OK\r\nCall-ID: media-1@example.com\r\nCSeq: 1 INVITE\r\nContent-Type: application/sdp\r\n\r\n" +
+		"v=0\r\nc=IN IP4 192.0.2.2\r\nm=audio 5000 RTP/AVP 0\r\na=rtcp:5005\r\nm=video 0 RTP/AVP 96\r\n"
+	options := "SIP/2.0 200 OK\r\nCall-ID: options-1@example.com\r\nCSeq: 1 OPTIONS\r\nContent-Type: application/sdp\r\n\r\n" +
+		"v=0\r\nc=IN IP4 192.0.2.3\r\nm=audio 6000 RTP/AVP 0\r\n"
+	for _, m := range []string{invite, answer, options} {
+		s := sipparser.ParseMsg(m, nil, nil)
+		c.Process(&decoder.HEP{ProtoType: 1, SIP: s, SID: s.CallID})
+	}
+	assert.Len(t, c.addrs, 4)
+
+	rtcp := func(srcIP string, srcPort uint32, cid string) *decoder.HEP {
+		pkt := &decoder.HEP{ProtoType: 5, ProtoString: "rtcp", SrcIP: srcIP, SrcPort: srcPort, DstIP: "198.51.100.1", DstPort: 9, CID: cid}
+		c.Process(pkt)
+		return pkt
+	}
+	hits := testutil.ToFloat64(mediaCorrelation.WithLabelValues("rtcp", "hit"))
+	misses := testutil.ToFloat64(mediaCorrelation.WithLabelValues("rtcp", "miss"))
+
+	assert.Equal(t, "media-1@example.com", rtcp("192.0.2.1", 4001, "").CID)
+	assert.Equal(t, "media-1@example.com", rtcp("192.0.2.2", 5005, "").CID)
+	assert.Equal(t, "", rtcp("192.0.2.2", 5001, "").CID)
+	assert.Equal(t, "", rtcp("192.0.2.3", 6001, "").CID)
+	assert.Equal(t, "agent", rtcp("192.0.2.1", 4001, "agent").CID)
+	assert.Equal(t, hits+2, testutil.ToFloat64(mediaCorrelation.WithLabelValues("rtcp", "hit")))
+	assert.Equal(t, misses+2, testutil.ToFloat64(mediaCorrelation.WithLabelValues("rtcp", "miss")))
+
+	c.expire(time.Now().Add(30 * time.Second))
+	assert.Len(t, c.addrs, 4)
+	c.expire(time.Now().Add(2 * time.Minute))
+	assert.Empty(t, c.addrs)
+}
@@ -0,0 +1,124 @@
+package input
+
+import (
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/negbie/logp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sipcapture/heplify-server/config"
+	"github.com/sipcapture/heplify-server/enrich"
+)
+
+var (
+	enrichReloads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "heplify_enrich_reload_total",
+		Help: "Enrichment table and GeoIP reloads by result"},
+		[]string{"result"})
+	enrichEntries = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "heplify_enrich_table_entries",
+		Help: "Rows of the loaded enrichment tables"},
+		[]string{"table"})
+)
+
+// enrichReloader loads the enrichment tables and GeoIP database and
+// reloads them when their files change. Data which fails to load is
+// thrown away and the active data stays.
+type enrichReloader struct {
+	mu      sync.Mutex
+	folder  string
+	geoFile string
+	tables  []string
+	quit    chan struct{}
+	done    chan struct{}
+}
+
+func newEnrichReloader() *enrichReloader {
+	return &enrichReloader{
+		folder:  config.Setting.EnrichFolder,
+		geoFile: config.Setting.EnrichGeoIPFile,
+	}
+}
+
+// Start watches the table folder and the folder of the GeoIP database
+// until Stop is called.
+func (r *enrichReloader) Start() {
+	r.quit = make(chan struct{})
+	r.done = make(chan struct{})
+
+	var tables, geo <-chan string
+	var err error
+	if r.folder != "" {
+		if tables, err = watchDir(r.folder, enrich.IsTable, r.quit); err != nil {
+			logp.Err("can't watch enrichment folder %s: %v", r.folder, err)
+		}
+	}
+	if r.geoFile != "" {
+		base := filepath.Base(r.geoFile)
+		isGeo := func(name string) bool { return name == base }
+		if geo, err = watchDir(filepath.Dir(r.geoFile), isGeo, r.quit); err != nil {
+			logp.Err("can't watch GeoIP database %s: %v", r.geoFile, err)
+		}
+	}
+
+	go func() {
+		defer close(r.done)
+		settle := time.NewTimer(scriptSettle)
+		settle.Stop()
+		for {
+			select {
+			case <-r.quit:
+				settle.Stop()
+				return
+			case name := <-tables:
+				logp.Debug("enrich", "table %s changed", name)
+				settle.Reset(scriptSettle)
+			case name := <-geo:
+				logp.Debug("enrich", "GeoIP database %s changed", name)
+				settle.Reset(scriptSettle)
+			case <-settle.C:
+				r.reload()
+			}
+		}
+	}()
+}
+
+func (r *enrichReloader) Stop() {
+	close(r.quit)
+	<-r.done
+}
+
+// reload loads the tables and GeoIP database and activates them.
+func (r *enrichReloader) reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	d, err := enrich.Load(r.folder, r.geoFile)
+	if err != nil {
+		enrichReloads.WithLabelValues("failure").Inc()
+		if enrich.Active() != nil {
+			logp.Err("%v, keep previous enrichment data", err)
+		} else {
+			logp.Err("%v, enrichment stays disabled until it is fixed", err)
+		}
+		return err
+	}
+	enrich.Store(d)
+	enrichReloads.WithLabelValues("success").Inc()
+
+	for _, name := range r.tables {
+		enrichEntries.DeleteLabelValues(name)
+	}
+	r.tables = r.tables[:0]
+	for name, t := range d.Tables {
+		enrichEntries.WithLabelValues(name).Set(float64(t.Len()))
+		r.tables = append(r.tables, name)
+		logp.Info("loaded enrichment table %s with %d entries", name, t.Len())
+	}
+	if d.Geo != nil {
+		logp.Info("loaded GeoIP database %s of type %s", r.geoFile, d.Geo.Type)
+	}
+	return nil
+}
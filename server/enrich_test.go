@@ -0,0 +1,48 @@
+package input
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sipcapture/heplify-server/config"
+	"github.com/sipcapture/heplify-server/enrich"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnrichReloader(t *testing.T) {
+	dir := t.TempDir()
+	folder := config.Setting.EnrichFolder
+	config.Setting.EnrichFolder = dir
+	defer func() { config.Setting.EnrichFolder = folder }()
+	defer enrich.Store(nil)
+
+	write := func(data string) {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, "customers.csv"), []byte(data), 0644))
+	}
+	customer := func() string {
+		if d := enrich.Active(); d != nil {
+			return d.Lookup("customers", "192.0.2.1", "customer")
+		}
+		return ""
+	}
+	failures := testutil.ToFloat64(enrichReloads.WithLabelValues("failure"))
+
+	write("cidr,customer\n192.0.2.0/24,acme\n")
+	r := newEnrichReloader()
+	assert.NoError(t, r.reload())
+	assert.Equal(t, "acme", customer())
+	assert.Equal(t, 1.0, testutil.ToFloat64(enrichEntries.WithLabelValues("customers")))
+
+	write("cidr,customer\nnot a network,acme\n")
+	assert.Error(t, r.reload())
+	assert.Equal(t, "acme", customer())
+	assert.Equal(t, failures+1, testutil.ToFloat64(enrichReloads.WithLabelValues("failure")))
+
+	r.Start()
+	defer r.Stop()
+	write("cidr,customer\n192.0.2.0/24,globex\n")
+	assert.Eventually(t, func() bool { return customer() == "globex" }, 5*time.Second, 50*time.Millisecond)
+}
@@ -0,0 +1,356 @@
+package input
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/negbie/logp"
+	"github.com/sipcapture/heplify-server/config"
+	"github.com/sipcapture/heplify-server/database"
+	"github.com/sipcapture/heplify-server/decoder"
+	"github.com/sipcapture/heplify-server/pcap"
+)
+
+const (
+	httpIngestPath    = "/api/v1/hep"
+	httpExportPath    = "/api/v1/pcap"
+	httpBindingPath   = "/api/v1/registrations"
+	httpScriptPath    = "/api/v1/scripts"
+	httpIngestMaxBody = 16 << 20
+)
+
+type ingestResult struct {
+	Accepted int    `json:"accepted"`
+	Rejected int    `json:"rejected"`
+	Error    string `json:"error,omitempty"`
+}
+
+func (h *HEPInput) serveHTTP(addr string) {
+	defer close(h.exitHTTP)
+
+	useTLS := config.Setting.HEPHTTPTLS
+	if useTLS && h.tlsStore == nil {
+		logp.Err("no valid TLS certificates, HTTPS listener on %s disabled", addr)
+		return
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		logp.Err("%v", err)
+		return
+	}
+	if useTLS {
+		ln = tls.NewListener(ln, &tls.Config{
+			GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+				return h.tlsStore.conf.Load(), nil
+			},
+		})
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(httpIngestPath, h.handleHTTP)
+	mux.HandleFunc(httpExportPath, h.handleExport)
+	mux.HandleFunc(httpBindingPath, h.handleBindings)
+	mux.HandleFunc(httpScriptPath, h.handleScripts)
+	srv := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       time.Minute,
+	}
+
+	go func() {
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			logp.Err("%v", err)
+		}
+	}()
+
+	for atomic.LoadUint32(&h.stopped) == 0 {
+		time.Sleep(1e9)
+	}
+	logp.Info("stopping HTTP listener on %s", ln.Addr())
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	srv.Shutdown(ctx)
+}
+
+// handleHTTP accepts a POST body with concatenated HEPv3 packets, varint
+// length prefixed protobuf HEP messages or a JSON array of HEP objects.
+func (h *HEPInput) handleHTTP(w http.ResponseWriter, r *http.Request) {
+	reply := func(code int, res ingestResult) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(res)
+	}
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		reply(http.StatusMethodNotAllowed, ingestResult{Error: "method not allowed"})
+		return
+	}
+	if !httpAuthorized(r) {
+		reply(http.StatusUnauthorized, ingestResult{Error: "invalid bearer token"})
+		return
+	}
+
+	ip := remoteIP(httpAddr(r.RemoteAddr))
+	if !h.acl.allowPeer("http", net.ParseIP(ip)) {
+		reply(http.StatusForbidden, ingestResult{Error: "forbidden"})
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, httpIngestMaxBody))
+	if err != nil {
+		reply(http.StatusRequestEntityTooLarge, ingestResult{Error: err.Error()})
+		return
+	}
+
+	var nodeName string
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		nodeName = certNodeName(r.TLS.VerifiedChains[0][0])
+	}
+
+	var res ingestResult
+	push := func(pkt []byte) {
+		if len(pkt) < minPktLen || len(pkt) > maxPktLen || !h.limits.allowIP(ip) {
+			res.Rejected++
+			return
+		}
+		buf := h.buffer.Get().([]byte)
+		n := copy(buf, pkt)
+		out := buf[:n]
+		if nodeName != "" {
+			var ok bool
+			if out, ok = setNodeName(out, nodeName); !ok {
+				h.buffer.Put(buf)
+				res.Rejected++
+				return
+			}
+		}
+		h.inputCh <- hepMsg{"http", out}
+		atomic.AddUint64(&h.stats.PktCount, 1)
+		res.Accepted++
+	}
+	marshal := func(hep *decoder.HEP) {
+		pkt, err := hep.MarshalHEP3()
+		if err != nil {
+			logp.Debug("hep", "reject HTTP packet from %s: %v", ip, err)
+			res.Rejected++
+			return
+		}
+		push(pkt)
+	}
+
+	ct := r.Header.Get("Content-Type")
+	switch {
+	case strings.HasPrefix(ct, "application/json"):
+		var heps []*decoder.HEP
+		if err := json.Unmarshal(body, &heps); err != nil {
+			reply(http.StatusBadRequest, ingestResult{Error: err.Error()})
+			return
+		}
+		for _, hep := range heps {
+			if hep == nil {
+				res.Rejected++
+				continue
+			}
+			marshal(hep)
+		}
+	case strings.HasPrefix(ct, "application/x-protobuf"):
+		for len(body) > 0 {
+			l, n := binary.Uvarint(body)
+			if n <= 0 || uint64(len(body)-n) < l {
+				res.Rejected++
+				break
+			}
+			hep := &decoder.HEP{}
+			if err := hep.Unmarshal(body[n : n+int(l)]); err != nil {
+				res.Rejected++
+			} else {
+				marshal(hep)
+			}
+			body = body[n+int(l):]
+		}
+	default:
+		for len(body) > 0 {
+			if len(body) < minPktLen || !bytes.HasPrefix(body, []byte{0x48, 0x45, 0x50, 0x33}) {
+				res.Rejected++
+				break
+			}
+			l := int(binary.BigEndian.Uint16(body[4:6]))
+			if l < minPktLen || l > len(body) {
+				res.Rejected++
+				break
+			}
+			push(body[:l])
+			body = body[l:]
+		}
+	}
+
+	code := http.StatusOK
+	if res.Accepted == 0 && res.Rejected > 0 {
+		code = http.StatusBadRequest
+	}
+	reply(code, res)
+}
+
+// handleExport answers GET requests with a pcapng file of the stored SIP
+// messages. The callid parameter can be repeated or comma separated, from
+// and to default to the last 24 hours.
+func (h *HEPInput) handleExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !httpAuthorized(r) {
+		http.Error(w, "invalid bearer token", http.StatusUnauthorized)
+		return
+	}
+	if !h.acl.allowPeer("http", net.ParseIP(remoteIP(httpAddr(r.RemoteAddr)))) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	q := r.URL.Query()
+	var ids []string
+	for _, v := range q["callid"] {
+		for _, id := range strings.Split(v, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				ids = append(ids, id)
+			}
+		}
+	}
+	if len(ids) == 0 {
+		http.Error(w, "missing callid", http.StatusBadRequest)
+		return
+	}
+	now := time.Now()
+	to, err := database.ParseExportTime(q.Get("to"), now)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	from, err := database.ParseExportTime(q.Get("from"), to.Add(-24*time.Hour))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := database.CheckExport(ids, from, to); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	pkts, err := database.FindSIP(ids, from, to)
+	if err != nil {
+		logp.Err("pcap export: %v", err)
+		http.Error(w, "database query failed", http.StatusInternalServerError)
+		return
+	}
+	if len(pkts) == 0 {
+		http.Error(w, "no packets found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-pcapng")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+exportName(ids[0])+`.pcapng"`)
+	if err := pcap.WriteNg(w, pkts); err != nil {
+		logp.Warn("pcap export to %s: %v", r.RemoteAddr, err)
+	}
+}
+
+// handleBindings lists the current registrations as JSON. They can be
+// filtered by the aor and domain parameters.
+func (h *HEPInput) handleBindings(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !httpAuthorized(r) {
+		http.Error(w, "invalid bearer token", http.StatusUnauthorized)
+		return
+	}
+	if !h.acl.allowPeer("http", net.ParseIP(remoteIP(httpAddr(r.RemoteAddr)))) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	q := r.URL.Query()
+	aor, domain := q.Get("aor"), q.Get("domain")
+	list := []decoder.Binding{}
+	if h.bindings != nil {
+		for _, b := range h.bindings.Bindings() {
+			if aor != "" && b.AoR != aor || domain != "" && b.Domain != domain {
+				continue
+			}
+			list = append(list, b)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+func (h *HEPInput) handleScripts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !httpAuthorized(r) {
+		http.Error(w, "invalid bearer token", http.StatusUnauthorized)
+		return
+	}
+	if !h.acl.allowPeer("http", net.ParseIP(remoteIP(httpAddr(r.RemoteAddr)))) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	if h.scripts == nil {
+		http.Error(w, "scripts are disabled", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.scripts.Status())
+}
+
+// httpAuthorized checks the Bearer token of the Authorization header
+// when HEPHTTPToken is set.
+func httpAuthorized(r *http.Request) bool {
+	token := config.Setting.HEPHTTPToken
+	if token == "" {
+		return true
+	}
+	scheme, auth, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(strings.TrimSpace(auth)), []byte(token)) == 1
+}
+
+// exportName keeps only characters which are safe in a file name.
+func exportName(id string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '.' || r == '_' {
+			return r
+		}
+		return '_'
+	}, id)
+}
+
+func httpAddr(s string) net.Addr {
+	a, err := net.ResolveTCPAddr("tcp", s)
+	if err != nil {
+		return &net.IPAddr{}
+	}
+	return a
+}
@@ -0,0 +1,164 @@
+package input
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sipcapture/heplify-server/config"
+	"github.com/sipcapture/heplify-server/decoder"
+	"github.com/sipcapture/heplify-server/dialog"
+	"github.com/stretchr/testify/assert"
+)
+
+func postHEP(h *HEPInput, ct, token string, body []byte) (int, ingestResult) {
+	r := httptest.NewRequest(http.MethodPost, httpIngestPath, bytes.NewReader(body))
+	r.RemoteAddr = "127.0.0.1:40000"
+	if ct != "" {
+		r.Header.Set("Content-Type", ct)
+	}
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	h.handleHTTP(w, r)
+
+	var res ingestResult
+	json.Unmarshal(w.Body.Bytes(), &res)
+	return w.Code, res
+}
+
+func TestHandleHTTP(t *testing.T) {
+	orig := config.Setting
+	defer func() { config.Setting = orig }()
+	config.Setting.HEPHTTPToken = "secret"
+
+	h := NewHEPInput()
+	drain := func(n int) {
+		for i := 0; i < n; i++ {
+			msg := <-h.inputCh
+			assert.Equal(t, "http", msg.listener)
+			hep, err := decoder.DecodeHEP(msg.data)
+			assert.NoError(t, err)
+			assert.Equal(t, "192.168.247.250", hep.SrcIP)
+		}
+		assert.Len(t, h.inputCh, 0)
+	}
+
+	code, _ := postHEP(h, "", "wrong", hepPacket)
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	r := httptest.NewRequest(http.MethodGet, httpIngestPath, nil)
+	w := httptest.NewRecorder()
+	h.handleHTTP(w, r)
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+
+	// concatenated HEPv3 with trailing garbage
+	body := append(append(append([]byte{}, hepPacket...), hepPacket...), "HEP3xx"...)
+	code, res := postHEP(h, "application/octet-stream", "secret", body)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, ingestResult{Accepted: 2, Rejected: 1}, res)
+	drain(2)
+
+	hep, err := decoder.DecodeHEP(hepPacket)
+	assert.NoError(t, err)
+
+	js, err := json.Marshal([]*decoder.HEP{hep, {SrcIP: "bad"}})
+	assert.NoError(t, err)
+	code, res = postHEP(h, "application/json", "secret", js)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, ingestResult{Accepted: 1, Rejected: 1}, res)
+	drain(1)
+
+	pb, err := hep.Marshal()
+	assert.NoError(t, err)
+	var framed []byte
+	for i := 0; i < 3; i++ {
+		framed = binary.AppendUvarint(framed, uint64(len(pb)))
+		framed = append(framed, pb...)
+	}
+	code, res = postHEP(h, "application/x-protobuf", "secret", framed)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, ingestResult{Accepted: 3}, res)
+	drain(3)
+
+	code, res = postHEP(h, "", "secret", []byte("garbage"))
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, ingestResult{Rejected: 1}, res)
+}
+
+func TestHTTPAuthorized(t *testing.T) {
+	orig := config.Setting
+	defer func() { config.Setting = orig }()
+	config.Setting.HEPHTTPToken = "secret"
+
+	for auth, ok := range map[string]bool{
+		"Bearer secret": true,
+		"bearer secret": true,
+		"secret":        false,
+		"Basic secret":  false,
+		"Bearer wrong":  false,
+		"":              false,
+	} {
+		r := httptest.NewRequest(http.MethodGet, httpIngestPath, nil)
+		r.Header.Set("Authorization", auth)
+		assert.Equal(t, ok, httpAuthorized(r), auth)
+	}
+}
+
+func TestHandleExport(t *testing.T) {
+	h := NewHEPInput()
+	get := func(target string) int {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		r.RemoteAddr = "127.0.0.1:40000"
+		w := httptest.NewRecorder()
+		h.handleExport(w, r)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusBadRequest, get(httpExportPath))
+	assert.Equal(t, http.StatusBadRequest, get(httpExportPath+"?callid=abc&from=yesterday"))
+	assert.Equal(t, http.StatusBadRequest, get(httpExportPath+"?callid=abc&from=1577934245&to=1577930000"))
+	// the mock database can't be queried
+	assert.Equal(t, http.StatusInternalServerError, get(httpExportPath+"?callid=abc,def"))
+	assert.Equal(t, "abc_def.ghi", exportName("abc/def.ghi"))
+}
+
+func TestHandleBindings(t *testing.T) {
+	h := NewHEPInput()
+	get := func(target string) (int, []decoder.Binding) {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		r.RemoteAddr = "127.0.0.1:40000"
+		w := httptest.NewRecorder()
+		h.handleBindings(w, r)
+		var list []decoder.Binding
+		json.Unmarshal(w.Body.Bytes(), &list)
+		return w.Code, list
+	}
+
+	code, list := get(httpBindingPath)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Empty(t, list)
+
+	h.bindings = dialog.NewRegistrar(func(*decoder.HEP) {})
+	for _, to := range []string{"alice", "bob"} {
+		hep, err := decoder.DecodeHEP(hepPacket)
+		assert.NoError(t, err)
+		hep.SIP.CseqMethod = "REGISTER"
+		hep.SIP.FirstResp = "200"
+		hep.SIP.ToUser, hep.SIP.ToHost = to, "example.com"
+		hep.SIP.ContactUser, hep.SIP.ContactHost = to, "10.0.0.1"
+		h.bindings.Process(hep)
+	}
+
+	_, list = get(httpBindingPath)
+	assert.Len(t, list, 2)
+	_, list = get(httpBindingPath + "?aor=bob@example.com")
+	if assert.Len(t, list, 1) {
+		assert.Equal(t, "bob@10.0.0.1", list[0].Contact)
+		assert.Equal(t, "192.168.245.250", list[0].SrcIP)
+	}
+}
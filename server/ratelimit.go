@@ -0,0 +1,261 @@
+package input
+
+import (
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/negbie/logp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sipcapture/heplify-server/config"
+)
+
+const (
+	bucketIdle = time.Minute
+	// maxBuckets bounds the buckets of a limiter, sources beyond it share
+	// one bucket so spoofed addresses can't grow the map.
+	maxBuckets = 100000
+	// maxSourceLabels bounds the source label values of the metrics, later
+	// sources are counted as "other".
+	maxSourceLabels = 200
+	otherSource     = "other"
+)
+
+var (
+	rateDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "heplify_hep_ratelimit_dropped_total",
+		Help: "HEP packets dropped by the per source rate limit"},
+		[]string{"limit", "source"})
+	connRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "heplify_hep_conn_rejected_total",
+		Help: "HEP stream connections rejected by the per IP connection limit"},
+		[]string{"source"})
+)
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter is a token bucket per key. A rate of zero disables it.
+type rateLimiter struct {
+	enabled   atomic.Bool
+	mu        sync.Mutex
+	rate      float64
+	burst     float64
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+func newRateLimiter(rate, burst int) *rateLimiter {
+	l := &rateLimiter{buckets: make(map[string]*bucket)}
+	l.set(rate, burst)
+	return l
+}
+
+func (l *rateLimiter) set(rate, burst int) {
+	if burst < rate {
+		burst = rate
+	}
+	l.mu.Lock()
+	l.rate = float64(rate)
+	l.burst = float64(burst)
+	l.buckets = make(map[string]*bucket)
+	l.enabled.Store(rate > 0)
+	l.mu.Unlock()
+}
+
+// active reports without locking whether the limiter is enabled.
+func (l *rateLimiter) active() bool {
+	return l.enabled.Load()
+}
+
+func (l *rateLimiter) allow(key string, now time.Time) bool {
+	if !l.active() {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate <= 0 {
+		return true
+	}
+
+	if now.Sub(l.lastSweep) > bucketIdle {
+		l.sweep(now)
+	}
+
+	b, ok := l.buckets[key]
+	if !ok && len(l.buckets) >= maxBuckets {
+		l.sweep(now)
+		if len(l.buckets) >= maxBuckets {
+			key = otherSource
+			b, ok = l.buckets[key]
+		}
+	}
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	} else {
+		b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+		b.last = now
+	}
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+func (l *rateLimiter) sweep(now time.Time) {
+	for k, b := range l.buckets {
+		if now.Sub(b.last) > bucketIdle {
+			delete(l.buckets, k)
+		}
+	}
+	l.lastSweep = now
+}
+
+// labelSet hands out at most max distinct label values, the rest is
+// counted as "other".
+type labelSet struct {
+	mu   sync.Mutex
+	seen map[string]struct{}
+	max  int
+}
+
+func (s *labelSet) label(v string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.seen[v]; ok {
+		return v
+	}
+	if len(s.seen) >= s.max {
+		return otherSource
+	}
+	s.seen[v] = struct{}{}
+	return v
+}
+
+// connLimiter counts open stream connections per IP. A max of zero disables it.
+type connLimiter struct {
+	mu    sync.Mutex
+	max   int
+	conns map[string]int
+}
+
+func (l *connLimiter) set(max int) {
+	l.mu.Lock()
+	l.max = max
+	l.mu.Unlock()
+}
+
+func (l *connLimiter) acquire(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.max > 0 && l.conns[ip] >= l.max {
+		return false
+	}
+	l.conns[ip]++
+	return true
+}
+
+func (l *connLimiter) release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.conns[ip] <= 1 {
+		delete(l.conns, ip)
+		return
+	}
+	l.conns[ip]--
+}
+
+type sourceLimits struct {
+	ip      *rateLimiter
+	node    *rateLimiter
+	conns   *connLimiter
+	sources *labelSet
+}
+
+func newSourceLimits() *sourceLimits {
+	s := &sourceLimits{
+		ip:      newRateLimiter(0, 0),
+		node:    newRateLimiter(0, 0),
+		conns:   &connLimiter{conns: make(map[string]int)},
+		sources: &labelSet{seen: make(map[string]struct{}), max: maxSourceLabels},
+	}
+	s.set(&config.Setting)
+	return s
+}
+
+func (s *sourceLimits) set(c *config.HeplifyServer) {
+	s.ip.set(c.HEPRateLimitIP, c.HEPRateBurstIP)
+	s.node.set(c.HEPRateLimitNode, c.HEPRateBurstNode)
+	s.conns.set(c.HEPMaxConnPerIP)
+	if c.HEPRateLimitIP > 0 || c.HEPRateLimitNode > 0 || c.HEPMaxConnPerIP > 0 {
+		logp.Info("HEP limits: %d pps per IP, %d pps per node, %d connections per IP",
+			c.HEPRateLimitIP, c.HEPRateLimitNode, c.HEPMaxConnPerIP)
+	}
+}
+
+func (s *sourceLimits) reload() {
+	cfg, err := config.ReadFile()
+	if err != nil {
+		logp.Err("failed to reload HEP limits: %v", err)
+		return
+	}
+	s.set(cfg)
+}
+
+func (s *sourceLimits) allowIP(ip string) bool {
+	if !s.ip.active() || s.ip.allow(ip, time.Now()) {
+		return true
+	}
+	rateDropped.WithLabelValues("ip", s.sources.label(ip)).Inc()
+	return false
+}
+
+// allowAddr is allowIP for the UDP read path, the address is only
+// formatted when the limit is enabled.
+func (s *sourceLimits) allowAddr(ip net.IP) bool {
+	return !s.ip.active() || s.allowIP(ip.String())
+}
+
+func (s *sourceLimits) allowNode(nodeID uint32) bool {
+	if !s.node.active() {
+		return true
+	}
+	node := strconv.FormatUint(uint64(nodeID), 10)
+	if s.node.allow(node, time.Now()) {
+		return true
+	}
+	rateDropped.WithLabelValues("node", s.sources.label(node)).Inc()
+	return false
+}
+
+// acceptConn checks the connection limit for c. The returned release func
+// must be called when the connection is closed.
+func (s *sourceLimits) acceptConn(c net.Conn) (func(), bool) {
+	ip := remoteIP(c.RemoteAddr())
+	if !s.conns.acquire(ip) {
+		connRejected.WithLabelValues(s.sources.label(ip)).Inc()
+		return nil, false
+	}
+	return func() { s.conns.release(ip) }, true
+}
+
+func remoteIP(a net.Addr) string {
+	switch v := a.(type) {
+	case *net.UDPAddr:
+		return v.IP.String()
+	case *net.TCPAddr:
+		return v.IP.String()
+	}
+	host, _, err := net.SplitHostPort(a.String())
+	if err != nil {
+		return a.String()
+	}
+	return host
+}
@@ -0,0 +1,101 @@
+package input
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sipcapture/heplify-server/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Now()
+	l := newRateLimiter(10, 20)
+	for i := 0; i < 20; i++ {
+		assert.True(t, l.allow("10.0.0.1", now))
+	}
+	assert.False(t, l.allow("10.0.0.1", now))
+	assert.True(t, l.allow("10.0.0.2", now))
+
+	// 100ms refill one token at 10 pps
+	now = now.Add(100 * time.Millisecond)
+	assert.True(t, l.allow("10.0.0.1", now))
+	assert.False(t, l.allow("10.0.0.1", now))
+
+	// idle buckets are swept
+	now = now.Add(2 * bucketIdle)
+	assert.True(t, l.allow("10.0.0.3", now))
+	assert.Len(t, l.buckets, 1)
+
+	l.set(0, 0)
+	for i := 0; i < 100; i++ {
+		assert.True(t, l.allow("10.0.0.1", now))
+	}
+}
+
+func TestRateLimiterBounded(t *testing.T) {
+	now := time.Now()
+	l := newRateLimiter(1, 1)
+	for i := 0; i < maxBuckets; i++ {
+		l.allow(strconv.Itoa(i), now)
+	}
+	assert.True(t, l.allow("new", now), "first source beyond the cap")
+	assert.False(t, l.allow("newer", now), "sources beyond the cap share a bucket")
+	assert.Len(t, l.buckets, maxBuckets+1)
+
+	s := &labelSet{seen: make(map[string]struct{}), max: 2}
+	assert.Equal(t, "a", s.label("a"))
+	assert.Equal(t, "b", s.label("b"))
+	assert.Equal(t, otherSource, s.label("c"))
+	assert.Equal(t, "a", s.label("a"))
+}
+
+func TestConnLimiter(t *testing.T) {
+	s := newSourceLimits()
+	s.conns.set(2)
+
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+
+	before := testutil.ToFloat64(connRejected.WithLabelValues("pipe"))
+	r1, ok := s.acceptConn(c1)
+	assert.True(t, ok)
+	r2, ok := s.acceptConn(c1)
+	assert.True(t, ok)
+	_, ok = s.acceptConn(c1)
+	assert.False(t, ok)
+	assert.Equal(t, before+1, testutil.ToFloat64(connRejected.WithLabelValues("pipe")))
+
+	r1()
+	_, ok = s.acceptConn(c1)
+	assert.True(t, ok)
+	r2()
+}
+
+func TestSourceLimitsReload(t *testing.T) {
+	f := filepath.Join(t.TempDir(), "heplify-server.toml")
+	err := os.WriteFile(f, []byte("HEPRateLimitNode = 1\nHEPMaxConnPerIP = 3\n"), 0600)
+	assert.NoError(t, err)
+
+	orig := config.Setting.Config
+	config.Setting.Config = f
+	defer func() { config.Setting.Config = orig }()
+
+	s := newSourceLimits()
+	assert.True(t, s.allowNode(42))
+	assert.True(t, s.allowNode(42))
+
+	s.reload()
+	assert.Equal(t, 3, s.conns.max)
+	assert.True(t, s.allowNode(42))
+	before := testutil.ToFloat64(rateDropped.WithLabelValues("node", "42"))
+	assert.False(t, s.allowNode(42))
+	assert.Equal(t, before+1, testutil.ToFloat64(rateDropped.WithLabelValues("node", "42")))
+	assert.True(t, s.allowIP("10.0.0.1"))
+}
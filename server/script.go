@@ -0,0 +1,188 @@
+package input
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/negbie/logp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sipcapture/heplify-server/config"
+	"github.com/sipcapture/heplify-server/decoder"
+)
+
+// scriptSettle is how long the script folder has to be quiet after a
+// change before the scripts are reloaded.
+const scriptSettle = 500 * time.Millisecond
+
+var (
+	scriptReloads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "heplify_script_reload_total",
+		Help: "Script reloads by result"},
+		[]string{"result"})
+	scriptGeneration = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "heplify_script_generation",
+		Help: "Generation of the active scripts, increased by every successful reload"})
+)
+
+// scriptStatus is served by the HTTP listener.
+type scriptStatus struct {
+	Engine     string    `json:"engine"`
+	Folder     string    `json:"folder"`
+	Active     bool      `json:"active"`
+	Generation uint64    `json:"generation"`
+	LoadedAt   time.Time `json:"loaded_at,omitempty"`
+	LastReload time.Time `json:"last_reload"`
+	LastError  string    `json:"last_error,omitempty"`
+	Reloads    uint64    `json:"reloads"`
+	Failures   uint64    `json:"failures"`
+}
+
+// scriptSet is one compiled generation of the scripts with an engine for
+// every worker. Workers which find the pool empty build their own.
+type scriptSet struct {
+	gen     uint64
+	engines chan decoder.ScriptEngine
+	build   func() (decoder.ScriptEngine, error)
+}
+
+func (s *scriptSet) engine() (decoder.ScriptEngine, error) {
+	select {
+	case e := <-s.engines:
+		return e, nil
+	default:
+		return s.build()
+	}
+}
+
+// drain closes the engines no worker took.
+func (s *scriptSet) drain() {
+	for {
+		select {
+		case e := <-s.engines:
+			e.Close()
+		default:
+			return
+		}
+	}
+}
+
+// scriptReloader compiles the scripts off the hot path and swaps them in
+// for all workers at once. A set which fails to compile is thrown away
+// and the workers keep the active one.
+type scriptReloader struct {
+	mu      sync.Mutex
+	current atomic.Pointer[scriptSet]
+	size    int
+	build   func() (decoder.ScriptEngine, error)
+	status  scriptStatus
+	quit    chan struct{}
+	done    chan struct{}
+}
+
+func newScriptReloader(size int) *scriptReloader {
+	return &scriptReloader{
+		size:  size,
+		build: decoder.NewScriptEngine,
+		status: scriptStatus{
+			Engine: config.Setting.ScriptEngine,
+			Folder: config.Setting.ScriptFolder,
+		},
+	}
+}
+
+// Start watches the script folder until Stop is called.
+func (r *scriptReloader) Start() {
+	r.quit = make(chan struct{})
+	r.done = make(chan struct{})
+	if r.status.Folder == "" {
+		close(r.done)
+		return
+	}
+
+	events, err := watchDir(r.status.Folder, isScript, r.quit)
+	if err != nil {
+		logp.Err("can't watch script folder %s: %v", r.status.Folder, err)
+		close(r.done)
+		return
+	}
+	go func() {
+		defer close(r.done)
+		settle := time.NewTimer(scriptSettle)
+		settle.Stop()
+		for {
+			select {
+			case <-r.quit:
+				settle.Stop()
+				return
+			case name := <-events:
+				logp.Debug("script", "script %s changed", name)
+				settle.Reset(scriptSettle)
+			case <-settle.C:
+				r.reload()
+			}
+		}
+	}()
+}
+
+func (r *scriptReloader) Stop() {
+	close(r.quit)
+	<-r.done
+	if set := r.current.Load(); set != nil {
+		set.drain()
+	}
+}
+
+// reload compiles a new set of scripts and activates it.
+func (r *scriptReloader) reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.status.LastReload = time.Now()
+	set := &scriptSet{
+		engines: make(chan decoder.ScriptEngine, r.size),
+		build:   r.build,
+	}
+	for i := 0; i < r.size; i++ {
+		e, err := r.build()
+		if err != nil {
+			set.drain()
+			r.status.LastError = err.Error()
+			r.status.Failures++
+			scriptReloads.WithLabelValues("failure").Inc()
+			if r.status.Active {
+				logp.Err("%v, keep scripts of generation %d", err, r.status.Generation)
+			} else {
+				logp.Err("%v, scripts stay disabled until they are fixed", err)
+			}
+			return err
+		}
+		set.engines <- e
+	}
+
+	r.status.Generation++
+	r.status.Active = true
+	r.status.LoadedAt = r.status.LastReload
+	r.status.LastError = ""
+	r.status.Reloads++
+	set.gen = r.status.Generation
+	if old := r.current.Swap(set); old != nil {
+		old.drain()
+	}
+	scriptReloads.WithLabelValues("success").Inc()
+	scriptGeneration.Set(float64(set.gen))
+	logp.Info("loaded %s scripts of generation %d", r.status.Engine, set.gen)
+	return nil
+}
+
+func (r *scriptReloader) Status() scriptStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.status
+}
+
+func isScript(name string) bool {
+	return strings.HasSuffix(name, ".lua") || strings.HasSuffix(name, ".expr")
+}
@@ -0,0 +1,78 @@
+package input
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sipcapture/heplify-server/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScriptReloader(t *testing.T) {
+	dir := t.TempDir()
+	folder := config.Setting.ScriptFolder
+	config.Setting.ScriptFolder = dir
+	defer func() { config.Setting.ScriptFolder = folder }()
+
+	write := func(code string) {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, "node.expr"), []byte(code), 0644))
+	}
+	send := func(h *HEPInput) string {
+		buf := h.buffer.Get().([]byte)
+		copy(buf, hepPacket)
+		h.inputCh <- hepMsg{data: buf[:len(hepPacket)]}
+		return (<-h.dbCh).NodeName
+	}
+	failures := testutil.ToFloat64(scriptReloads.WithLabelValues("failure"))
+
+	write(`SetHEPField("NodeName", "first")`)
+	h := NewHEPInput()
+	assert.Equal(t, uint64(1), h.scripts.Status().Generation)
+	h.wg.Add(1)
+	go h.worker()
+	defer func() {
+		h.exitWorker <- true
+		<-h.exitWorker
+	}()
+	assert.Equal(t, "first", send(h))
+
+	write(`SetHEPField("NodeName", "second")`)
+	assert.NoError(t, h.scripts.reload())
+	assert.Equal(t, "second", send(h))
+
+	// a broken script keeps the active one
+	set := h.scripts.current.Load()
+	write(`SetHEPField("NodeName", `)
+	assert.Error(t, h.scripts.reload())
+	assert.Equal(t, set, h.scripts.current.Load())
+	assert.Equal(t, "second", send(h))
+	st := h.scripts.Status()
+	assert.True(t, st.Active)
+	assert.Equal(t, uint64(2), st.Generation)
+	assert.NotEmpty(t, st.LastError)
+	assert.Equal(t, failures+1, testutil.ToFloat64(scriptReloads.WithLabelValues("failure")))
+
+	r := httptest.NewRequest(http.MethodGet, httpScriptPath, nil)
+	r.RemoteAddr = "127.0.0.1:40000"
+	w := httptest.NewRecorder()
+	h.handleScripts(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var res scriptStatus
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.Equal(t, st.LastError, res.LastError)
+	assert.Equal(t, dir, res.Folder)
+
+	// fixing the script in the watched folder activates it
+	h.scripts.Start()
+	defer h.scripts.Stop()
+	write(`SetHEPField("NodeName", "third")`)
+	assert.Eventually(t, func() bool { return h.scripts.Status().Generation == 3 }, 5*time.Second, 50*time.Millisecond)
+	assert.Equal(t, "third", send(h))
+	assert.Empty(t, h.scripts.Status().LastError)
+}
@@ -13,24 +13,28 @@ import (
 	"github.com/sipcapture/heplify-server/config"
 	"github.com/sipcapture/heplify-server/database"
 	"github.com/sipcapture/heplify-server/decoder"
+	"github.com/sipcapture/heplify-server/dialog"
 	"github.com/sipcapture/heplify-server/metric"
 	"github.com/sipcapture/heplify-server/remotelog"
 	"github.com/sipcapture/heplify-server/rotator"
 )
 
 type HEPInput struct {
-	inputCh     chan []byte
+	inputCh     chan hepMsg
 	dbCh        chan *decoder.HEP
+	spillCh     chan *decoder.HEP
 	promCh      chan *decoder.HEP
 	esCh        chan *decoder.HEP
 	lokiCh      chan *decoder.HEP
 	lineprotoCh chan *decoder.HEP
+	fwdCh       chan *decoder.HEP
 	wg          *sync.WaitGroup
 	buffer      *sync.Pool
 	exitUDP     chan bool
 	exitTCP     chan bool
 	exitTLS     chan bool
 	exitWS      chan bool
+	exitHTTP    chan bool
 	exitWorker  chan bool
 	quit        chan bool
 	stopped     uint32
@@ -40,6 +44,17 @@ type HEPInput struct {
 	useES       bool
 	useLK       bool
 	useLP       bool
+	useFW       bool
+	replay      bool
+	auth        *nodeAuth
+	tlsStore    *tlsStore
+	limits      *sourceLimits
+	acl         *ingressACL
+	dialogs     *dialog.Tracker
+	bindings    *dialog.Registrar
+	media       *mediaCorrelator
+	scripts     *scriptReloader
+	enricher    *enrichReloader
 }
 
 type HEPStats struct {
@@ -49,12 +64,18 @@ type HEPStats struct {
 	PktCount uint64
 }
 
+// hepMsg is a received packet with the listener it came in on.
+type hepMsg struct {
+	listener string
+	data     []byte
+}
+
 const maxPktLen = 65507
 const minPktLen = 6
 
 func NewHEPInput() *HEPInput {
 	h := &HEPInput{
-		inputCh:    make(chan []byte, 40000),
+		inputCh:    make(chan hepMsg, 40000),
 		buffer:     &sync.Pool{New: func() any { return make([]byte, maxPktLen) }},
 		wg:         &sync.WaitGroup{},
 		quit:       make(chan bool),
@@ -62,11 +83,17 @@ func NewHEPInput() *HEPInput {
 		exitTCP:    make(chan bool),
 		exitTLS:    make(chan bool),
 		exitWS:     make(chan bool),
+		exitHTTP:   make(chan bool),
 		exitWorker: make(chan bool),
+		limits:     newSourceLimits(),
+		acl:        newIngressACL(),
 	}
 	if len(config.Setting.DBAddr) > 2 {
 		h.useDB = true
 		h.dbCh = make(chan *decoder.HEP, config.Setting.DBBuffer)
+		if config.Setting.DBSpoolFolder != "" {
+			h.spillCh = make(chan *decoder.HEP, config.Setting.DBBuffer)
+		}
 	}
 	if len(config.Setting.PromAddr) > 2 {
 		h.usePM = true
@@ -84,6 +111,43 @@ func NewHEPInput() *HEPInput {
 		h.useLP = true
 		h.lineprotoCh = make(chan *decoder.HEP, config.Setting.LineprotoBuffer)
 	}
+	if len(config.Setting.HEPTLSAddr) > 2 || len(config.Setting.HEPWSAddr) > 2 && config.Setting.HEPWSTLS ||
+		len(config.Setting.HEPHTTPAddr) > 2 && config.Setting.HEPHTTPTLS {
+		var err error
+		if h.tlsStore, err = newTLSStore(); err != nil {
+			logp.Err("%v", err)
+		}
+	}
+	if config.Setting.HEPAuthEnable {
+		h.auth = newNodeAuth(config.Setting.HEPAuthNodes)
+	}
+	if len(config.Setting.HEPForwardAddr) > 0 {
+		h.useFW = true
+		h.fwdCh = make(chan *decoder.HEP, max(config.Setting.HEPForwardBuffer, 1))
+	}
+	if config.Setting.CDREnable || h.usePM {
+		h.dialogs = dialog.New(
+			time.Duration(config.Setting.CDRSetupTimeout)*time.Second,
+			time.Duration(config.Setting.CDRIdleTimeout)*time.Second,
+			h.sendCDR,
+		)
+	}
+	if h.usePM || len(config.Setting.HEPHTTPAddr) > 2 {
+		h.bindings = dialog.NewRegistrar(h.sendBinding)
+	}
+	if config.Setting.MediaCorrelate {
+		h.media = newMediaCorrelator(time.Duration(config.Setting.MediaCorrelateTTL) * time.Second)
+	}
+	if config.Setting.EnrichFolder != "" || config.Setting.EnrichGeoIPFile != "" {
+		h.enricher = newEnrichReloader()
+		h.enricher.reload()
+	}
+	if config.Setting.ScriptEnable {
+		decoder.ScriptState().SetLimit(int64(config.Setting.ScriptStateMaxSize) << 20)
+		decoder.ScriptState().SetMetricNamespaces(config.Setting.ScriptStateNamespaces)
+		h.scripts = newScriptReloader(runtime.NumCPU())
+		h.scripts.reload()
+	}
 
 	return h
 }
@@ -97,6 +161,10 @@ func (h *HEPInput) Run() {
 
 	s := config.Setting
 	s.DBPass = "<private>"
+	s.HEPAuthNodes = maskAuthNodes(s.HEPAuthNodes)
+	if s.HEPHTTPToken != "" {
+		s.HEPHTTPToken = "<private>"
+	}
 	logp.Info("start %s with %#v\n", config.Version, s)
 	go h.logStats()
 	go h.reloadWorker()
@@ -113,10 +181,16 @@ func (h *HEPInput) Run() {
 	if len(config.Setting.HEPTLSAddr) > 2 {
 		go h.serveTLS(config.Setting.HEPTLSAddr)
 	}
+	if len(config.Setting.HEPHTTPAddr) > 2 {
+		go h.serveHTTP(config.Setting.HEPHTTPAddr)
+	}
 
 	if h.usePM {
 		m := metric.New("prometheus")
 		m.Chan = h.promCh
+		if h.bindings != nil {
+			m.Bindings = h.bindings.Bindings
+		}
 
 		if err := m.Run(); err != nil {
 			logp.Err("%v", err)
@@ -154,6 +228,16 @@ func (h *HEPInput) Run() {
 		defer lp.End()
 	}
 
+	if h.useFW {
+		f := remotelog.New("hepfwd")
+		f.Chan = h.fwdCh
+
+		if err := f.Run(); err != nil {
+			logp.Err("%v", err)
+		}
+		defer f.End()
+	}
+
 	if h.useDB && config.Setting.DBRotate &&
 		(config.Setting.DBDriver == "mysql" || config.Setting.DBDriver == "postgres") {
 		r := rotator.Setup(h.quit)
@@ -164,6 +248,7 @@ func (h *HEPInput) Run() {
 	if h.useDB {
 		d := database.New(config.Setting.DBDriver)
 		d.Chan = h.dbCh
+		d.Spill = h.spillCh
 
 		if err := d.Run(); err != nil {
 			logp.Err("%v", err)
@@ -171,9 +256,63 @@ func (h *HEPInput) Run() {
 		defer d.End()
 	}
 
+	if h.dialogs != nil {
+		h.dialogs.Start()
+		defer h.dialogs.Stop()
+	}
+	if h.bindings != nil {
+		h.bindings.Start()
+		defer h.bindings.Stop()
+	}
+	if h.media != nil {
+		h.media.Start()
+		defer h.media.Stop()
+	}
+	if h.scripts != nil {
+		h.scripts.Start()
+		defer h.scripts.Stop()
+		decoder.ScriptState().Start()
+		defer decoder.ScriptState().Stop()
+	}
+	if h.enricher != nil {
+		h.enricher.Start()
+		defer h.enricher.Stop()
+	}
+
 	h.wg.Wait()
 }
 
+// Replay runs the pipeline like Run but takes the packets from src instead
+// of the listeners, so the listener addresses should be cleared beforehand.
+// The outputs block rather than drop packets. Replay returns after src is
+// closed and all outputs are flushed.
+func (h *HEPInput) Replay(src <-chan []byte) {
+	h.replay = true
+	done := make(chan struct{})
+	go func() {
+		h.Run()
+		close(done)
+	}()
+
+	for pkt := range src {
+		if len(pkt) < minPktLen || len(pkt) > maxPktLen {
+			atomic.AddUint64(&h.stats.ErrCount, 1)
+			continue
+		}
+		buf := h.buffer.Get().([]byte)
+		n := copy(buf, pkt)
+		h.inputCh <- hepMsg{data: buf[:n]}
+		atomic.AddUint64(&h.stats.PktCount, 1)
+	}
+	// the workers drain inputCh and return, then Run ends the outputs
+	// which flush their pending batches
+	atomic.StoreUint32(&h.stopped, 1)
+	close(h.inputCh)
+	<-done
+	h.quit <- true
+	<-h.quit
+}
+
 func (h *HEPInput) End() {
 	atomic.StoreUint32(&h.stopped, 1)
 
@@ -189,6 +328,9 @@ func (h *HEPInput) End() {
 	if len(config.Setting.HEPTLSAddr) > 2 {
 		<-h.exitTLS
 	}
+	if len(config.Setting.HEPHTTPAddr) > 2 {
+		<-h.exitHTTP
+	}
 
 	h.exitWorker <- true
 	<-h.exitWorker
@@ -202,21 +344,17 @@ func (h *HEPInput) worker() {
 	defer h.wg.Done()
 
 	var ok bool
-	var err error
+	var in hepMsg
 	var script decoder.ScriptEngine
+	var scripts *scriptSet
 	lastWarn := time.Now()
 	msg := h.buffer.Get().([]byte)
-	useScript := config.Setting.ScriptEnable
-
-	if useScript {
-		script, err = decoder.NewScriptEngine()
-		if err != nil {
-			logp.Err("%v, please fix and run killall -HUP heplify-server", err)
-			useScript = false
-		} else {
-			defer script.Close()
+
+	defer func() {
+		if script != nil {
+			script.Close()
 		}
-	}
+	}()
 
 	for {
 		h.buffer.Put(msg[:maxPktLen])
@@ -224,10 +362,11 @@ func (h *HEPInput) worker() {
 		case <-h.exitWorker:
 			h.exitWorker <- true
 			return
-		case msg, ok = <-h.inputCh:
+		case in, ok = <-h.inputCh:
 			if !ok {
 				return
 			}
+			msg = in.data
 			hepPkt, err := decoder.DecodeHEP(msg)
 			if err != nil {
 				atomic.AddUint64(&h.stats.ErrCount, 1)
@@ -236,9 +375,35 @@ func (h *HEPInput) worker() {
 				atomic.AddUint64(&h.stats.DupCount, 1)
 				continue
 			}
+			if h.auth != nil && !h.auth.check(hepPkt) {
+				if time.Since(lastWarn) > 1e9 {
+					logp.Warn("reject HEP packet with invalid NodePW from nodeID %d", hepPkt.NodeID)
+				}
+				lastWarn = time.Now()
+				continue
+			}
+			// a replayed file comes at once from one node and not from
+			// the network, so it is neither rate limited nor filtered
+			if !h.replay && (!h.acl.allowHEP(in.listener, hepPkt) || !h.limits.allowNode(hepPkt.NodeID)) {
+				continue
+			}
 			atomic.AddUint64(&h.stats.HEPCount, 1)
 
-			if useScript {
+			if h.scripts != nil {
+				if set := h.scripts.current.Load(); set != nil && set != scripts {
+					scripts = set
+					if e, err := set.engine(); err != nil {
+						logp.Err("%v, keep previous scripts in worker", err)
+					} else {
+						if script != nil {
+							script.Close()
+						}
+						script = e
+					}
+				}
+			}
+
+			if script != nil {
 				for _, v := range config.Setting.ScriptHEPFilter {
 					if hepPkt.ProtoType == uint32(v) {
 						if err = script.Run(hepPkt); err != nil {
@@ -247,16 +412,27 @@ func (h *HEPInput) worker() {
 						break
 					}
 				}
+				if hepPkt != nil && !countVerdict(hepPkt) {
+					continue
+				}
 				if hepPkt == nil || hepPkt.ProtoType == 1 && hepPkt.SIP == nil {
 					logp.Warn("nil struct after script processing")
 					continue
 				}
 			}
 
-			if h.usePM {
-				select {
-				case h.promCh <- hepPkt:
-				default:
+			if h.media != nil {
+				h.media.Process(hepPkt)
+			}
+			if h.dialogs != nil {
+				h.dialogs.Process(hepPkt)
+			}
+			if h.bindings != nil {
+				h.bindings.Process(hepPkt)
+			}
+
+			if h.usePM && hepPkt.SendTo(decoder.OutputProm) {
+				if !h.send(h.promCh, hepPkt) {
 					if time.Since(lastWarn) > 1e9 {
 						logp.Warn("overflowing metric channel")
 					}
@@ -264,10 +440,8 @@ func (h *HEPInput) worker() {
 				}
 			}
 
-			if h.useDB {
-				select {
-				case h.dbCh <- hepPkt:
-				default:
+			if h.useDB && hepPkt.SendTo(decoder.OutputDB) {
+				if !h.send(h.dbCh, hepPkt) && !h.send(h.spillCh, hepPkt) {
 					if time.Since(lastWarn) > 1e9 {
 						logp.Warn("overflowing db channel, please adjust DBWorker or DBBuffer setting")
 					}
@@ -275,10 +449,8 @@ func (h *HEPInput) worker() {
 				}
 			}
 
-			if h.useES {
-				select {
-				case h.esCh <- hepPkt:
-				default:
+			if h.useES && hepPkt.SendTo(decoder.OutputES) {
+				if !h.send(h.esCh, hepPkt) {
 					if time.Since(lastWarn) > 1e9 {
 						logp.Warn("overflowing elasticsearch channel")
 					}
@@ -286,12 +458,10 @@ func (h *HEPInput) worker() {
 				}
 			}
 
-			if h.useLK {
+			if h.useLK && hepPkt.SendTo(decoder.OutputLoki) {
 				for _, v := range config.Setting.LokiHEPFilter {
 					if hepPkt.ProtoType == uint32(v) {
-						select {
-						case h.lokiCh <- hepPkt:
-						default:
+						if !h.send(h.lokiCh, hepPkt) {
 							if time.Since(lastWarn) > 1e9 {
 								logp.Warn("overflowing loki channel")
 							}
@@ -302,12 +472,10 @@ func (h *HEPInput) worker() {
 				}
 			}
 
-			if h.useLP {
+			if h.useLP && hepPkt.SendTo(decoder.OutputLineproto) {
 				for _, v := range config.Setting.LineprotoHEPFilter {
 					if hepPkt.ProtoType == uint32(v) {
-						select {
-						case h.lineprotoCh <- hepPkt:
-						default:
+						if !h.send(h.lineprotoCh, hepPkt) {
 							if time.Since(lastWarn) > 1e9 {
 								logp.Warn("overflowing lineproto channel")
 							}
@@ -317,10 +485,46 @@ func (h *HEPInput) worker() {
 					}
 				}
 			}
+
+			if h.useFW && hepPkt.SendTo(decoder.OutputForward) {
+				if !h.send(h.fwdCh, hepPkt) {
+					if time.Since(lastWarn) > 1e9 {
+						logp.Warn("overflowing hep forward channel")
+					}
+					lastWarn = time.Now()
+				}
+			}
 		}
 	}
 }
 
+// sendCDR hands a finished call to the metrics and, with CDREnable, to the
+// database, Loki and lineproto outputs.
+func (h *HEPInput) sendCDR(pkt *decoder.HEP) {
+	if h.usePM && !h.send(h.promCh, pkt) {
+		logp.Warn("overflowing metric channel, drop CDR of %s", pkt.SID)
+	}
+	if !config.Setting.CDREnable {
+		return
+	}
+	if h.useDB && !h.send(h.dbCh, pkt) && !h.send(h.spillCh, pkt) {
+		logp.Warn("overflowing db channel, drop CDR of %s", pkt.SID)
+	}
+	if h.useLK && !h.send(h.lokiCh, pkt) {
+		logp.Warn("overflowing loki channel, drop CDR of %s", pkt.SID)
+	}
+	if h.useLP && !h.send(h.lineprotoCh, pkt) {
+		logp.Warn("overflowing lineproto channel, drop CDR of %s", pkt.SID)
+	}
+}
+
+// sendBinding hands a registration change to the metrics.
+func (h *HEPInput) sendBinding(pkt *decoder.HEP) {
+	if h.usePM && !h.send(h.promCh, pkt) {
+		logp.Warn("overflowing metric channel, drop registration of %s", pkt.Binding.AoR)
+	}
+}
+
 func (h *HEPInput) logStats() {
 	ticker := time.NewTicker(5 * time.Minute)
 	defer ticker.Stop()
@@ -353,20 +557,39 @@ func (h *HEPInput) reloadWorker() {
 	for {
 		select {
 		case <-s:
-			logp.Info("reload all worker")
-			h.wg.Add(1)
-
-			h.exitWorker <- true
-			<-h.exitWorker
-
-			for n := 0; n < runtime.NumCPU(); n++ {
-				h.wg.Add(1)
-				go h.worker()
+			logp.Info("reload settings and scripts")
+			if h.auth != nil {
+				h.auth.reload()
+			}
+			if h.tlsStore != nil {
+				h.tlsStore.reload()
+			}
+			h.limits.reload()
+			h.acl.reload()
+			if h.scripts != nil {
+				h.scripts.reload()
+			}
+			if h.enricher != nil {
+				h.enricher.reload()
 			}
-			h.wg.Done()
 		case <-h.quit:
 			h.quit <- true
 			return
 		}
 	}
 }
+
+// send hands the packet to an output. Live traffic is dropped when the
+// output can't keep up, a replay waits for it instead.
+func (h *HEPInput) send(ch chan *decoder.HEP, hepPkt *decoder.HEP) bool {
+	if h.replay && ch != nil {
+		ch <- hepPkt
+		return true
+	}
+	select {
+	case ch <- hepPkt:
+		return true
+	default:
+		return false
+	}
+}
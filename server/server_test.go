@@ -45,20 +45,63 @@ func TestInput(t *testing.T) {
 	if err != nil {
 		t.FailNow()
 	}
-	buf := hi.buffer.Get().([]byte)
+	// hi runs the mock database which reads dbCh as well. TestInput only won
+	// that race while it was the first test of the package, so use an input
+	// with only a worker to be the sole reader.
+	h := NewHEPInput()
+	h.wg.Add(1)
+	go h.worker()
+	defer close(h.inputCh)
+
+	buf := h.buffer.Get().([]byte)
 	copy(buf, hepPacket)
-	hi.inputCh <- buf[:len(hepPacket)]
-	d := <-hi.dbCh
+	h.inputCh <- hepMsg{data: buf[:len(hepPacket)]}
+	d := <-h.dbCh
 	if d == nil || p == nil {
 		t.FailNow()
 	}
 	assert.Equal(t, p.Payload, d.Payload)
 }
 
+func TestSend(t *testing.T) {
+	h := &HEPInput{}
+	p := &decoder.HEP{}
+	ch := make(chan *decoder.HEP, 1)
+	assert.True(t, h.send(ch, p))
+	assert.False(t, h.send(ch, p), "live traffic must not block")
+	assert.False(t, h.send(nil, p))
+
+	h.replay = true
+	go func() { <-ch }()
+	assert.True(t, h.send(ch, p), "replay waits for the output")
+	assert.Len(t, ch, 1)
+	assert.False(t, h.send(nil, p))
+}
+
+func TestReplay(t *testing.T) {
+	orig := config.Setting
+	defer func() { config.Setting = orig }()
+	config.Setting.PromAddr = ""
+	config.Setting.ScriptEnable = false
+	// neither applies to a replayed file
+	config.Setting.HEPRateLimitNode = 1
+	config.Setting.HEPDenyCIDR = []string{"192.168.0.0/16"}
+	config.Setting.HEPEncapDenyCIDR = []string{"192.168.0.0/16"}
+
+	src := make(chan []byte, 3)
+	for i := 0; i < 3; i++ {
+		src <- hepPacket
+	}
+	close(src)
+	h := NewHEPInput()
+	h.Replay(src)
+	assert.Equal(t, uint64(3), h.stats.HEPCount, "all packets are processed when Replay returns")
+}
+
 func BenchmarkInput(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		buf := hi.buffer.Get().([]byte)
 		copy(buf, hepPacket)
-		hi.inputCh <- buf[:len(hepPacket)]
+		hi.inputCh <- hepMsg{data: buf[:len(hepPacket)]}
 	}
 }
@@ -2,14 +2,17 @@ package input
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/binary"
 	"io"
 	"net"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/negbie/logp"
+	"github.com/sipcapture/heplify-server/decoder"
 )
 
 func (h *HEPInput) serveTCP(addr string) {
@@ -49,18 +52,32 @@ func (h *HEPInput) serveTCP(addr string) {
 			}
 			continue
 		}
+		if !h.acl.allowPeer("tcp", addrIP(conn.RemoteAddr())) {
+			logp.Debug("hep", "reject TCP connection from %s by CIDR list", conn.RemoteAddr())
+			conn.Close()
+			continue
+		}
+		release, ok := h.limits.acceptConn(conn)
+		if !ok {
+			logp.Warn("reject TCP connection from %s, too many connections", conn.RemoteAddr())
+			conn.Close()
+			continue
+		}
 		logp.Info("new TCP connection %s -> %s", conn.RemoteAddr(), conn.LocalAddr())
 		wg.Go(func() {
+			defer release()
 			h.handleTCP(conn)
 		})
 	}
 }
 
 func (h *HEPInput) handleTCP(c net.Conn) {
-	h.handleStream(c, "TCP")
+	h.handleStream(c, "TCP", "")
 }
 
-func (h *HEPInput) handleStream(c net.Conn, protocol string) {
+// handleStream reads length prefixed HEP packets from c. A non empty
+// nodeName is written into every packet as NodeName chunk.
+func (h *HEPInput) handleStream(c net.Conn, protocol, nodeName string) {
 	defer func() {
 		logp.Info("closing %s connection from %s", protocol, c.RemoteAddr())
 		err := c.Close()
@@ -69,6 +86,8 @@ func (h *HEPInput) handleStream(c net.Conn, protocol string) {
 		}
 	}()
 
+	ip := remoteIP(c.RemoteAddr())
+	listener := strings.ToLower(protocol)
 	r := bufio.NewReader(c)
 	for {
 		if atomic.LoadUint32(&h.stopped) == 1 {
@@ -94,8 +113,37 @@ func (h *HEPInput) handleStream(c net.Conn, protocol string) {
 				atomic.AddUint64(&h.stats.ErrCount, 1)
 				return
 			}
-			h.inputCh <- buf[:n]
+			if !h.limits.allowIP(ip) {
+				h.buffer.Put(buf[:maxPktLen])
+				continue
+			}
+			pkt := buf[:n]
+			if nodeName != "" {
+				var ok bool
+				if pkt, ok = setNodeName(pkt, nodeName); !ok {
+					logp.Warn("drop packet from %s which can't carry the NodeName %s", c.RemoteAddr(), nodeName)
+					atomic.AddUint64(&h.stats.ErrCount, 1)
+					h.buffer.Put(buf[:maxPktLen])
+					continue
+				}
+			}
+			h.inputCh <- hepMsg{listener, pkt}
 			atomic.AddUint64(&h.stats.PktCount, 1)
 		}
 	}
 }
+
+// setNodeName appends a NodeName chunk to a HEPv3 packet. The decoder keeps
+// the last NodeName chunk, so this overrides whatever the agent did send.
+func setNodeName(pkt []byte, name string) ([]byte, bool) {
+	n := len(pkt) + 6 + len(name)
+	if !bytes.HasPrefix(pkt, []byte{0x48, 0x45, 0x50, 0x33}) || n > maxPktLen || n > cap(pkt) {
+		return pkt, false
+	}
+	pkt = binary.BigEndian.AppendUint16(pkt, 0)
+	pkt = binary.BigEndian.AppendUint16(pkt, decoder.NodeName)
+	pkt = binary.BigEndian.AppendUint16(pkt, uint16(6+len(name)))
+	pkt = append(pkt, name...)
+	binary.BigEndian.PutUint16(pkt[4:6], uint16(len(pkt)))
+	return pkt, true
+}
@@ -2,7 +2,10 @@ package input
 
 import (
 	"crypto/tls"
+	"crypto/x509"
+	"fmt"
 	"net"
+	"os"
 	"path/filepath"
 	"sync"
 	"sync/atomic"
@@ -31,26 +34,93 @@ func parseTLSVersion(versionText string) uint16 {
 	}
 }
 
+// tlsStore holds the listener config. New connections always get the latest
+// loaded config, established connections keep the one they started with.
+type tlsStore struct {
+	conf atomic.Pointer[tls.Config]
+}
+
+func newTLSStore() (*tlsStore, error) {
+	s := &tlsStore{}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *tlsStore) load() error {
+	c := &tls.Config{MinVersion: parseTLSVersion(config.Setting.TLSMinVersion)}
+
+	if config.Setting.TLSCertFile != "" || config.Setting.TLSKeyFile != "" {
+		crt, err := tls.LoadX509KeyPair(config.Setting.TLSCertFile, config.Setting.TLSKeyFile)
+		if err != nil {
+			return err
+		}
+		c.Certificates = []tls.Certificate{crt}
+	} else {
+		// load any existing certs, otherwise generate a new one
+		ca, err := cert.NewCertificateAuthority(filepath.Join(config.Setting.TLSCertFolder, "heplify-server"))
+		if err != nil {
+			return err
+		}
+		c.GetCertificate = ca.GetCertificate
+	}
+
+	if config.Setting.TLSClientCA != "" {
+		pem, err := os.ReadFile(config.Setting.TLSClientCA)
+		if err != nil {
+			return err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in TLSClientCA %s", config.Setting.TLSClientCA)
+		}
+		c.ClientCAs = pool
+		c.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	s.conf.Store(c)
+	return nil
+}
+
+func (s *tlsStore) reload() {
+	if err := s.load(); err != nil {
+		logp.Err("failed to reload TLS certificates, keep the old ones: %v", err)
+		return
+	}
+	logp.Info("successfully reloaded TLS certificates")
+}
+
+// certNodeName returns the agent identity of a verified client certificate.
+func certNodeName(c *x509.Certificate) string {
+	switch {
+	case c.Subject.CommonName != "":
+		return c.Subject.CommonName
+	case len(c.DNSNames) > 0:
+		return c.DNSNames[0]
+	case len(c.URIs) > 0:
+		return c.URIs[0].String()
+	case len(c.IPAddresses) > 0:
+		return c.IPAddresses[0].String()
+	}
+	return ""
+}
+
 func (h *HEPInput) serveTLS(addr string) {
 	defer close(h.exitTLS)
 
-	ta, err := net.ResolveTCPAddr("tcp", addr)
-	if err != nil {
-		logp.Err("%v", err)
+	if h.tlsStore == nil {
+		logp.Err("no valid TLS certificates, TLS listener on %s disabled", addr)
 		return
 	}
 
-	ln, err := net.ListenTCP("tcp", ta)
+	ta, err := net.ResolveTCPAddr("tcp", addr)
 	if err != nil {
 		logp.Err("%v", err)
 		return
 	}
 
-	// get path for certificate/key storage
-	cPath := config.Setting.TLSCertFolder
-	minTLSVersion := parseTLSVersion(config.Setting.TLSMinVersion)
-	// load any existing certs, otherwise generate a new one
-	ca, err := cert.NewCertificateAuthority(filepath.Join(cPath, "heplify-server"))
+	ln, err := net.ListenTCP("tcp", ta)
 	if err != nil {
 		logp.Err("%v", err)
 		return
@@ -78,13 +148,39 @@ func (h *HEPInput) serveTLS(addr string) {
 			}
 			continue
 		}
+		if !h.acl.allowPeer("tls", addrIP(conn.RemoteAddr())) {
+			logp.Debug("hep", "reject TLS connection from %s by CIDR list", conn.RemoteAddr())
+			conn.Close()
+			continue
+		}
+		release, ok := h.limits.acceptConn(conn)
+		if !ok {
+			logp.Warn("reject TLS connection from %s, too many connections", conn.RemoteAddr())
+			conn.Close()
+			continue
+		}
 		logp.Info("new TLS connection %s -> %s", conn.RemoteAddr(), conn.LocalAddr())
+		tc := tls.Server(conn, h.tlsStore.conf.Load())
 		wg.Go(func() {
-			h.handleTLS(tls.Server(conn, &tls.Config{GetCertificate: ca.GetCertificate, MinVersion: minTLSVersion}))
+			defer release()
+			h.handleTLS(tc)
 		})
 	}
 }
 
-func (h *HEPInput) handleTLS(c net.Conn) {
-	h.handleStream(c, "TLS")
+func (h *HEPInput) handleTLS(c *tls.Conn) {
+	c.SetDeadline(time.Now().Add(10 * time.Second))
+	if err := c.Handshake(); err != nil {
+		logp.Warn("TLS handshake with %s failed: %v", c.RemoteAddr(), err)
+		c.Close()
+		return
+	}
+	c.SetDeadline(time.Time{})
+
+	var nodeName string
+	if cs := c.ConnectionState(); len(cs.VerifiedChains) > 0 {
+		nodeName = certNodeName(cs.VerifiedChains[0][0])
+		logp.Info("TLS client %s authenticated as %s", c.RemoteAddr(), nodeName)
+	}
+	h.handleStream(c, "TLS", nodeName)
 }
@@ -1,9 +1,24 @@
 package input
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/tls"
-	"github.com/stretchr/testify/assert"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
+
+	"github.com/sipcapture/heplify-server/config"
+	"github.com/sipcapture/heplify-server/decoder"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestMinVersionConfig_Valid(t *testing.T) {
@@ -48,3 +63,115 @@ func TestMinVersionConfig_Invalid(t *testing.T) {
 		}(t, test.input, test.output)
 	}
 }
+
+func writeTestCert(t *testing.T, dir, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey, isCA bool) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		DNSNames:              []string{name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	if parent == nil {
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	assert.NoError(t, err)
+	kb, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, name+".crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, name+".key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kb}), 0600))
+	c, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+	return c, key
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey := writeTestCert(t, dir, "ca", nil, nil, true)
+	writeTestCert(t, dir, "server", ca, caKey, false)
+	writeTestCert(t, dir, "sbc-core", ca, caKey, false)
+
+	orig := config.Setting
+	defer func() { config.Setting = orig }()
+	config.Setting.TLSCertFile = filepath.Join(dir, "server.crt")
+	config.Setting.TLSKeyFile = filepath.Join(dir, "server.key")
+	config.Setting.TLSClientCA = filepath.Join(dir, "ca.crt")
+
+	s, err := newTLSStore()
+	assert.NoError(t, err)
+
+	clientCert, err := tls.LoadX509KeyPair(filepath.Join(dir, "sbc-core.crt"), filepath.Join(dir, "sbc-core.key"))
+	assert.NoError(t, err)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer ln.Close()
+
+	handshake := func(certs []tls.Certificate) (string, *x509.Certificate, error) {
+		leafCh := make(chan *x509.Certificate, 1)
+		go func() {
+			cc, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{ServerName: "server", RootCAs: roots, Certificates: certs})
+			if err != nil {
+				leafCh <- nil
+				return
+			}
+			leafCh <- cc.ConnectionState().PeerCertificates[0]
+			io.Copy(io.Discard, cc)
+			cc.Close()
+		}()
+		sc, err := ln.Accept()
+		if err != nil {
+			return "", nil, err
+		}
+		defer sc.Close()
+		sc.SetDeadline(time.Now().Add(5 * time.Second))
+		server := tls.Server(sc, s.conf.Load())
+		if err := server.Handshake(); err != nil {
+			return "", nil, err
+		}
+		cs := server.ConnectionState()
+		return certNodeName(cs.VerifiedChains[0][0]), <-leafCh, nil
+	}
+
+	name, leaf, err := handshake([]tls.Certificate{clientCert})
+	assert.NoError(t, err)
+	assert.Equal(t, "sbc-core", name)
+
+	_, _, err = handshake(nil)
+	assert.Error(t, err)
+
+	writeTestCert(t, dir, "server", ca, caKey, false)
+	s.reload()
+	_, newLeaf, err := handshake([]tls.Certificate{clientCert})
+	assert.NoError(t, err)
+	assert.NotEqual(t, leaf.SerialNumber, newLeaf.SerialNumber)
+
+	config.Setting.TLSCertFile = filepath.Join(dir, "missing.crt")
+	s.reload()
+	_, sameLeaf, err := handshake([]tls.Certificate{clientCert})
+	assert.NoError(t, err)
+	assert.Equal(t, newLeaf.SerialNumber, sameLeaf.SerialNumber)
+}
+
+func TestSetNodeName(t *testing.T) {
+	buf := make([]byte, len(hepPacket), maxPktLen)
+	copy(buf, hepPacket)
+	pkt, ok := setNodeName(buf, "sbc-core")
+	assert.True(t, ok)
+	h, err := decoder.DecodeHEP(pkt)
+	assert.NoError(t, err)
+	assert.Equal(t, "sbc-core", h.NodeName)
+
+	_, ok = setNodeName([]byte{0x02, 0x10}, "sbc-core")
+	assert.False(t, ok)
+}
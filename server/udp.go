@@ -34,7 +34,7 @@ func (h *HEPInput) serveUDP(addr string) {
 		}
 		uc.SetReadDeadline(time.Now().Add(1e9))
 		buf := h.buffer.Get().([]byte)
-		n, err := uc.Read(buf)
+		n, ra, err := uc.ReadFromUDP(buf)
 		if err != nil {
 			if opErr, ok := err.(*net.OpError); ok && opErr.Timeout() {
 				continue
@@ -46,8 +46,11 @@ func (h *HEPInput) serveUDP(addr string) {
 			logp.Warn("received too big packet with %d bytes", n)
 			atomic.AddUint64(&h.stats.ErrCount, 1)
 			continue
+		} else if !h.acl.allowPeer("udp", ra.IP) || !h.limits.allowAddr(ra.IP) {
+			h.buffer.Put(buf)
+			continue
 		}
-		h.inputCh <- buf[:n]
+		h.inputCh <- hepMsg{"udp", buf[:n]}
 		atomic.AddUint64(&h.stats.PktCount, 1)
 	}
 }
@@ -0,0 +1,30 @@
+package input
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sipcapture/heplify-server/decoder"
+)
+
+var scriptVerdicts = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "heplify_script_verdict_total",
+	Help: "HEP packets dropped or routed to some outputs by scripts"},
+	[]string{"verdict"})
+
+var (
+	verdictRoute = scriptVerdicts.WithLabelValues("route")
+	verdictDrop  = scriptVerdicts.WithLabelValues("drop")
+)
+
+// countVerdict counts the script verdict of a packet and reports whether
+// it is kept.
+func countVerdict(pkt *decoder.HEP) bool {
+	if pkt.Dropped {
+		verdictDrop.Inc()
+		return false
+	}
+	if pkt.SkipOutputs != 0 {
+		verdictRoute.Inc()
+	}
+	return true
+}
@@ -0,0 +1,21 @@
+package input
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sipcapture/heplify-server/decoder"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCountVerdict(t *testing.T) {
+	drops := testutil.ToFloat64(verdictDrop)
+	routes := testutil.ToFloat64(verdictRoute)
+
+	assert.True(t, countVerdict(&decoder.HEP{}))
+	assert.True(t, countVerdict(&decoder.HEP{SkipOutputs: decoder.OutputES}))
+	assert.False(t, countVerdict(&decoder.HEP{Dropped: true, SkipOutputs: decoder.OutputES}))
+
+	assert.Equal(t, drops+1, testutil.ToFloat64(verdictDrop))
+	assert.Equal(t, routes+1, testutil.ToFloat64(verdictRoute))
+}
@@ -0,0 +1,53 @@
+package input
+
+import (
+	"os"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// watchDir sends the names of files in dir which match and were written,
+// moved or removed until quit is closed.
+func watchDir(dir string, match func(string) bool, quit <-chan struct{}) (<-chan string, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	const mask = syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO | syscall.IN_MOVED_FROM | syscall.IN_DELETE
+	if _, err = syscall.InotifyAddWatch(fd, dir, mask); err != nil {
+		syscall.Close(fd)
+		return nil, os.NewSyscallError("inotify_add_watch", err)
+	}
+	// a nonblocking fd is handled by the runtime poller, so Close
+	// interrupts a pending Read
+	f := os.NewFile(uintptr(fd), "inotify")
+	go func() {
+		<-quit
+		f.Close()
+	}()
+
+	events := make(chan string, 16)
+	go func() {
+		buf := make([]byte, 16*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+		for {
+			n, err := f.Read(buf)
+			if err != nil {
+				return
+			}
+			for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+				ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+				name := strings.TrimRight(string(buf[off+syscall.SizeofInotifyEvent:off+syscall.SizeofInotifyEvent+int(ev.Len)]), "\x00")
+				off += syscall.SizeofInotifyEvent + int(ev.Len)
+				if match(name) {
+					select {
+					case events <- name:
+					case <-quit:
+						return
+					}
+				}
+			}
+		}
+	}()
+	return events, nil
+}
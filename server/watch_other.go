@@ -0,0 +1,71 @@
+//go:build !linux
+
+package input
+
+import (
+	"os"
+	"time"
+)
+
+// watchDir sends the names of files in dir which match and were written,
+// added or removed until quit is closed. Without inotify the folder is
+// polled.
+func watchDir(dir string, match func(string) bool, quit <-chan struct{}) (<-chan string, error) {
+	seen, err := scanDir(dir, match)
+	if err != nil {
+		return nil, err
+	}
+	events := make(chan string, 16)
+	go func() {
+		ticker := time.NewTicker(2 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-quit:
+				return
+			case <-ticker.C:
+			}
+			now, err := scanDir(dir, match)
+			if err != nil {
+				continue
+			}
+			var changed []string
+			for name, mod := range now {
+				if !seen[name].Equal(mod) {
+					changed = append(changed, name)
+				}
+			}
+			for name := range seen {
+				if _, ok := now[name]; !ok {
+					changed = append(changed, name)
+				}
+			}
+			seen = now
+			for _, name := range changed {
+				select {
+				case events <- name:
+				case <-quit:
+					return
+				}
+			}
+		}
+	}()
+	return events, nil
+}
+
+func scanDir(dir string, match func(string) bool) (map[string]time.Time, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]time.Time)
+	for _, e := range entries {
+		if e.IsDir() || !match(e.Name()) {
+			continue
+		}
+		if info, err := e.Info(); err == nil {
+			files[e.Name()] = info.ModTime()
+		}
+	}
+	return files, nil
+}
@@ -1,19 +1,31 @@
 package input
 
 import (
-	"github.com/gobwas/ws"
+	"crypto/tls"
+	"fmt"
 	"io"
 	"net"
+	"net/url"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/gobwas/ws"
 	"github.com/negbie/logp"
+	"github.com/sipcapture/heplify-server/config"
 )
 
+const wsHandshakeTimeout = 10 * time.Second
+
 func (h *HEPInput) serveWS(addr string) {
 	defer close(h.exitWS)
 
+	useTLS := config.Setting.HEPWSTLS
+	if useTLS && h.tlsStore == nil {
+		logp.Err("no valid TLS certificates, WSS listener on %s disabled", addr)
+		return
+	}
+
 	ta, err := net.ResolveTCPAddr("tcp", addr)
 	if err != nil {
 		logp.Err("%v", err)
@@ -48,19 +60,66 @@ func (h *HEPInput) serveWS(addr string) {
 			}
 			continue
 		}
-		_, err = ws.Upgrade(conn)
-		if err != nil {
-			logp.Err("%v", err)
-			return
+		if !h.acl.allowPeer("ws", addrIP(conn.RemoteAddr())) {
+			logp.Debug("hep", "reject WS connection from %s by CIDR list", conn.RemoteAddr())
+			conn.Close()
+			continue
+		}
+		release, ok := h.limits.acceptConn(conn)
+		if !ok {
+			logp.Warn("reject WS connection from %s, too many connections", conn.RemoteAddr())
+			conn.Close()
+			continue
 		}
 
-		logp.Info("new WS connection %s -> %s", conn.RemoteAddr(), conn.LocalAddr())
+		if useTLS {
+			conn = tls.Server(conn, h.tlsStore.conf.Load())
+		}
 		wg.Go(func() {
+			defer release()
 			h.handleWS(conn)
 		})
 	}
 }
 
+// upgradeWS runs the TLS and WebSocket handshakes. It returns the NodeName
+// of a verified client certificate.
+func (h *HEPInput) upgradeWS(c net.Conn) (string, error) {
+	var nodeName string
+
+	c.SetDeadline(time.Now().Add(wsHandshakeTimeout))
+	defer c.SetDeadline(time.Time{})
+
+	if tc, ok := c.(*tls.Conn); ok {
+		if err := tc.Handshake(); err != nil {
+			return "", fmt.Errorf("TLS handshake failed: %v", err)
+		}
+		if cs := tc.ConnectionState(); len(cs.VerifiedChains) > 0 {
+			nodeName = certNodeName(cs.VerifiedChains[0][0])
+		}
+	}
+
+	path := config.Setting.HEPWSPath
+	u := ws.Upgrader{
+		Protocol: func(p []byte) bool {
+			return string(p) == "hep"
+		},
+		OnRequest: func(uri []byte) error {
+			if path == "" {
+				return nil
+			}
+			if ru, err := url.ParseRequestURI(string(uri)); err != nil || ru.Path != path {
+				return ws.RejectConnectionError(ws.RejectionStatus(404), ws.RejectionReason("unknown path "+string(uri)))
+			}
+			return nil
+		},
+	}
+	if _, err := u.Upgrade(c); err != nil {
+		return "", fmt.Errorf("WS handshake failed: %v", err)
+	}
+	return nodeName, nil
+}
+
 func (h *HEPInput) handleWS(c net.Conn) {
 	defer func() {
 		logp.Info("closing WS connection from %s", c.RemoteAddr())
@@ -69,28 +128,128 @@ func (h *HEPInput) handleWS(c net.Conn) {
 			logp.Err("%v", err)
 		}
 	}()
+
+	nodeName, err := h.upgradeWS(c)
+	if err != nil {
+		logp.Warn("%v from %s", err, c.RemoteAddr())
+		return
+	}
+	logp.Info("new WS connection %s -> %s", c.RemoteAddr(), c.LocalAddr())
+
+	var wmu sync.Mutex
+	write := func(f ws.Frame) error {
+		wmu.Lock()
+		defer wmu.Unlock()
+		c.SetWriteDeadline(time.Now().Add(wsHandshakeTimeout))
+		return ws.WriteFrame(c, f)
+	}
+
+	idle := time.Duration(config.Setting.HEPWSIdleTimeout) * time.Second
+	if idle > 0 {
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			t := time.NewTicker(idle / 2)
+			defer t.Stop()
+			for {
+				select {
+				case <-t.C:
+					if err := write(ws.NewPingFrame(nil)); err != nil {
+						return
+					}
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+
+	ip := remoteIP(c.RemoteAddr())
+	buf := h.buffer.Get().([]byte)
+	defer func() {
+		h.buffer.Put(buf)
+	}()
+	n := 0
 	for {
+		if atomic.LoadUint32(&h.stopped) == 1 {
+			return
+		}
+		if idle > 0 {
+			c.SetReadDeadline(time.Now().Add(idle))
+		}
+
 		header, err := ws.ReadHeader(c)
 		if err != nil {
-			logp.Err("%v", err)
+			if err != io.EOF {
+				logp.Warn("%v from %s", err, c.RemoteAddr())
+			}
 			return
 		}
-		buf := h.buffer.Get().([]byte)
-		payload := buf[:header.Length]
-		_, err = io.ReadFull(c, payload)
-		if err != nil {
-			logp.Err("%v", err)
+		if !header.Masked {
+			write(ws.NewCloseFrame(ws.NewCloseFrameBody(ws.StatusProtocolError, "unmasked frame")))
 			return
 		}
 
-		if header.Masked {
-			ws.Cipher(payload, header.Mask, 0)
+		if header.OpCode.IsControl() {
+			if header.Length > 125 {
+				write(ws.NewCloseFrame(ws.NewCloseFrameBody(ws.StatusProtocolError, "control frame too big")))
+				return
+			}
+			p := make([]byte, header.Length)
+			if _, err = io.ReadFull(c, p); err != nil {
+				return
+			}
+			ws.Cipher(p, header.Mask, 0)
+			switch header.OpCode {
+			case ws.OpPing:
+				err = write(ws.NewPongFrame(p))
+			case ws.OpClose:
+				write(ws.NewCloseFrame(ws.NewCloseFrameBody(ws.StatusNormalClosure, "")))
+				err = io.EOF
+			}
+			if err != nil {
+				return
+			}
+			continue
 		}
 
-		if header.OpCode == ws.OpClose {
+		if header.OpCode != ws.OpContinuation {
+			n = 0
+		}
+		if int64(n)+header.Length > maxPktLen {
+			logp.Warn("too big WS message from %s", c.RemoteAddr())
+			atomic.AddUint64(&h.stats.ErrCount, 1)
+			write(ws.NewCloseFrame(ws.NewCloseFrameBody(ws.StatusMessageTooBig, "")))
+			return
+		}
+		payload := buf[n : n+int(header.Length)]
+		if _, err = io.ReadFull(c, payload); err != nil {
+			logp.Warn("%v from %s", err, c.RemoteAddr())
 			return
 		}
-		h.inputCh <- payload
+		ws.Cipher(payload, header.Mask, 0)
+		n += len(payload)
+		if !header.Fin {
+			continue
+		}
+
+		if n < minPktLen || !h.limits.allowIP(ip) {
+			n = 0
+			continue
+		}
+		pkt := buf[:n]
+		if nodeName != "" {
+			var ok bool
+			if pkt, ok = setNodeName(pkt, nodeName); !ok {
+				logp.Warn("drop packet from %s which can't carry the NodeName %s", c.RemoteAddr(), nodeName)
+				atomic.AddUint64(&h.stats.ErrCount, 1)
+				n = 0
+				continue
+			}
+		}
+		h.inputCh <- hepMsg{"ws", pkt}
 		atomic.AddUint64(&h.stats.PktCount, 1)
+		buf = h.buffer.Get().([]byte)
+		n = 0
 	}
 }
@@ -0,0 +1,84 @@
+package input
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+	"github.com/sipcapture/heplify-server/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServeWS(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	addr := ln.Addr().String()
+	ln.Close()
+
+	orig := config.Setting
+	defer func() { config.Setting = orig }()
+	config.Setting.HEPWSPath = "/hep"
+	config.Setting.HEPWSIdleTimeout = 5
+
+	h := NewHEPInput()
+	go h.serveWS(addr)
+	defer func() {
+		atomic.StoreUint32(&h.stopped, 1)
+		<-h.exitWS
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// wait for the listener
+	var conn net.Conn
+	for i := 0; i < 50; i++ {
+		if conn, _, _, err = ws.Dial(ctx, "ws://"+addr+"/wrong"); err == nil {
+			break
+		}
+		if _, ok := err.(ws.StatusError); ok {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	assert.Error(t, err, "handshake with wrong path must fail")
+	if conn != nil {
+		conn.Close()
+	}
+
+	conn, _, _, err = ws.Dial(ctx, "ws://"+addr+"/hep")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+
+	assert.NoError(t, wsutil.WriteClientMessage(conn, ws.OpBinary, hepPacket))
+	select {
+	case msg := <-h.inputCh:
+		assert.Equal(t, hepMsg{"ws", hepPacket}, msg)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no packet from binary frame")
+	}
+
+	// fragmented text message
+	half := len(hepPacket) / 2
+	assert.NoError(t, ws.WriteFrame(conn, ws.MaskFrame(ws.NewFrame(ws.OpText, false, hepPacket[:half]))))
+	assert.NoError(t, ws.WriteFrame(conn, ws.MaskFrame(ws.NewFrame(ws.OpContinuation, true, hepPacket[half:]))))
+	select {
+	case msg := <-h.inputCh:
+		assert.Equal(t, hepMsg{"ws", hepPacket}, msg)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no packet from fragmented text frames")
+	}
+
+	assert.NoError(t, ws.WriteFrame(conn, ws.MaskFrame(ws.NewPingFrame([]byte("hi")))))
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	f, err := ws.ReadFrame(conn)
+	assert.NoError(t, err)
+	assert.Equal(t, ws.OpPong, f.Header.OpCode)
+	assert.Equal(t, []byte("hi"), f.Payload)
+}
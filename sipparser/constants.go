@@ -56,6 +56,7 @@ const (
 	SIP_HDR_DATE                          = "date"
 	SIP_HDR_ERROR_INFO                    = "error-info"
 	SIP_HDR_EVENT                         = "event"
+	SIP_HDR_EVENT_CMP                     = "o" // RFC 6665
 	SIP_HDR_EXPIRES                       = "expires"
 	SIP_HDR_FLOW_TIMER                    = "flow-timer"
 	SIP_HDR_FROM                          = "from"
@@ -85,6 +86,7 @@ const (
 	SIP_HDR_RECORD_ROUTE                  = "record-route"
 	SIP_HDR_REFER_SUB                     = "refer-sub"                     // RFC4488
 	SIP_HDR_REFER_TO                      = "refer-to"                      // RFC 3515, RFC 4508
+	SIP_HDR_REFER_TO_CMP                  = "r"                             // RFC 3515
 	SIP_HDR_REFERRED_BY                   = "referred-by"                   // RFC3892
 	SIP_HDR_REFERRED_BY_CMP               = "b"                             // RFC3892
 	SIP_HDR_REJECT_CONTACT                = "reject-contact"                // RFC3841
@@ -94,6 +96,7 @@ const (
 	SIP_HDR_REPLACES                      = "replaces"                      // RFC3891
 	SIP_HDR_REPLY_TO                      = "reply-to"                      // RFC3261
 	SIP_HDR_REQUEST_DISPOSITION           = "request-disposition"           // RFC3841
+	SIP_HDR_REQUEST_DISPOSITION_CMP       = "d"                             // RFC3841
 	SIP_HDR_REQUIRE                       = "require"                       // RFC3261
 	SIP_HDR_RESOURCE_PRIORITY             = "resource-priority"             // RFC4412
 	SIP_HDR_RETRY_AFTER                   = "retry-after"                   // RFC3261
@@ -151,3 +154,36 @@ const (
 	SIP_HDR_X_RTP_STAT     = "x-rtp-stat"
 	SIP_HDR_X_RTP_STAT_ADD = "x-rtp-stat-add"
 )
+
+// compactForms maps the header names to their compact form
+var compactForms = map[string]string{
+	SIP_HDR_ACCEPT_CONTACT:      SIP_HDR_ACCEPT_CONTACT_CMP,
+	SIP_HDR_ALLOW_EVENTS:        SIP_HDR_ALLOW_EVENTS_CMP,
+	SIP_HDR_CALL_ID:             SIP_HDR_CALL_ID_CMP,
+	SIP_HDR_CONTACT:             SIP_HDR_CONTACT_CMP,
+	SIP_HDR_CONTENT_ENCODING:    SIP_HDR_CONTENT_ENCODING_CMP,
+	SIP_HDR_CONTENT_LENGTH:      SIP_HDR_CONTENT_LENGTH_CMP,
+	SIP_HDR_CONTENT_TYPE:        SIP_HDR_CONTENT_TYPE_CMP,
+	SIP_HDR_EVENT:               SIP_HDR_EVENT_CMP,
+	SIP_HDR_FROM:                SIP_HDR_FROM_CMP,
+	SIP_HDR_IDENTITY:            SIP_HDR_IDENTITY_CMP,
+	SIP_HDR_IDENTITY_INFO:       SIP_HDR_IDENTITY_INFO_CMP,
+	SIP_HDR_REFER_TO:            SIP_HDR_REFER_TO_CMP,
+	SIP_HDR_REFERRED_BY:         SIP_HDR_REFERRED_BY_CMP,
+	SIP_HDR_REJECT_CONTACT:      SIP_HDR_REJECT_CONTACT_CMP,
+	SIP_HDR_REQUEST_DISPOSITION: SIP_HDR_REQUEST_DISPOSITION_CMP,
+	SIP_HDR_SESSION_EXPIRES:     SIP_HDR_SESSION_EXPIRES_CMP,
+	SIP_HDR_SUBJECT:             SIP_HDR_SUBJECT_CMP,
+	SIP_HDR_SUPPORTED:           SIP_HDR_SUPPORTED_CMP,
+	SIP_HDR_TO:                  SIP_HDR_TO_CMP,
+	SIP_HDR_VIA:                 SIP_HDR_VIA_CMP,
+}
+
+// longForms maps the compact forms back to the header names
+var longForms = func() map[string]string {
+	m := make(map[string]string, len(compactForms))
+	for long, compact := range compactForms {
+		m[compact] = long
+	}
+	return m
+}()
@@ -8,7 +8,9 @@ package sipparser
 import (
 	"errors"
 	"fmt"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/sipcapture/heplify-server/config"
 )
@@ -90,6 +92,7 @@ type SipMsg struct {
 	PAssertedId      *PAssertedId
 	UserAgent        string
 	Server           string
+	URI              *URI
 	URIHost          string
 	URIRaw           string
 	URIUser          string
@@ -100,31 +103,35 @@ type SipMsg struct {
 	eof              int
 	hdr              string
 	hdrv             string
+	allParsed        bool
+	sdpOnce          sync.Once
+	sdp              *SDP
+	// The following headers are only filled by ParseAllHeaders
+	Via                []*Via
+	Allow              []string
+	AllowEvents        []string
+	ContentDisposition *ContentDisposition
+	ProxyAuthenticate  *Authorization
+	Rack               *Rack
+	Rseq               string
+	RseqInt            int
+	RecordRoute        []*URI
+	Route              []*URI
+	Require            []string
+	Unsupported        []string
+	Subject            string
+	Supported          []string
+	Warning            []*Warning
+	WWWAuthenticate    *Authorization
 	//Reason           *Reason
-	//Via                []*Via
 	//StartLine          *StartLine
 	//Headers            []*Header
 	//Accept             *Accept
 	//AlertInfo          string
-	//Allow              []string
-	//AllowEvents        []string
-	//ContentDisposition *ContentDisposition
 	//ContentLengthInt   int
 	//MaxForwardsInt     int
-	//ProxyAuthenticate  *Authorization
 	//ProxyRequire       []string
-	//Rack               *Rack
-	//Rseq               string
-	//RseqInt            int
-	//RecordRoute        []*URI
 	//RTPStat            *RTPStat
-	//Route              []*URI
-	//Require            []string
-	//Unsupported        []string
-	//Subject            string
-	//Supported          []string
-	//Warning            *Warning
-	//WWWAuthenticate    *Authorization
 }
 
 func (s *SipMsg) run() {
@@ -288,6 +295,155 @@ func (s *SipMsg) addHdr(str string) {
 	}
 }
 
+// SDP returns the SDP body, also out of a multipart body, or nil. ParseMsg
+// doesn't parse it, that happens on the first call. SDP is safe for
+// concurrent use.
+func (s *SipMsg) SDP() *SDP {
+	s.sdpOnce.Do(func() {
+		if s.Body == "" {
+			return
+		}
+		if body := sdpBody(s.ContentType, s.Body); body != "" {
+			s.sdp = ParseSDP(body)
+		}
+	})
+	return s.sdp
+}
+
+// ParseAllHeaders parses the Via chain, Route, Record-Route, Require,
+// Supported, Unsupported, Allow, Allow-Events, RAck, RSeq, Warning,
+// WWW-Authenticate, Proxy-Authenticate, Subject and Content-Disposition
+// headers which ParseMsg skips. It returns the first parse error but
+// keeps the headers it could parse. Later calls do nothing.
+func (s *SipMsg) ParseAllHeaders() error {
+	if s.allParsed {
+		return nil
+	}
+	s.allParsed = true
+
+	var err error
+	keep := func(e error) {
+		if err == nil {
+			err = e
+		}
+	}
+	s.eachHeader(func(name, val string) {
+		switch {
+		case strings.EqualFold(name, "Via") || name == "v" || name == "V":
+			keep(s.parseVias(val))
+		case strings.EqualFold(name, "Route"):
+			keep(s.parseRoute(val))
+		case strings.EqualFold(name, "Record-Route"):
+			keep(s.parseRecordRoute(val))
+		case strings.EqualFold(name, "Require"):
+			s.parseRequire(val)
+		case strings.EqualFold(name, "Supported") || name == "k" || name == "K":
+			s.parseSupported(val)
+		case strings.EqualFold(name, "Unsupported"):
+			s.parseUnsupported(val)
+		case strings.EqualFold(name, "Allow"):
+			s.parseAllow(val)
+		case strings.EqualFold(name, "Allow-Events") || name == "u" || name == "U":
+			s.parseAllowEvents(val)
+		case strings.EqualFold(name, "RAck"):
+			keep(s.parseRack(val))
+		case strings.EqualFold(name, "RSeq"):
+			s.Rseq = val
+			s.RseqInt, _ = strconv.Atoi(val)
+		case strings.EqualFold(name, "Warning"):
+			keep(s.parseWarning(val))
+		case strings.EqualFold(name, "WWW-Authenticate"):
+			keep(s.parseWWWAuthenticate(val))
+		case strings.EqualFold(name, "Proxy-Authenticate"):
+			keep(s.parseProxyAuthenticate(val))
+		case strings.EqualFold(name, "Subject") || name == "s" || name == "S":
+			s.Subject = val
+		case strings.EqualFold(name, "Content-Disposition"):
+			s.parseContentDisposition(val)
+		}
+	})
+	return err
+}
+
+// eachHeader calls fn with the name and value of every header line.
+// Folded lines are joined.
+func (s *SipMsg) eachHeader(fn func(name, val string)) {
+	if s.eof < 0 || s.eof > len(s.Msg) {
+		return
+	}
+	lines := strings.Split(s.Msg[:s.eof], "\n")
+	for i := 1; i < len(lines); i++ {
+		line := strings.TrimRight(lines[i], "\r")
+		for i+1 < len(lines) && len(lines[i+1]) > 0 && (lines[i+1][0] == ' ' || lines[i+1][0] == '\t') {
+			i++
+			line += " " + cleanWs(strings.TrimRight(lines[i], "\r"))
+		}
+		sp := strings.IndexByte(line, ':')
+		if sp < 1 {
+			continue
+		}
+		fn(cleanWs(line[:sp]), cleanWs(line[sp+1:]))
+	}
+}
+
+// GetHeaders returns the values of all header lines with the given
+// name. Names match case-insensitive and also in compact form.
+func (s *SipMsg) GetHeaders(name string) []string {
+	alias := compactForms[strings.ToLower(name)]
+	if alias == "" {
+		alias = longForms[strings.ToLower(name)]
+	}
+	var vals []string
+	s.eachHeader(func(n, v string) {
+		if strings.EqualFold(n, name) || alias != "" && strings.EqualFold(n, alias) {
+			vals = append(vals, v)
+		}
+	})
+	return vals
+}
+
+// GetHeader returns the value of the first header line with the given
+// name like GetHeaders.
+func (s *SipMsg) GetHeader(name string) string {
+	if vals := s.GetHeaders(name); len(vals) > 0 {
+		return vals[0]
+	}
+	return ""
+}
+
+// GetURI returns the parsed uri of the request line (ruri), From, To,
+// Contact, P-Asserted-Identity (pai) or Remote-Party-ID (rpid) header.
+func (s *SipMsg) GetURI(name string) *URI {
+	var f *From
+	switch strings.ToLower(name) {
+	case "ruri":
+		return s.URI
+	case "from":
+		f = s.From
+	case "to":
+		f = s.To
+	case "contact":
+		f = s.Contact
+	case "pai":
+		if s.PAssertedId != nil {
+			return s.PAssertedId.URI
+		}
+	case "rpid":
+		if s.RemotePartyId == nil && s.RemotePartyIdVal != "" {
+			rpid := &RemotePartyId{Val: s.RemotePartyIdVal}
+			rpid.parse()
+			return rpid.URI
+		}
+		if s.RemotePartyId != nil {
+			return s.RemotePartyId.URI
+		}
+	}
+	if f == nil {
+		return nil
+	}
+	return f.URI
+}
+
 func GetSIPHeaderVal(header string, data string) (val string) {
 	l := len(header)
 	if startPos := strings.Index(data, header); startPos > -1 {
@@ -374,19 +530,13 @@ func (s *SipMsg) getCallingPartyRpid() error {
 	s.Accept.parse()
 } */
 
-/* func (s *SipMsg) parseAllow(str string) {
-	s.Allow = getCommaSeperated(str)
-	if s.Allow == nil {
-		s.Allow = []string{str}
-	}
-} */
+func (s *SipMsg) parseAllow(str string) {
+	s.Allow = append(s.Allow, getList(str)...)
+}
 
-/* func (s *SipMsg) parseAllowEvents(str string) {
-	s.AllowEvents = getCommaSeperated(str)
-	if s.AllowEvents == nil {
-		s.Allow = []string{str}
-	}
-} */
+func (s *SipMsg) parseAllowEvents(str string) {
+	s.AllowEvents = append(s.AllowEvents, getList(str)...)
+}
 
 func (s *SipMsg) parseAuthorization(str string) {
 	s.Authorization = &Authorization{Val: str}
@@ -411,10 +561,10 @@ func (s *SipMsg) ParseContact(str string) {
 	s.parseContact(str)
 }
 
-/* func (s *SipMsg) parseContentDisposition(str string) {
+func (s *SipMsg) parseContentDisposition(str string) {
 	s.ContentDisposition = &ContentDisposition{Val: str}
 	s.ContentDisposition.parse()
-} */
+}
 
 func (s *SipMsg) parseCseq(str string) {
 	s.Cseq = &Cseq{Val: str}
@@ -453,15 +603,15 @@ func (s *SipMsg) ParsePAssertedId(str string) {
 	s.parsePAssertedId(str)
 }
 
-/* func (s *SipMsg) parseProxyAuthenticate(str string) {
+func (s *SipMsg) parseProxyAuthenticate(str string) error {
 	s.ProxyAuthenticate = &Authorization{Val: str}
-	s.Error = s.ProxyAuthenticate.parse()
-} */
+	return s.ProxyAuthenticate.parse()
+}
 
-/* func (s *SipMsg) parseRack(str string) {
+func (s *SipMsg) parseRack(str string) error {
 	s.Rack = &Rack{Val: str}
-	s.Error = s.Rack.parse()
-} */
+	return s.Rack.parse()
+}
 
 /* func (s *SipMsg) parseReason(str string) {
 	s.Reason = &Reason{Val: str}
@@ -472,33 +622,14 @@ func (s *SipMsg) parseRTPStat(str string) {
 	s.RTPStatVal = str
 }
 
-/* func (s *SipMsg) parseRecordRoute(str string) {
-	cs := []string{str}
-	for rt := range cs {
-		left := 0
-		right := 0
-		for i := range cs[rt] {
-			if cs[rt][i] == '<' && left == 0 {
-				left = i
-			}
-			if cs[rt][i] == '>' && right == 0 {
-				right = i
-			}
-		}
-		if left < right {
-			u := ParseURI(cs[rt][left+1 : right])
-			if u.Error != nil {
-				s.Error = fmt.Errorf("parseRecordRoute err: received err parsing uri: %v", u.Error)
-				return
-			}
-			if s.RecordRoute == nil {
-				s.RecordRoute = []*URI{u}
-			}
-			s.RecordRoute = append(s.RecordRoute, u)
-		}
+func (s *SipMsg) parseRecordRoute(str string) error {
+	uris, err := getRouteURIs(str)
+	if err != nil {
+		return fmt.Errorf("parseRecordRoute err: received err parsing uri: %v", err)
 	}
-	return
-} */
+	s.RecordRoute = append(s.RecordRoute, uris...)
+	return nil
+}
 
 func (s *SipMsg) parseRemotePartyId(str string) {
 	s.RemotePartyId = &RemotePartyId{Val: str}
@@ -512,39 +643,18 @@ func (s *SipMsg) ParseRemotePartyId(str string) {
 	s.parseRemotePartyId(str)
 }
 
-/* func (s *SipMsg) parseRequire(str string) {
-	s.Require = getCommaSeperated(str)
-	if s.Require == nil {
-		s.Require = []string{str}
-	}
-} */
+func (s *SipMsg) parseRequire(str string) {
+	s.Require = append(s.Require, getList(str)...)
+}
 
-/* func (s *SipMsg) parseRoute(str string) {
-	cs := getCommaSeperated(str)
-	for rt := range cs {
-		left := 0
-		right := 0
-		for i := range cs[rt] {
-			if cs[rt][i] == '<' && left == 0 {
-				left = i
-			}
-			if cs[rt][i] == '>' && right == 0 {
-				right = i
-			}
-		}
-		if left < right {
-			u := ParseURI(cs[rt][left+1 : right])
-			if u.Error != nil {
-				s.Error = fmt.Errorf("parseRoute err: received err parsing uri: %v", u.Error)
-				return
-			}
-			if s.Route == nil {
-				s.Route = []*URI{u}
-			}
-			s.Route = append(s.Route, u)
-		}
+func (s *SipMsg) parseRoute(str string) error {
+	uris, err := getRouteURIs(str)
+	if err != nil {
+		return fmt.Errorf("parseRoute err: received err parsing uri: %v", err)
 	}
-} */
+	s.Route = append(s.Route, uris...)
+	return nil
+}
 
 func (s *SipMsg) parseStartLine(str string) {
 	s.State = sipParseStateStartLine
@@ -553,6 +663,7 @@ func (s *SipMsg) parseStartLine(str string) {
 	s.FirstResp = sLine.Resp
 	s.FirstRespText = sLine.RespText
 	if sLine.URI != nil {
+		s.URI = sLine.URI
 		s.URIHost = sLine.URI.Host
 		s.URIRaw = sLine.URI.Raw
 		s.URIUser = sLine.URI.User
@@ -562,12 +673,9 @@ func (s *SipMsg) parseStartLine(str string) {
 	}
 }
 
-/* func (s *SipMsg) parseSupported(str string) {
-	s.Supported = getCommaSeperated(str)
-	if s.Supported == nil {
-		s.Supported = []string{str}
-	}
-} */
+func (s *SipMsg) parseSupported(str string) {
+	s.Supported = append(s.Supported, getList(str)...)
+}
 
 func (s *SipMsg) parseTo(str string) {
 	s.To = getFrom(str)
@@ -580,24 +688,21 @@ func (s *SipMsg) parseTo(str string) {
 	}
 }
 
-/* func (s *SipMsg) parseUnsupported(str string) {
-	s.Unsupported = getCommaSeperated(str)
-	if s.Unsupported == nil {
-		s.Unsupported = []string{str}
+func (s *SipMsg) parseUnsupported(str string) {
+	s.Unsupported = append(s.Unsupported, getList(str)...)
+}
+
+func (s *SipMsg) parseVias(str string) error {
+	vs := &vias{via: str}
+	vs.parse()
+	if vs.err != nil {
+		return vs.err
 	}
-} */
+	s.Via = append(s.Via, vs.vias...)
+	return nil
+}
 
 func (s *SipMsg) parseVia(str string) {
-	/* 	vs := &vias{via: str}
-	   	vs.parse()
-	   	if vs.err != nil {
-	   		s.Error = vs.err
-	   		return
-	   	}
-	   	for _, v := range vs.vias {
-	   		s.Via = append(s.Via, v)
-	   	}
-	*/
 	s.ViaOne = str
 	if a := strings.Index(str, "branch="); a > -1 && a < len(str) {
 		b := str[a:]
@@ -610,15 +715,21 @@ func (s *SipMsg) parseVia(str string) {
 	}
 }
 
-/* func (s *SipMsg) parseWarning(str string) {
-	s.Warning = &Warning{Val: str}
-	s.Error = s.Warning.parse()
-} */
+func (s *SipMsg) parseWarning(str string) error {
+	for _, v := range getList(str) {
+		w := &Warning{Val: v}
+		if err := w.parse(); err != nil {
+			return err
+		}
+		s.Warning = append(s.Warning, w)
+	}
+	return nil
+}
 
-/* func (s *SipMsg) parseWWWAuthenticate(str string) {
+func (s *SipMsg) parseWWWAuthenticate(str string) error {
 	s.WWWAuthenticate = &Authorization{Val: str}
-	s.Error = s.WWWAuthenticate.parse()
-} */
+	return s.WWWAuthenticate.parse()
+}
 
 func getHeaders(s *SipMsg) sipParserStateFn {
 	s.State = sipParseStateHeaders
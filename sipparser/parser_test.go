@@ -271,3 +271,90 @@ func BenchmarkParseMsgParallel(b *testing.B) {
 		}
 	})
 }
+
+func TestParseAllHeaders(t *testing.T) {
+	m := "SIP/2.0 183 Session Progress\r\nVia: SIP/2.0/UDP 10.0.0.1:5060;branch=z9hG4bK1, SIP/2.0/TCP 10.0.0.2:5060;branch=z9hG4bK2\r\nv: SIP/2.0/UDP 10.0.0.3;branch=z9hG4bK3\r\n" +
+		"Record-Route: <sip:10.0.0.2;lr>, \"proxy, one\" <sip:10.0.0.1;lr>\r\nRecord-Route: <sip:10.0.0.9;lr>\r\nFrom: <sip:alice@example.com>;tag=a\r\nTo: <sip:bob@example.com>;tag=b\r\nCall-ID: all@example.com\r\nCSeq: 1 INVITE\r\n" +
+		"Require: 100rel\r\nk: timer,\r\n replaces\r\nAllow: INVITE, ACK, BYE\r\nRSeq: 776656\r\nWarning: 399 sbc.example.com \"Media anchored, codec removed\", 370 pbx \"Insufficient bandwidth\"\r\n" +
+		"WWW-Authenticate: Digest realm=\"example.com\", nonce=\"abc\"\r\nSubject: test\r\nContent-Disposition: session; handling=required\r\nContent-Length: 0\r\n\r\n"
+	s := ParseMsg(m, nil, nil)
+	if s.Error != nil {
+		t.Fatalf("[TestParseAllHeaders] Error parsing msg. Received: %v", s.Error)
+	}
+	if s.Via != nil || s.RecordRoute != nil {
+		t.Errorf("[TestParseAllHeaders] Error parsing msg. Via and RecordRoute should only be filled by ParseAllHeaders.")
+	}
+	if err := s.ParseAllHeaders(); err != nil {
+		t.Errorf("[TestParseAllHeaders] Error parsing all headers. Received: %v", err)
+	}
+	if len(s.Via) != 3 || s.Via[1].Transport != "TCP" || s.Via[2].Branch != "z9hG4bK3" {
+		t.Errorf("[TestParseAllHeaders] Error parsing via chain. Should have 3 vias, the second TCP. Received: %d", len(s.Via))
+	}
+	if len(s.RecordRoute) != 3 || s.RecordRoute[1].Host != "10.0.0.1" || s.RecordRoute[2].Host != "10.0.0.9" {
+		t.Errorf("[TestParseAllHeaders] Error parsing record-route. Should have 3 uris. Received: %d", len(s.RecordRoute))
+	}
+	if len(s.Require) != 1 || s.Require[0] != "100rel" {
+		t.Errorf("[TestParseAllHeaders] Error parsing require. Should be [100rel] but received: %v", s.Require)
+	}
+	if len(s.Supported) != 2 || s.Supported[1] != "replaces" {
+		t.Errorf("[TestParseAllHeaders] Error parsing folded compact supported. Should be [timer replaces] but received: %v", s.Supported)
+	}
+	if len(s.Allow) != 3 || s.Allow[2] != "BYE" {
+		t.Errorf("[TestParseAllHeaders] Error parsing allow. Should be [INVITE ACK BYE] but received: %v", s.Allow)
+	}
+	if s.Rseq != "776656" || s.RseqInt != 776656 {
+		t.Errorf("[TestParseAllHeaders] Error parsing rseq. Should be 776656 but received: %s", s.Rseq)
+	}
+	if len(s.Warning) != 2 || s.Warning[0].CodeInt != 399 || s.Warning[0].Text != "Media anchored, codec removed" || s.Warning[1].Agent != "pbx" {
+		t.Errorf("[TestParseAllHeaders] Error parsing warnings. Should have codes 399 and 370. Received: %d", len(s.Warning))
+	}
+	if s.WWWAuthenticate == nil || s.WWWAuthenticate.Credentials != "Digest" {
+		t.Errorf("[TestParseAllHeaders] Error parsing www-authenticate. Credentials should be \"Digest\".")
+	}
+	if s.Subject != "test" {
+		t.Errorf("[TestParseAllHeaders] Error parsing subject. Should be \"test\" but received: %s", s.Subject)
+	}
+	if s.ContentDisposition == nil || s.ContentDisposition.DispType != "session" {
+		t.Errorf("[TestParseAllHeaders] Error parsing content-disposition. DispType should be \"session\".")
+	}
+
+	// a second call does not add the headers again
+	s.ParseAllHeaders()
+	if len(s.Via) != 3 {
+		t.Errorf("[TestParseAllHeaders] Error parsing all headers twice. Should have 3 vias but received: %d", len(s.Via))
+	}
+}
+
+func TestGetHeaders(t *testing.T) {
+	m := "INVITE sip:bob@example.com;transport=tcp;lr SIP/2.0\r\nv: SIP/2.0/UDP 10.0.0.1;branch=z9hG4bK1\r\nVia: SIP/2.0/UDP 10.0.0.2;branch=z9hG4bK2\r\n" +
+		"From: <sip:alice@example.com;user=phone>;tag=a\r\nt: <sip:bob@example.com>\r\ni: headers@example.com\r\nCSeq: 1 INVITE\r\nx-custom-id: 42\r\n" +
+		"P-Asserted-Identity: <sip:+15551000@example.com;user=phone>\r\nContent-Type: text/plain\r\nContent-Length: 4\r\n\r\ntest"
+	s := ParseMsg(m, nil, nil)
+	if s.Error != nil {
+		t.Fatalf("[TestGetHeaders] Error parsing msg. Received: %v", s.Error)
+	}
+	if v := s.GetHeaders("VIA"); len(v) != 2 || v[0] != "SIP/2.0/UDP 10.0.0.1;branch=z9hG4bK1" || v[1] != "SIP/2.0/UDP 10.0.0.2;branch=z9hG4bK2" {
+		t.Errorf("[TestGetHeaders] Error getting compact and long via. Should have 2 values but received: %v", v)
+	}
+	if v := s.GetHeaders("v"); len(v) != 2 {
+		t.Errorf("[TestGetHeaders] Error getting via by compact name. Should have 2 values but received: %v", v)
+	}
+	if v := s.GetHeader("Call-ID"); v != "headers@example.com" {
+		t.Errorf("[TestGetHeaders] Error getting compact call-id. Should be \"headers@example.com\" but received: %s", v)
+	}
+	if v := s.GetHeader("X-Custom-ID"); v != "42" {
+		t.Errorf("[TestGetHeaders] Error getting custom header. Should be \"42\" but received: %s", v)
+	}
+	if v := s.GetHeaders("Subject"); v != nil {
+		t.Errorf("[TestGetHeaders] Error getting missing header. Should be nil but received: %v", v)
+	}
+	if u := s.GetURI("ruri"); u == nil || u.Host != "example.com" {
+		t.Errorf("[TestGetHeaders] Error getting request uri. Host should be \"example.com\".")
+	}
+	if u := s.GetURI("PAI"); u == nil || u.User != "+15551000" {
+		t.Errorf("[TestGetHeaders] Error getting p-asserted-identity uri. User should be \"+15551000\".")
+	}
+	if u := s.GetURI("record-route"); u != nil {
+		t.Errorf("[TestGetHeaders] Error getting unsupported uri. Should be nil.")
+	}
+}
@@ -6,13 +6,15 @@
 package sipparser
 
 // Imports from the go standard library
+import (
+	"testing"
+)
 
-/* func TestRack(t *testing.T) {
+func TestRack(t *testing.T) {
 	sm := &SipMsg{}
 	s := "776656 1 INVITE"
-	sm.parseRack(s)
-	if sm.Error != nil {
-		t.Errorf("[TestRack] Error parsing rack hdr: 776656 1 INVITE.  Received err: %v", sm.Error)
+	if err := sm.parseRack(s); err != nil {
+		t.Errorf("[TestRack] Error parsing rack hdr: 776656 1 INVITE.  Received err: %v", err)
 	}
 	if sm.Rack.RseqVal != "776656" {
 		t.Errorf("[TestRack] Error parsing rack hdr: 776656 1 INVITE.  RseqVal should be 776656 but received: %v", sm.Rack.RseqVal)
@@ -24,4 +26,3 @@ package sipparser
 		t.Errorf("[TestRack] Error parsing rack hdr: 776656 1 INVITE.  CseqMethod should be \"INVITE\" but received: \"%s\"", sm.Rack.CseqMethod)
 	}
 }
-*/
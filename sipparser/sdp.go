@@ -0,0 +1,395 @@
+// Copyright 2011, Shelby Ramsey. All rights reserved.
+// Copyright 2018, Eugen Biegler. All rights reserved.
+// Use of this code is governed by a BSD license that can be
+// found in the LICENSE.txt file.
+
+package sipparser
+
+// Imports from the go standard library
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// staticPayloads are the RTP payload types of RFC 3551 which may be
+// used without a rtpmap attribute.
+var staticPayloads = map[int]SDPCodec{
+	0:  {Name: "PCMU", ClockRate: 8000, Channels: 1},
+	3:  {Name: "GSM", ClockRate: 8000, Channels: 1},
+	4:  {Name: "G723", ClockRate: 8000, Channels: 1},
+	8:  {Name: "PCMA", ClockRate: 8000, Channels: 1},
+	9:  {Name: "G722", ClockRate: 8000, Channels: 1},
+	13: {Name: "CN", ClockRate: 8000, Channels: 1},
+	18: {Name: "G729", ClockRate: 8000, Channels: 1},
+	26: {Name: "JPEG", ClockRate: 90000},
+	31: {Name: "H261", ClockRate: 90000},
+	34: {Name: "H263", ClockRate: 90000},
+}
+
+// SDP is a struct that holds a parsed session description
+// Fields are as follows:
+// -- Val is the raw body
+// -- Origin is the o= line
+// -- Session is the session name of the s= line
+// -- Connection is the session level c= line
+// -- Direction is the session level direction attribute
+// -- Fingerprint is the session level DTLS fingerprint
+// -- Media are the m= sections
+type SDP struct {
+	Val         string
+	Origin      *SDPOrigin
+	Session     string
+	Connection  *SDPConnection
+	Direction   string
+	Fingerprint string
+	Media       []*SDPMedia
+	Error       error
+}
+
+// SDPOrigin holds the o= line
+type SDPOrigin struct {
+	Username       string
+	SessionID      string
+	SessionVersion string
+	NetType        string
+	AddrType       string
+	Address        string
+}
+
+// SDPConnection holds a c= line
+type SDPConnection struct {
+	NetType  string
+	AddrType string
+	Address  string
+}
+
+// SDPMedia holds one m= section. Connection and Direction fall back
+// to the session level values.
+type SDPMedia struct {
+	Type        string
+	Port        int
+	Proto       string
+	Formats     []string
+	Connection  *SDPConnection
+	RTCPPort    int
+	Direction   string
+	Codecs      []*SDPCodec
+	Candidates  []*SDPCandidate
+	Crypto      []*SDPCrypto
+	Fingerprint string
+}
+
+// SDPCodec holds a payload type with its rtpmap and fmtp attributes
+type SDPCodec struct {
+	PayloadType int
+	Name        string
+	ClockRate   int
+	Channels    int
+	Fmtp        string
+}
+
+// SDPCandidate holds an ICE candidate attribute
+type SDPCandidate struct {
+	Foundation string
+	Component  int
+	Transport  string
+	Priority   uint32
+	Address    string
+	Port       int
+	Type       string
+}
+
+// SDPCrypto holds a SDES crypto attribute
+type SDPCrypto struct {
+	Tag       int
+	Suite     string
+	KeyParams string
+}
+
+// ParseSDP parses a session description
+func ParseSDP(str string) *SDP {
+	s := &SDP{Val: str}
+	s.parse()
+	return s
+}
+
+func (s *SDP) parse() {
+	var m *SDPMedia
+	for _, line := range strings.Split(s.Val, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if len(line) < 2 || line[1] != '=' {
+			continue
+		}
+		v := cleanWs(line[2:])
+		switch line[0] {
+		case 'o':
+			s.parseOrigin(v)
+		case 's':
+			s.Session = v
+		case 'c':
+			if m != nil {
+				m.Connection = parseSDPConnection(v)
+			} else {
+				s.Connection = parseSDPConnection(v)
+			}
+		case 'm':
+			m = parseSDPMedia(v)
+			if m != nil {
+				s.Media = append(s.Media, m)
+			}
+		case 'a':
+			if m != nil {
+				m.addAttr(v)
+			} else {
+				s.addAttr(v)
+			}
+		}
+	}
+	if len(s.Media) == 0 {
+		s.Error = errors.New("ParseSDP err: no media description found")
+		return
+	}
+	for _, m := range s.Media {
+		if m.Connection == nil {
+			m.Connection = s.Connection
+		}
+		if m.Direction == "" {
+			m.Direction = s.Direction
+		}
+		if m.Direction == "" {
+			m.Direction = "sendrecv"
+		}
+		if m.Fingerprint == "" {
+			m.Fingerprint = s.Fingerprint
+		}
+		m.addStaticCodecs()
+	}
+}
+
+func (s *SDP) parseOrigin(v string) {
+	f := strings.Fields(v)
+	if len(f) != 6 {
+		return
+	}
+	s.Origin = &SDPOrigin{
+		Username:       f[0],
+		SessionID:      f[1],
+		SessionVersion: f[2],
+		NetType:        f[3],
+		AddrType:       f[4],
+		Address:        f[5],
+	}
+}
+
+func (s *SDP) addAttr(v string) {
+	name, val := splitAttr(v)
+	switch name {
+	case "sendrecv", "sendonly", "recvonly", "inactive":
+		s.Direction = name
+	case "fingerprint":
+		s.Fingerprint = val
+	}
+}
+
+// Codecs returns the names of the codecs of all media sections
+// without duplicates.
+func (s *SDP) Codecs() []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, m := range s.Media {
+		for _, c := range m.Codecs {
+			if c.Name != "" && !seen[c.Name] {
+				seen[c.Name] = true
+				names = append(names, c.Name)
+			}
+		}
+	}
+	return names
+}
+
+// MediaAddr returns the address and port of the first media section
+// which is not disabled.
+func (s *SDP) MediaAddr() (string, int) {
+	for _, m := range s.Media {
+		if m.Port > 0 && m.Connection != nil {
+			return m.Connection.Address, m.Port
+		}
+	}
+	return "", 0
+}
+
+func parseSDPConnection(v string) *SDPConnection {
+	f := strings.Fields(v)
+	if len(f) != 3 {
+		return nil
+	}
+	c := &SDPConnection{NetType: f[0], AddrType: f[1], Address: f[2]}
+	// multicast addresses carry a ttl and count
+	if i := strings.IndexByte(c.Address, '/'); i > 0 {
+		c.Address = c.Address[:i]
+	}
+	return c
+}
+
+func parseSDPMedia(v string) *SDPMedia {
+	f := strings.Fields(v)
+	if len(f) < 3 {
+		return nil
+	}
+	m := &SDPMedia{Type: f[0], Proto: f[2], Formats: f[3:]}
+	port := f[1]
+	if i := strings.IndexByte(port, '/'); i > 0 {
+		port = port[:i]
+	}
+	m.Port, _ = strconv.Atoi(port)
+	return m
+}
+
+func (m *SDPMedia) addAttr(v string) {
+	name, val := splitAttr(v)
+	switch name {
+	case "sendrecv", "sendonly", "recvonly", "inactive":
+		m.Direction = name
+	case "rtcp":
+		if f := strings.Fields(val); len(f) > 0 {
+			m.RTCPPort, _ = strconv.Atoi(f[0])
+		}
+	case "rtpmap":
+		pt, rest, ok := splitPayloadType(val)
+		if !ok {
+			return
+		}
+		c := m.codec(pt)
+		enc := strings.Split(rest, "/")
+		c.Name = enc[0]
+		if len(enc) > 1 {
+			c.ClockRate, _ = strconv.Atoi(enc[1])
+		}
+		c.Channels = 1
+		if len(enc) > 2 {
+			c.Channels, _ = strconv.Atoi(enc[2])
+		}
+	case "fmtp":
+		if pt, rest, ok := splitPayloadType(val); ok {
+			m.codec(pt).Fmtp = rest
+		}
+	case "candidate":
+		f := strings.Fields(val)
+		if len(f) < 8 || f[6] != "typ" {
+			return
+		}
+		c := &SDPCandidate{
+			Foundation: f[0],
+			Transport:  strings.ToLower(f[2]),
+			Address:    f[4],
+			Type:       f[7],
+		}
+		c.Component, _ = strconv.Atoi(f[1])
+		prio, _ := strconv.ParseUint(f[3], 10, 32)
+		c.Priority = uint32(prio)
+		c.Port, _ = strconv.Atoi(f[5])
+		m.Candidates = append(m.Candidates, c)
+	case "crypto":
+		f := strings.Fields(val)
+		if len(f) < 3 {
+			return
+		}
+		c := &SDPCrypto{Suite: f[1], KeyParams: f[2]}
+		c.Tag, _ = strconv.Atoi(f[0])
+		m.Crypto = append(m.Crypto, c)
+	case "fingerprint":
+		m.Fingerprint = val
+	}
+}
+
+// codec returns the codec of the payload type and adds it if it
+// is not known yet.
+func (m *SDPMedia) codec(pt int) *SDPCodec {
+	for _, c := range m.Codecs {
+		if c.PayloadType == pt {
+			return c
+		}
+	}
+	c := &SDPCodec{PayloadType: pt}
+	m.Codecs = append(m.Codecs, c)
+	return c
+}
+
+// addStaticCodecs names the static payload types without rtpmap and
+// orders the codecs like the formats of the m= line.
+func (m *SDPMedia) addStaticCodecs() {
+	if !strings.Contains(m.Proto, "RTP") {
+		return
+	}
+	codecs := make([]*SDPCodec, 0, len(m.Formats))
+	for _, f := range m.Formats {
+		pt, err := strconv.Atoi(f)
+		if err != nil {
+			continue
+		}
+		c := m.codec(pt)
+		if sc, ok := staticPayloads[pt]; ok && c.Name == "" {
+			c.Name, c.ClockRate, c.Channels = sc.Name, sc.ClockRate, sc.Channels
+		}
+		codecs = append(codecs, c)
+	}
+	m.Codecs = codecs
+}
+
+func splitAttr(v string) (string, string) {
+	if i := strings.IndexByte(v, ':'); i >= 0 {
+		return v[:i], cleanWs(v[i+1:])
+	}
+	return v, ""
+}
+
+func splitPayloadType(v string) (int, string, bool) {
+	i := strings.IndexByte(v, ' ')
+	if i < 1 {
+		return 0, "", false
+	}
+	pt, err := strconv.Atoi(v[:i])
+	if err != nil {
+		return 0, "", false
+	}
+	return pt, cleanWs(v[i+1:]), true
+}
+
+// sdpBody returns the session description of a body with the
+// given content type. Multipart bodies are searched for the
+// application/sdp part.
+func sdpBody(contentType, body string) string {
+	ct := strings.ToLower(contentType)
+	if strings.HasPrefix(ct, "application/sdp") {
+		return body
+	}
+	if !strings.HasPrefix(ct, "multipart/") {
+		return ""
+	}
+	i := strings.Index(ct, "boundary=")
+	if i < 0 {
+		return ""
+	}
+	boundary := contentType[i+len("boundary="):]
+	if end := strings.IndexByte(boundary, ';'); end >= 0 {
+		boundary = boundary[:end]
+	}
+	boundary = "--" + strings.Trim(cleanWs(boundary), "\"")
+	for _, part := range strings.Split(body, boundary) {
+		hdrEnd := strings.Index(part, "\r\n\r\n")
+		if hdrEnd < 0 {
+			continue
+		}
+		for _, h := range strings.Split(part[:hdrEnd], "\r\n") {
+			sp := strings.IndexByte(h, ':')
+			if sp < 0 || !strings.EqualFold(cleanWs(h[:sp]), "Content-Type") {
+				continue
+			}
+			if strings.HasPrefix(strings.ToLower(cleanWs(h[sp+1:])), "application/sdp") {
+				return strings.TrimSuffix(part[hdrEnd+4:], "\r\n")
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,125 @@
+// Copyright 2011, Shelby Ramsey. All rights reserved.
+// Copyright 2018, Eugen Biegler. All rights reserved.
+// Use of this code is governed by a BSD license that can be
+// found in the LICENSE.txt file.
+
+package sipparser
+
+// Imports from the go standard library
+import (
+	"testing"
+)
+
+var testWebRTCSDP = "v=0\r\no=- 4611731400430051336 2 IN IP4 127.0.0.1\r\ns=-\r\nt=0 0\r\na=fingerprint:sha-256 7B:8B:F0:65:5F:78:E2:51:3B:AC:6F:F3:3F:46:1B:35\r\na=sendonly\r\n" +
+	"m=audio 50000 UDP/TLS/RTP/SAVPF 111 0\r\nc=IN IP4 192.0.2.10\r\na=rtcp:50001 IN IP4 192.0.2.10\r\n" +
+	"a=candidate:1467250027 1 udp 2122260223 192.0.2.10 50000 typ host generation 0\r\n" +
+	"a=candidate:435653019 1 udp 1845501695 198.51.100.7 3478 typ srflx raddr 192.0.2.10 rport 50000\r\n" +
+	"a=rtpmap:111 opus/48000/2\r\na=fmtp:111 minptime=10;useinbandfec=1\r\n" +
+	"m=video 0 RTP/SAVP 96\r\na=rtpmap:96 VP8/90000\r\na=inactive\r\n" +
+	"a=crypto:1 AES_CM_128_HMAC_SHA1_80 inline:PS1uQCVeeCFCanVmcjkpPywjNWhcYD0mXXtxaVBR\r\n"
+
+func TestSDP(t *testing.T) {
+	s := ParseSDP(testWebRTCSDP)
+	if s.Error != nil {
+		t.Errorf("[TestSDP] Error parsing sdp. Received: %v", s.Error)
+	}
+	if s.Origin == nil || s.Origin.SessionID != "4611731400430051336" || s.Origin.Address != "127.0.0.1" {
+		t.Errorf("[TestSDP] Error parsing sdp. Origin should have SessionID \"4611731400430051336\" and Address \"127.0.0.1\" but received: %+v", s.Origin)
+	}
+	if s.Session != "-" {
+		t.Errorf("[TestSDP] Error parsing sdp. Session should be \"-\" but received: %s", s.Session)
+	}
+	if s.Connection != nil {
+		t.Errorf("[TestSDP] Error parsing sdp. Session Connection should be nil but received: %+v", s.Connection)
+	}
+	if len(s.Media) != 2 {
+		t.Fatalf("[TestSDP] Error parsing sdp. Should have 2 media but received: %d", len(s.Media))
+	}
+
+	a := s.Media[0]
+	if a.Type != "audio" || a.Port != 50000 || a.Proto != "UDP/TLS/RTP/SAVPF" || a.RTCPPort != 50001 {
+		t.Errorf("[TestSDP] Error parsing audio media line. Received: %s %d %s rtcp %d", a.Type, a.Port, a.Proto, a.RTCPPort)
+	}
+	if a.Connection == nil || a.Connection.Address != "192.0.2.10" {
+		t.Errorf("[TestSDP] Error parsing audio connection. Address should be \"192.0.2.10\" but received: %+v", a.Connection)
+	}
+	if a.Direction != "sendonly" {
+		t.Errorf("[TestSDP] Error parsing audio direction. Should inherit \"sendonly\" but received: %s", a.Direction)
+	}
+	if a.Fingerprint != "sha-256 7B:8B:F0:65:5F:78:E2:51:3B:AC:6F:F3:3F:46:1B:35" {
+		t.Errorf("[TestSDP] Error parsing audio fingerprint. Received: %s", a.Fingerprint)
+	}
+	if len(a.Codecs) != 2 {
+		t.Fatalf("[TestSDP] Error parsing audio codecs. Should have 2 codecs but received: %d", len(a.Codecs))
+	}
+	if c := a.Codecs[0]; c.PayloadType != 111 || c.Name != "opus" || c.ClockRate != 48000 || c.Channels != 2 || c.Fmtp != "minptime=10;useinbandfec=1" {
+		t.Errorf("[TestSDP] Error parsing rtpmap and fmtp of payload 111. Received: %+v", c)
+	}
+	if c := a.Codecs[1]; c.PayloadType != 0 || c.Name != "PCMU" || c.ClockRate != 8000 {
+		t.Errorf("[TestSDP] Error naming static payload 0. Should be \"PCMU\" but received: %+v", c)
+	}
+	if len(a.Candidates) != 2 {
+		t.Fatalf("[TestSDP] Error parsing candidates. Should have 2 candidates but received: %d", len(a.Candidates))
+	}
+	if c := a.Candidates[1]; c.Component != 1 || c.Transport != "udp" || c.Priority != 1845501695 || c.Address != "198.51.100.7" || c.Port != 3478 || c.Type != "srflx" {
+		t.Errorf("[TestSDP] Error parsing srflx candidate. Received: %+v", c)
+	}
+
+	v := s.Media[1]
+	if v.Port != 0 || v.Direction != "inactive" || v.Connection != nil {
+		t.Errorf("[TestSDP] Error parsing disabled video media. Received: port %d direction %s connection %+v", v.Port, v.Direction, v.Connection)
+	}
+	if len(v.Crypto) != 1 || v.Crypto[0].Tag != 1 || v.Crypto[0].Suite != "AES_CM_128_HMAC_SHA1_80" || v.Crypto[0].KeyParams != "inline:PS1uQCVeeCFCanVmcjkpPywjNWhcYD0mXXtxaVBR" {
+		t.Errorf("[TestSDP] Error parsing crypto. Received: %+v", v.Crypto)
+	}
+
+	if c := s.Codecs(); len(c) != 3 || c[0] != "opus" || c[1] != "PCMU" || c[2] != "VP8" {
+		t.Errorf("[TestSDP] Error with Codecs() method. Should be [opus PCMU VP8] but received: %v", c)
+	}
+	if ip, port := s.MediaAddr(); ip != "192.0.2.10" || port != 50000 {
+		t.Errorf("[TestSDP] Error with MediaAddr() method. Should be 192.0.2.10:50000 but received: %s:%d", ip, port)
+	}
+
+	s = ParseSDP("v=0\r\ns=-\r\n")
+	if s.Error == nil {
+		t.Errorf("[TestSDP] Error parsing sdp without media. Should return an error.")
+	}
+}
+
+func TestParseMsgSDP(t *testing.T) {
+	s := ParseMsg(testInviteMsg, nil, nil)
+	if s.sdp != nil {
+		t.Errorf("[TestParseMsgSDP] ParseMsg should leave the sdp unparsed.")
+	}
+	if s.SDP() == nil {
+		t.Fatalf("[TestParseMsgSDP] Error parsing sdp of testInviteMsg. SDP should not be nil.")
+	}
+	if ip, port := s.SDP().MediaAddr(); ip != "X.X.X.X" || port != 17354 {
+		t.Errorf("[TestParseMsgSDP] Error parsing media address. Should be X.X.X.X:17354 but received: %s:%d", ip, port)
+	}
+	if c := s.SDP().Codecs(); len(c) != 5 || c[2] != "G726-32" || c[4] != "telephone-event" {
+		t.Errorf("[TestParseMsgSDP] Error parsing codecs. Received: %v", c)
+	}
+	if d := s.SDP().Media[0].Direction; d != "sendrecv" {
+		t.Errorf("[TestParseMsgSDP] Error parsing direction. Should be \"sendrecv\" but received: %s", d)
+	}
+
+	m := "INVITE sip:bob@example.com SIP/2.0\r\nCall-ID: multi@example.com\r\nContent-Type: multipart/mixed;boundary=unique-boundary-1\r\n\r\n" +
+		"--unique-boundary-1\r\nContent-Type: application/sdp\r\n\r\nv=0\r\nc=IN IP4 192.0.2.4\r\nm=audio 3456 RTP/AVP 8\r\n\r\n" +
+		"--unique-boundary-1\r\nContent-Type: application/isup;version=itu-t92+\r\n\r\n\x01\x00\r\n--unique-boundary-1--\r\n"
+	s = ParseMsg(m, nil, nil)
+	if s.SDP() == nil {
+		t.Fatalf("[TestParseMsgSDP] Error parsing multipart sdp. SDP should not be nil.")
+	}
+	if ip, port := s.SDP().MediaAddr(); ip != "192.0.2.4" || port != 3456 {
+		t.Errorf("[TestParseMsgSDP] Error parsing multipart media address. Should be 192.0.2.4:3456 but received: %s:%d", ip, port)
+	}
+	if c := s.SDP().Codecs(); len(c) != 1 || c[0] != "PCMA" {
+		t.Errorf("[TestParseMsgSDP] Error parsing multipart codecs. Should be [PCMA] but received: %v", c)
+	}
+
+	s = ParseMsg("SIP/2.0 200 OK\r\nCall-ID: x@example.com\r\nContent-Type: text/plain\r\n\r\nm=audio 1 RTP/AVP 0\r\n", nil, nil)
+	if s.SDP() != nil {
+		t.Errorf("[TestParseMsgSDP] Error parsing text/plain body. SDP should be nil.")
+	}
+}
@@ -73,6 +73,39 @@ func (u *URI) Parse() {
 	}
 }
 
+// Params returns the uri parameters after the host part
+func (u *URI) Params() []*Param {
+	if u.atPos > len(u.Raw) {
+		return nil
+	}
+	hp := u.Raw[u.atPos:]
+	if i := strings.IndexRune(hp, '?'); i > -1 {
+		hp = hp[0:i]
+	}
+	semi := strings.IndexRune(hp, ';')
+	if semi == -1 {
+		return nil
+	}
+	var params []*Param
+	for _, p := range strings.Split(hp[semi+1:], ";") {
+		if p != "" {
+			params = append(params, getParam(p))
+		}
+	}
+	return params
+}
+
+// GetParam returns the uri parameter with the given name (case
+// insensitive) or nil
+func (u *URI) GetParam(name string) *Param {
+	for _, p := range u.Params() {
+		if strings.EqualFold(p.Param, name) {
+			return p
+		}
+	}
+	return nil
+}
+
 // parseUri is the for loop that does the actual parsing
 func parseUri(u *URI) uriStateFn {
 	if u.Error == nil {
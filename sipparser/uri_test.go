@@ -53,3 +53,23 @@ func TestUri(t *testing.T) {
 		t.Errorf("[TestUri] Error parsing URI \"sip:myfoo.com\".  Host should be \"myfoo.com\" but received: " + u.Host)
 	}
 }
+
+func TestUriParams(t *testing.T) {
+	u := ParseURI("sip:15555551000;npdi=yes@0.0.0.0:5060;user=phone;Transport=TCP;lr?X-Header=1")
+	if p := u.Params(); len(p) != 3 {
+		t.Errorf("[TestUriParams] Error getting params. Should have 3 params but received: %d", len(p))
+	}
+	if p := u.GetParam("transport"); p == nil || p.Val != "TCP" {
+		t.Errorf("[TestUriParams] Error getting param \"transport\". Val should be \"TCP\".")
+	}
+	if p := u.GetParam("lr"); p == nil || p.Val != "" {
+		t.Errorf("[TestUriParams] Error getting param \"lr\" without value.")
+	}
+	if p := u.GetParam("npdi"); p != nil {
+		t.Errorf("[TestUriParams] Error getting param \"npdi\". User params should not be returned.")
+	}
+	u = ParseURI("sip:10.0.0.1;maddr=10.0.0.2")
+	if p := u.GetParam("maddr"); p == nil || p.Val != "10.0.0.2" {
+		t.Errorf("[TestUriParams] Error getting param \"maddr\" of uri without user.")
+	}
+}
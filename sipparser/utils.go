@@ -126,3 +126,60 @@ func getCommaSeperated(str string) []string {
 	}
 	return s
 }
+
+// getList splits a header value on the commas which are neither
+// quoted nor inside angle brackets and drops empty elements.
+func getList(str string) []string {
+	var list []string
+	quoted := false
+	bracks := 0
+	start := 0
+	for i := 0; i <= len(str); i++ {
+		if i < len(str) {
+			switch str[i] {
+			case '"':
+				quoted = !quoted
+				continue
+			case '<':
+				if !quoted {
+					bracks++
+				}
+				continue
+			case '>':
+				if !quoted && bracks > 0 {
+					bracks--
+				}
+				continue
+			case ',':
+				if quoted || bracks > 0 {
+					continue
+				}
+			default:
+				continue
+			}
+		}
+		if v := cleanWs(str[start:i]); v != "" {
+			list = append(list, v)
+		}
+		start = i + 1
+	}
+	return list
+}
+
+// getRouteURIs parses the name-addr list of a Route or Record-Route
+// header.
+func getRouteURIs(str string) ([]*URI, error) {
+	var uris []*URI
+	for _, v := range getList(str) {
+		left, right, ok := getBracks(v)
+		if !ok {
+			continue
+		}
+		u := ParseURI(v[left+1 : right])
+		if u.Error != nil {
+			return uris, u.Error
+		}
+		uris = append(uris, u)
+	}
+	return uris, nil
+}
@@ -20,8 +20,7 @@ type vias struct {
 }
 
 func (vs *vias) parse() {
-	parts := strings.SplitSeq(vs.via, ",")
-	for p := range parts {
+	for _, p := range getList(vs.via) {
 		v := &Via{Via: p}
 		v.parse()
 		if v.Error != nil {
@@ -47,13 +47,17 @@ func TestMultipleVias(t *testing.T) {
 	if sm.ViaOneBranch != "z9hG4bKea28eb32f60dc" {
 		t.Errorf("[TestMultipleVias] Error parsing via %q. sm.ViaOneBranch should be \"z9hG4bKea28eb32f60dc\" but received %q", s, sm.ViaOneBranch)
 	}
-	/* 	if len(sm.Via) != 2 {
-	   		t.Fatalf("[TestMultipleVias] Error parsing via %q. len(sm.Via) should be 2 but received %d", s, len(sm.Via))
-	   	}
-	   	if sm.Via[0].Branch != "z9hG4bKea28eb32f60dc" {
-	   		t.Errorf("[TestMultipleVias] Error parsing via %q. sm.Via[0].Branch should be \"z9hG4bKea28eb32f60dc\" but received %q", s, sm.Via[0].Branch)
-	   	}
-	   	if sm.Via[1].Branch != "z9hG4bK1750901461" {
-	   		t.Errorf("[TestMultipleVias] Error parsing via %q. sm.Via[1].Branch should be \"z9hG4bK1750901461\" but received %q", s, sm.Via[1].Branch)
-	   	} */
+	s = "SIP/2.0/UDP 0.0.0.0:5060;branch=z9hG4bKea28eb32f60dc,SIP/2.0/UDP 1.1.1.1:5060;branch=z9hG4bK1750901461"
+	if err := sm.parseVias(s); err != nil {
+		t.Fatalf("[TestMultipleVias] Error parsing via %q. Received err: %v", s, err)
+	}
+	if len(sm.Via) != 2 {
+		t.Fatalf("[TestMultipleVias] Error parsing via %q. len(sm.Via) should be 2 but received %d", s, len(sm.Via))
+	}
+	if sm.Via[0].Branch != "z9hG4bKea28eb32f60dc" {
+		t.Errorf("[TestMultipleVias] Error parsing via %q. sm.Via[0].Branch should be \"z9hG4bKea28eb32f60dc\" but received %q", s, sm.Via[0].Branch)
+	}
+	if sm.Via[1].Branch != "z9hG4bK1750901461" {
+		t.Errorf("[TestMultipleVias] Error parsing via %q. sm.Via[1].Branch should be \"z9hG4bK1750901461\" but received %q", s, sm.Via[1].Branch)
+	}
 }
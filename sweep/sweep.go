@@ -0,0 +1,37 @@
+// Package sweep runs the periodic expiry of in-memory state in the
+// background.
+package sweep
+
+import "time"
+
+// Sweeper calls a function on every tick until it is stopped.
+type Sweeper struct {
+	quit chan struct{}
+	done chan struct{}
+}
+
+// Start calls fn with the current time every interval until Stop is
+// called.
+func Start(interval time.Duration, fn func(time.Time)) *Sweeper {
+	s := &Sweeper{quit: make(chan struct{}), done: make(chan struct{})}
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.quit:
+				return
+			case now := <-ticker.C:
+				fn(now)
+			}
+		}
+	}()
+	return s
+}
+
+// Stop ends the sweep and waits until a running fn has returned.
+func (s *Sweeper) Stop() {
+	close(s.quit)
+	<-s.done
+}
@@ -0,0 +1,19 @@
+package sweep
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSweeper(t *testing.T) {
+	var n atomic.Int32
+	s := Start(time.Millisecond, func(time.Time) { n.Add(1) })
+	assert.Eventually(t, func() bool { return n.Load() >= 2 }, time.Second, time.Millisecond)
+	s.Stop()
+	stopped := n.Load()
+	time.Sleep(5 * time.Millisecond)
+	assert.Equal(t, stopped, n.Load(), "no sweep after Stop")
+}